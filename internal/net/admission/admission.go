@@ -0,0 +1,153 @@
+// Package admission decides whether a hub accepts one more connection.
+package admission
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRetryAfter is suggested to rejected clients when Limits.RetryAfter isn't set.
+const DefaultRetryAfter = 10 * time.Second
+
+var (
+	ErrFull           = errors.New("admission: no connection slots left")
+	ErrSpectatorsFull = errors.New("admission: no spectator slots left")
+	ErrIPLimit        = errors.New("admission: too many connections from this address")
+	ErrIdentityLimit  = errors.New("admission: too many connections for this identity")
+)
+
+// Limits bound the connections of a hub, 0 means no limit.
+type Limits struct {
+	// Total is the most connections at once.
+	Total int
+	// Reserved is how much of Total only privileged connections may use,
+	// so a surge of spectators can't lock drivers and race control out.
+	Reserved int
+	// PerIP is the most spectator connections from one address.
+	// Privileged connections are limited per identity instead, a paddock shares its address.
+	PerIP int
+	// PerIdentity is the most connections of one identity.
+	PerIdentity int
+	// RetryAfter is how long rejected clients are told to wait, DefaultRetryAfter if 0.
+	RetryAfter time.Duration
+}
+
+// Request is who is asking to connect.
+type Request struct {
+	IP string
+	// Identity is empty for anonymous clients.
+	Identity string
+	// Privileged connections, drivers and race control, may use the reserved capacity.
+	Privileged bool
+}
+
+// Controller counts the admitted connections. The zero limits admit everyone.
+type Controller struct {
+	mx         *sync.Mutex
+	limits     Limits
+	total      int
+	spectators int
+	ips        map[string]int
+	identities map[string]int
+}
+
+func New(l Limits) *Controller {
+	return &Controller{
+		mx:         &sync.Mutex{},
+		limits:     l,
+		ips:        make(map[string]int),
+		identities: make(map[string]int),
+	}
+}
+
+// SetLimits changes the limits, connections already admitted are kept.
+func (c *Controller) SetLimits(l Limits) {
+	c.mx.Lock()
+	c.limits = l
+	c.mx.Unlock()
+}
+
+func (c *Controller) Limits() Limits {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.limits
+}
+
+// Admit takes a slot for req, release must be called once the connection is gone.
+func (c *Controller) Admit(req Request) (release func(), err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	l := c.limits
+	switch {
+	case l.Total > 0 && c.total >= l.Total:
+		return nil, ErrFull
+	case !req.Privileged && l.Total > 0 && c.spectators >= l.Total-l.Reserved:
+		return nil, ErrSpectatorsFull
+	case req.Identity != "" && l.PerIdentity > 0 && c.identities[req.Identity] >= l.PerIdentity:
+		return nil, ErrIdentityLimit
+	case !req.Privileged && l.PerIP > 0 && c.ips[req.IP] >= l.PerIP:
+		return nil, ErrIPLimit
+	}
+
+	c.total++
+	if req.Identity != "" {
+		c.identities[req.Identity]++
+	}
+	if !req.Privileged {
+		c.spectators++
+		c.ips[req.IP]++
+	}
+
+	once := &sync.Once{}
+	return func() { once.Do(func() { c.release(req) }) }, nil
+}
+
+func (c *Controller) release(req Request) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.total--
+	if req.Identity != "" {
+		if c.identities[req.Identity]--; c.identities[req.Identity] <= 0 {
+			delete(c.identities, req.Identity)
+		}
+	}
+	if !req.Privileged {
+		c.spectators--
+		if c.ips[req.IP]--; c.ips[req.IP] <= 0 {
+			delete(c.ips, req.IP)
+		}
+	}
+}
+
+// Reject answers a request Admit turned down: 503 when the hub is full, 429 when the client has too many connections.
+// Both tell the client when to try again.
+func (c *Controller) Reject(w http.ResponseWriter, err error) {
+	retry := c.Limits().RetryAfter
+	if retry <= 0 {
+		retry = DefaultRetryAfter
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry.Round(time.Second)/time.Second)))
+
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, ErrIPLimit) || errors.Is(err, ErrIdentityLimit) {
+		status = http.StatusTooManyRequests
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// RemoteIP is the address part of a request's RemoteAddr.
+func RemoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
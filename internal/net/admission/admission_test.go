@@ -0,0 +1,50 @@
+package admission
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	c := New(Limits{Total: 4, Reserved: 2, PerIP: 1, PerIdentity: 1})
+
+	spectator := func(ip string) Request { return Request{IP: ip} }
+	driver := func(id string) Request { return Request{IP: "10.0.0.1", Identity: id, Privileged: true} }
+
+	steps := []struct {
+		req  Request
+		want error
+	}{
+		{spectator("1.1.1.1"), nil},
+		{spectator("1.1.1.1"), ErrIPLimit},
+		{spectator("2.2.2.2"), nil},
+		// the rest is reserved
+		{spectator("3.3.3.3"), ErrSpectatorsFull},
+		// drivers share an address
+		{driver("a"), nil},
+		{driver("a"), ErrIdentityLimit},
+		{driver("b"), nil},
+		{driver("c"), ErrFull},
+	}
+
+	var releases []func()
+	for i, step := range steps {
+		release, err := c.Admit(step.req)
+		if !errors.Is(err, step.want) {
+			t.Fatalf("step %d: got %v, want %v", i, err, step.want)
+		}
+		if err == nil {
+			releases = append(releases, release)
+		}
+	}
+
+	// released twice on purpose, the second time must not count
+	releases[0]()
+	releases[0]()
+	if _, err := c.Admit(spectator("3.3.3.3")); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+	if _, err := c.Admit(spectator("4.4.4.4")); !errors.Is(err, ErrFull) {
+		t.Fatalf("got %v, want %v", err, ErrFull)
+	}
+}
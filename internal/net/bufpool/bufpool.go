@@ -0,0 +1,118 @@
+// Package bufpool hands out reusable byte buffers in a few size classes,
+// so reading a message doesn't allocate once the pools are warm.
+package bufpool
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// classes fit a telemetry sample, a typical JSON message and so on, up to the largest envelope
+var classes = [...]int{256, 1 << 10, 4 << 10, 16 << 10, 64<<10 + 16}
+
+var pools [len(classes)]sync.Pool
+
+// Buffer is a pooled byte slice with reference counted ownership.
+// Whoever gets or retains a Buffer owns a reference and must call Release once done with B,
+// the last Release hands it back to the pool and B must not be touched afterwards.
+type Buffer struct {
+	B     []byte
+	class int
+	refs  atomic.Int32
+}
+
+// Get returns a buffer of length n owned by the caller.
+// Sizes above the largest class are allocated and never pooled, n is allocated as asked so callers bound it.
+func Get(n int) *Buffer {
+	for i, size := range classes {
+		if n > size {
+			continue
+		}
+
+		b, ok := pools[i].Get().(*Buffer)
+		if !ok {
+			b = &Buffer{B: make([]byte, size), class: i}
+		}
+		b.B = b.B[:n]
+		b.refs.Store(1)
+
+		return b
+	}
+
+	b := &Buffer{B: make([]byte, n), class: -1}
+	b.refs.Store(1)
+
+	return b
+}
+
+// Retain adds n owners, typically one per subscriber a message is fanned out to.
+func (b *Buffer) Retain(n int) {
+	b.refs.Add(int32(n))
+}
+
+// Release drops a reference.
+func (b *Buffer) Release() {
+	refs := b.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("bufpool: buffer released more than it was retained")
+	}
+
+	if b.class >= 0 {
+		b.B = b.B[:0]
+		pools[b.class].Put(b)
+	}
+}
+
+// ReadAll reads r until EOF into a pooled buffer, sizeHint picks the first class to try.
+// The hint often comes from a peer, it's clamped to the classes and the buffer only grows past them as r is read.
+func ReadAll(r io.Reader, sizeHint int) (*Buffer, error) {
+	b := Get(min(max(sizeHint, 0), classes[len(classes)-1]))
+	b.B = b.B[:0]
+
+	for {
+		if len(b.B) == cap(b.B) {
+			// a full buffer may hold the whole message, check before moving to a bigger class
+			var probe [1]byte
+			n, err := r.Read(probe[:])
+			if n == 0 && err == io.EOF {
+				return b, nil
+			}
+			if err != nil && err != io.EOF {
+				b.Release()
+				return nil, err
+			}
+
+			b = b.grow(len(b.B) + n)
+			b.B = append(b.B, probe[:n]...)
+			continue
+		}
+
+		n, err := r.Read(b.B[len(b.B):cap(b.B)])
+		b.B = b.B[:len(b.B)+n]
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			b.Release()
+			return nil, err
+		}
+	}
+}
+
+// grow moves the content of b into a buffer of the class above, releasing b
+func (b *Buffer) grow(n int) *Buffer {
+	size := 2 * cap(b.B)
+	if size < n {
+		size = n
+	}
+
+	nb := Get(size)
+	nb.B = append(nb.B[:0], b.B...)
+	b.Release()
+
+	return nb
+}
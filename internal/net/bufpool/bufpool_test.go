@@ -0,0 +1,70 @@
+package bufpool_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
+)
+
+func TestReadAll(t *testing.T) {
+	for _, size := range []int{0, 1, 255, 256, 257, 4096, 70_000} {
+		want := bytes.Repeat([]byte{'x'}, size)
+
+		for _, hint := range []int{0, size, 64} {
+			b, err := bufpool.ReadAll(iotest.HalfReader(bytes.NewReader(want)), hint)
+			if err != nil {
+				t.Fatalf("size %d hint %d: %v", size, hint, err)
+			}
+			if !bytes.Equal(b.B, want) {
+				t.Fatalf("size %d hint %d: read %d bytes", size, hint, len(b.B))
+			}
+			b.Release()
+		}
+	}
+}
+
+// a hint bigger than anything that can be allocated or negative, as a peer may claim, is only a hint
+func TestReadAllHint(t *testing.T) {
+	want := []byte("sample")
+	for _, hint := range []int{1 << 62, -1} {
+		b, err := bufpool.ReadAll(bytes.NewReader(want), hint)
+		if err != nil {
+			t.Fatalf("hint %d: %v", hint, err)
+		}
+		if !bytes.Equal(b.B, want) {
+			t.Fatalf("hint %d: read %q", hint, b.B)
+		}
+		b.Release()
+	}
+}
+
+// BenchmarkReadAll reads a 120 Hz telemetry sized message, as the hubs do for every frame.
+func BenchmarkReadAll(b *testing.B) {
+	msg := bytes.Repeat([]byte{'x'}, 64)
+	r := bytes.NewReader(msg)
+
+	b.Run("io.ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			r.Reset(msg)
+			if _, err := io.ReadAll(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bufpool", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			r.Reset(msg)
+			buf, err := bufpool.ReadAll(r, len(msg))
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf.Release()
+		}
+	})
+}
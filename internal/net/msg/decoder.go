@@ -1 +1,64 @@
 package msg
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+func (s *Sample) UnmarshalBinary(bs []byte) error {
+	if len(bs) < sampleSize {
+		return errors.New("sample too short")
+	}
+
+	srcLen := int(bs[25])
+	if len(bs) != sampleSize+srcLen {
+		return errors.New("invalid sample size")
+	}
+
+	s.Seq = binary.BigEndian.Uint32(bs[0:4])
+	s.Time = int64(binary.BigEndian.Uint64(bs[4:12]))
+	s.Location.X = math.Float32frombits(binary.BigEndian.Uint32(bs[12:16]))
+	s.Location.Y = math.Float32frombits(binary.BigEndian.Uint32(bs[16:20]))
+	s.Car.SteeringWheelRotation = int16(binary.BigEndian.Uint16(bs[20:22]))
+	s.Car.Gas = bs[22]
+	s.Car.Brake = bs[23]
+	s.Car.Gear = int8(bs[24])
+	s.Source = string(bs[sampleSize:])
+
+	return nil
+}
+
+// Decode unwraps an envelope.
+func Decode(bs []byte) (*Envelope, error) {
+	e := &Envelope{}
+	if err := e.UnmarshalBinary(bs); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// DecodeAll splits envelopes written back to back, as in a batch.
+func DecodeAll(bs []byte) ([]*Envelope, error) {
+	var es []*Envelope
+	for len(bs) > 0 {
+		if len(bs) < 4 {
+			return nil, errors.New("missing header")
+		}
+
+		n := 4 + int(binary.BigEndian.Uint16(bs[2:4]))
+		if len(bs) < n {
+			return nil, errors.New("truncated payload")
+		}
+
+		e, err := Decode(bs[:n])
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+		bs = bs[n:]
+	}
+
+	return es, nil
+}
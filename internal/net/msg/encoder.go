@@ -1 +1,46 @@
 package msg
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// sampleSize is the encoded size of a Sample without its source
+const sampleSize = 4 + 8 + 4 + 4 + 2 + 1 + 1 + 1 + 1
+
+func (s *Sample) MarshalBinary() ([]byte, error) {
+	if len(s.Source) > math.MaxUint8 {
+		return nil, errors.New("source too long")
+	}
+
+	bs := make([]byte, sampleSize, sampleSize+len(s.Source))
+	binary.BigEndian.PutUint32(bs[0:4], s.Seq)
+	binary.BigEndian.PutUint64(bs[4:12], uint64(s.Time))
+	binary.BigEndian.PutUint32(bs[12:16], math.Float32bits(s.Location.X))
+	binary.BigEndian.PutUint32(bs[16:20], math.Float32bits(s.Location.Y))
+	binary.BigEndian.PutUint16(bs[20:22], uint16(s.Car.SteeringWheelRotation))
+	bs[22] = s.Car.Gas
+	bs[23] = s.Car.Brake
+	bs[24] = byte(s.Car.Gear)
+	bs[25] = byte(len(s.Source))
+	bs = append(bs, s.Source...)
+
+	return bs, nil
+}
+
+// Encode wraps payload in a V1 envelope of type typ.
+func Encode(typ MsgType, payload []byte) ([]byte, error) {
+	e := &Envelope{Ver: V1, Typ: typ, Payload: payload}
+	return e.MarshalBinary()
+}
+
+// EncodeSample encodes s in a binary envelope.
+func EncodeSample(s *Sample) ([]byte, error) {
+	p, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return Encode(Binary, p)
+}
@@ -0,0 +1,55 @@
+package msg
+
+import "encoding/json"
+
+// MessageType tells what a JSON envelope carries.
+type MessageType string
+
+const (
+	RaceState   MessageType = "race_state"
+	Leaderboard MessageType = "leaderboard"
+	// EventState tells an event moved to another state of its lifecycle.
+	EventState MessageType = "event_state"
+	// Snapshot follows the replayed state sent to a new subscriber, live messages come after it.
+	Snapshot MessageType = "snapshot"
+	// Gap tells subscribers samples of a source were lost, see GapData.
+	Gap MessageType = "gap"
+	// TimeRequest and TimeResponse synchronise clocks, see ClockData.
+	// They are answered by the hub and never reach other subscribers.
+	TimeRequest  MessageType = "time_request"
+	TimeResponse MessageType = "time_response"
+)
+
+// Message is the payload of JSON envelopes.
+type Message struct {
+	Type MessageType `json:"type"`
+	// Source is the publisher of the message, stamped by the hub.
+	Source string          `json:"source,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// EncodeMessage encodes m in a JSON envelope.
+func EncodeMessage(m *Message) ([]byte, error) {
+	p, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return Encode(JSON, p)
+}
+
+// GapData is the data of a Gap message: samples From through To of the message's source never arrived.
+type GapData struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+// ClockData is the data of TimeRequest and TimeResponse messages, all times are unix nanoseconds.
+// The requester sets T0 when sending, the responder echoes it with T1 when the request arrived
+// and T2 when the response left. With T3 when the response arrived, the responder's clock is ahead by
+// ((T1-T0)+(T2-T3))/2 and the round trip took (T3-T0)-(T2-T1).
+type ClockData struct {
+	T0 int64 `json:"t0"`
+	T1 int64 `json:"t1,omitempty"`
+	T2 int64 `json:"t2,omitempty"`
+}
@@ -20,6 +20,9 @@ const (
 	JSON   MsgType = 0x3
 )
 
+// MaxSize is the size of the largest envelope, a header and a payload whose length fits in 16 bits.
+const MaxSize = 4 + 0xFFFF
+
 type Envelope struct {
 	Ver     Version
 	Typ     MsgType
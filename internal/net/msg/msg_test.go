@@ -1 +1,38 @@
 package msg_test
+
+import (
+	"testing"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+func TestSampleRoundTrip(t *testing.T) {
+	in := &msg.Sample{
+		Source:   "car-7",
+		Seq:      42,
+		Time:     1_700_000_000_000_000_000,
+		Location: msg.Location{X: 10.5, Y: -3.25},
+		Car:      msg.CarState{SteeringWheelRotation: -90, Gas: 80, Brake: 5, Gear: -1},
+	}
+
+	bs, err := msg.EncodeSample(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := msg.Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Typ != msg.Binary {
+		t.Fatalf("got envelope type %d, want %d", e.Typ, msg.Binary)
+	}
+
+	var out msg.Sample
+	if err := out.UnmarshalBinary(e.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if out != *in {
+		t.Fatalf("got %+v, want %+v", out, *in)
+	}
+}
@@ -0,0 +1,26 @@
+package msg
+
+// Sample is a single telemetry reading published by a car.
+// JSON names follow the recorded MOCK_DATA.json shape.
+type Sample struct {
+	// Source is the publisher the sample belongs to, stamped by the hub.
+	Source string `json:"source,omitempty"`
+	// Seq increases by one for every sample of a source.
+	Seq uint32 `json:"seq"`
+	// Time is when the sample was taken, in unix nanoseconds.
+	Time     int64    `json:"time"`
+	Location Location `json:"location"`
+	Car      CarState `json:"car"`
+}
+
+type Location struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+type CarState struct {
+	SteeringWheelRotation int16 `json:"steering_wheel_rotation"`
+	Gas                   uint8 `json:"gas"`
+	Brake                 uint8 `json:"brake"`
+	Gear                  int8  `json:"gear"`
+}
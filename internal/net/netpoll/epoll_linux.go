@@ -0,0 +1,175 @@
+//go:build linux
+
+package netpoll
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+const maxEvents = 128
+
+// Poller watches file descriptors with epoll.
+// Watches are one-shot: after a callback fires the descriptor stays quiet until Resume,
+// so a descriptor is never handled by two callbacks at once.
+type Poller struct {
+	epfd int
+	// wake is a pipe whose read end is watched to interrupt epoll_wait on Close
+	wake      [2]int
+	mx        *sync.Mutex
+	callbacks map[int]func(Event)
+	closed    bool
+}
+
+func New() (*Poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Poller{
+		epfd:      epfd,
+		mx:        &sync.Mutex{},
+		callbacks: make(map[int]func(Event)),
+	}
+
+	if err := syscall.Pipe2(p.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p.wake[0], &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(p.wake[0]),
+	}); err != nil {
+		p.closeFds()
+		return nil, err
+	}
+
+	go p.wait()
+
+	return p, nil
+}
+
+// Start watches fd and calls cb from the poller's goroutine once it is readable or broken.
+// cb must not block, hand the work to another goroutine and call Resume when done.
+func (p *Poller) Start(fd int, cb func(Event)) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.closed {
+		return errors.New("netpoll: poller closed")
+	}
+
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, watchEvent(fd)); err != nil {
+		return err
+	}
+	p.callbacks[fd] = cb
+
+	return nil
+}
+
+// Resume re-arms fd after its callback fired.
+func (p *Poller) Resume(fd int) error {
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, watchEvent(fd))
+}
+
+// Stop stops watching fd. It must be called before the connection is closed.
+func (p *Poller) Stop(fd int) error {
+	p.mx.Lock()
+	delete(p.callbacks, fd)
+	p.mx.Unlock()
+
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// Close stops the poller, watched descriptors are left open.
+func (p *Poller) Close() error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	_, err := syscall.Write(p.wake[1], []byte{0})
+	return err
+}
+
+func (p *Poller) wait() {
+	defer p.closeFds()
+
+	events := make([]syscall.EpollEvent, maxEvents)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return
+		}
+
+		for _, ev := range events[:n] {
+			fd := int(ev.Fd)
+			if fd == p.wake[0] {
+				return
+			}
+
+			p.mx.Lock()
+			cb := p.callbacks[fd]
+			p.mx.Unlock()
+			if cb == nil {
+				continue
+			}
+
+			var e Event
+			if ev.Events&syscall.EPOLLIN != 0 {
+				e |= EventRead
+			}
+			if ev.Events&(syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				e |= EventHup
+			}
+			cb(e)
+		}
+	}
+}
+
+func (p *Poller) closeFds() {
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+	syscall.Close(p.epfd)
+}
+
+// Read reads what has arrived on rc into p without waiting for more, ErrWouldBlock if nothing has.
+// It returns 0 and no error once the peer closed its side.
+func Read(rc syscall.RawConn, p []byte) (n int, err error) {
+	cerr := rc.Read(func(fd uintptr) bool {
+		for {
+			n, err = syscall.Read(int(fd), p)
+			if !errors.Is(err, syscall.EINTR) {
+				// done either way, the runtime mustn't park the caller until the descriptor is readable
+				return true
+			}
+		}
+	})
+	if cerr != nil {
+		return 0, cerr
+	}
+	if errors.Is(err, syscall.EAGAIN) {
+		return 0, ErrWouldBlock
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func watchEvent(fd int) *syscall.EpollEvent {
+	return &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	}
+}
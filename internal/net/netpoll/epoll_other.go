@@ -0,0 +1,22 @@
+//go:build !linux
+
+package netpoll
+
+import "syscall"
+
+// Poller is only implemented on Linux, New always fails elsewhere.
+type Poller struct{}
+
+func New() (*Poller, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *Poller) Start(fd int, cb func(Event)) error { return ErrNotSupported }
+
+func (p *Poller) Resume(fd int) error { return ErrNotSupported }
+
+func (p *Poller) Stop(fd int) error { return ErrNotSupported }
+
+func (p *Poller) Close() error { return nil }
+
+func Read(rc syscall.RawConn, p []byte) (int, error) { return 0, ErrNotSupported }
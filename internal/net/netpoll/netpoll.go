@@ -0,0 +1,50 @@
+// Package netpoll tells when connections become readable without a goroutine blocked on each of them.
+package netpoll
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var (
+	ErrNotSupported = errors.New("netpoll: not supported")
+	// ErrWouldBlock is returned by Read when nothing has arrived, the poller tells when something does.
+	ErrWouldBlock = errors.New("netpoll: read would block")
+)
+
+// Event is what happened on a watched connection.
+type Event uint8
+
+const (
+	// EventRead means the connection has data to read.
+	EventRead Event = 1 << iota
+	// EventHup means the peer hung up or the connection broke, reading will fail.
+	EventHup
+)
+
+// Raw returns the descriptor of nc for Read.
+func Raw(nc net.Conn) (syscall.RawConn, error) {
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	return sc.SyscallConn()
+}
+
+// Fd returns the file descriptor of nc without duplicating it,
+// so the runtime keeps owning it and reads through nc stay non-blocking.
+func Fd(nc net.Conn) (int, error) {
+	rc, err := Raw(nc)
+	if err != nil {
+		return 0, err
+	}
+
+	var fd int
+	if err := rc.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return 0, err
+	}
+
+	return fd, nil
+}
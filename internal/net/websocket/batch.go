@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// Largest batch written at once, a batch this big is flushed before its interval is up.
+const maxBatchSize = 64 << 10
+
+// writeBatches coalesces queued messages into one websocket message per flush interval.
+// Envelopes carry their own length, so they are simply written back to back and
+// msg.DecodeAll splits them again. Messages forwarded as they were read might not be
+// envelopes, those are written on their own, after the batch queued before them.
+func (s *subscriber) writeBatches(ctx context.Context) error {
+	ticker := time.NewTicker(s.flush)
+	defer ticker.Stop()
+
+	batch := make([]byte, 0, 4<<10)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.write(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		select {
+		case m, ok := <-s.send:
+			if !ok {
+				return flush()
+			}
+
+			if m.buf != nil {
+				if err := flush(); err != nil {
+					m.buf.Release()
+					return err
+				}
+				err := s.write(ctx, m.data)
+				m.buf.Release()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if len(batch)+len(m.data) > maxBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, m.data...)
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// messages published within one flush interval reach the subscriber as one websocket message,
+// without an interval each is written on its own
+func TestFlushInterval(t *testing.T) {
+	const published = 3
+
+	tests := []struct {
+		name  string
+		flush time.Duration
+		want  int
+	}{
+		{"batched", 500 * time.Millisecond, 1},
+		{"immediate", 0, published},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h.Serve(w, r, Subscription{Topic: DefaultTopic, FlushInterval: tt.flush})
+			}))
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.CloseNow()
+
+			// the snapshot marker tells the connection joined, it's written before any batch
+			if _, _, err := conn.Read(ctx); err != nil {
+				t.Fatal(err)
+			}
+			for i := range published {
+				data, err := json.Marshal(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := h.Publish(DefaultTopic, &msg.Message{Type: "test", Data: data}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			messages, envelopes := 0, 0
+			for envelopes < published {
+				_, bs, err := conn.Read(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				es, err := msg.DecodeAll(bs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				n := 0
+				for _, e := range es {
+					var m msg.Message
+					if err := json.Unmarshal(e.Payload, &m); err != nil {
+						t.Fatal(err)
+					}
+					// the hub syncs the client's clock meanwhile, straight to the connection
+					if m.Type != "test" {
+						continue
+					}
+					if want := strconv.Itoa(envelopes); string(m.Data) != want {
+						t.Errorf("got %s, want %s", m.Data, want)
+					}
+					envelopes++
+					n++
+				}
+				if n > 0 {
+					messages++
+				}
+			}
+
+			if messages != tt.want {
+				t.Errorf("%d messages published arrived in %d websocket messages, want %d", published, messages, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+const (
+	// How often the clock of a client is measured once the first estimate is in.
+	clockSyncPeriod = 15 * time.Second
+	// Measurements taken right after connecting, a second apart, for a quick first estimate.
+	clockWarmup = 4
+	// Number of measurements kept to estimate offset and drift.
+	clockWindow = 16
+	// Drifts beyond this many parts per million are taken for measurement noise.
+	maxDrift = 500e-6
+	// Responses to requests older than this are ignored.
+	maxRoundTrip = 10 * time.Second
+	// Measurements slower than twice the fastest one plus this are left out of the drift, a congested way skews them.
+	clockJitter = 5 * time.Millisecond
+)
+
+/*
+clock synchronisation
+
+- the hub sends TimeRequest messages to every client, straight to the connection so no batching delays them
+- the client answers with a TimeResponse, its receive and send times around the request's
+- every exchange measures the client's offset, the one with the shortest round trip is the most accurate
+- the drift is the slope of the offsets over time, so samples can be rebased between measurements
+- slow round trips are usually slow one way only, their offsets are off by up to half of it and are left out of the drift
+- clients can send TimeRequests too, to learn the server's time
+*/
+
+type clockSample struct {
+	at     int64 // server time the offset was measured at
+	offset int64 // client time minus server time
+	delay  int64
+}
+
+// clock estimates how far a client's clock is from the server's
+type clock struct {
+	mx      *sync.Mutex
+	samples []clockSample
+	// estimate: the client was offset ahead at server time at, and gains drift ns per ns since
+	at, offset int64
+	drift      float64
+	synced     bool
+}
+
+func newClock() *clock {
+	return &clock{mx: &sync.Mutex{}}
+}
+
+// measure records an exchange started by the server, t3 is when the response arrived
+func (c *clock) measure(d msg.ClockData, t3 int64) {
+	delay := (t3 - d.T0) - (d.T2 - d.T1)
+	if d.T0 <= 0 || d.T0 > t3 || t3-d.T0 > int64(maxRoundTrip) || delay < 0 {
+		return
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.samples = append(c.samples, clockSample{
+		at:     d.T0 + (t3-d.T0)/2,
+		offset: ((d.T1 - d.T0) + (d.T2 - t3)) / 2,
+		delay:  delay,
+	})
+	if len(c.samples) > clockWindow {
+		c.samples = slices.Delete(c.samples, 0, len(c.samples)-clockWindow)
+	}
+	c.estimate()
+}
+
+// estimate anchors the offset on the most accurate of the recent samples and fits the drift over the accurate ones
+func (c *clock) estimate() {
+	recent := c.samples[max(0, len(c.samples)-clockWindow/2):]
+	best := slices.MinFunc(recent, func(a, b clockSample) int {
+		return int(a.delay - b.delay)
+	})
+	c.at, c.offset, c.synced = best.at, best.offset, true
+
+	c.drift = 0
+	fastest := slices.MinFunc(c.samples, func(a, b clockSample) int {
+		return int(a.delay - b.delay)
+	})
+	limit := 2*fastest.delay + int64(clockJitter)
+	accurate := slices.DeleteFunc(slices.Clone(c.samples), func(s clockSample) bool {
+		return s.delay > limit
+	})
+	if len(accurate) < 2 {
+		return
+	}
+	// least squares, relative to the first sample to keep the numbers small
+	first := accurate[0]
+	var n, sx, sy, sxx, sxy float64
+	for _, s := range accurate {
+		x, y := float64(s.at-first.at), float64(s.offset-first.offset)
+		n++
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	if den := n*sxx - sx*sx; den > 0 {
+		c.drift = max(-maxDrift, min(maxDrift, (n*sxy-sx*sy)/den))
+	}
+}
+
+// toServer rebases a client timestamp onto server time, unchanged until the clock is measured
+func (c *clock) toServer(t int64) int64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if !c.synced {
+		return t
+	}
+
+	// the drift is small enough for the client time to stand in for server time here
+	offset := c.offset + int64(c.drift*float64(t-c.offset-c.at))
+	return t - offset
+}
+
+// Clock is the estimated clock of a client.
+type Clock struct {
+	Synced bool
+	// Offset is how far ahead of the server the client's clock is, negative if behind.
+	Offset time.Duration
+	// Drift is how much faster than the server's the client's clock runs, in parts per million.
+	Drift float64
+}
+
+func (c *clock) info() Clock {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return Clock{Synced: c.synced, Offset: time.Duration(c.offset), Drift: c.drift * 1e6}
+}
+
+// syncClock measures the client's clock until ctx is done
+func (s *subscriber) syncClock(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		if i == clockWarmup {
+			ticker.Reset(clockSyncPeriod)
+		}
+
+		if err := s.writeClock(ctx, msg.TimeRequest, msg.ClockData{T0: time.Now().UnixNano()}); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleClock takes care of a clock message from the client, received at t
+func (s *subscriber) handleClock(ctx context.Context, m *msg.Message, t time.Time) error {
+	var d msg.ClockData
+	if err := json.Unmarshal(m.Data, &d); err != nil {
+		return nil
+	}
+
+	switch m.Type {
+	case msg.TimeRequest:
+		d.T1 = t.UnixNano()
+		d.T2 = time.Now().UnixNano()
+		return s.writeClock(ctx, msg.TimeResponse, d)
+	case msg.TimeResponse:
+		s.clock.measure(d, t.UnixNano())
+	}
+
+	return nil
+}
+
+// writeClock writes to the connection directly, queued behind other messages the times would be off
+func (s *subscriber) writeClock(ctx context.Context, typ msg.MessageType, d msg.ClockData) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	bs, err := msg.EncodeMessage(&msg.Message{Type: typ, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return s.write(ctx, bs)
+}
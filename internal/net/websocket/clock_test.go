@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// exchange is a clock measurement, started by the server at the given time since the first one
+type exchange struct {
+	at       time.Duration
+	up, down time.Duration
+}
+
+// simulate runs exchanges against a client whose clock is offset ahead of the server's and gains drift ns per ns
+func simulate(c *clock, start int64, offset time.Duration, drift float64, exchanges []exchange) {
+	client := func(t int64) int64 {
+		return t + int64(offset) + int64(drift*float64(t-start))
+	}
+	for _, e := range exchanges {
+		t0 := start + int64(e.at)
+		t1 := t0 + int64(e.up)
+		t2 := t1 + int64(time.Millisecond) // the client takes a moment to answer
+		t3 := t2 + int64(e.down)
+		c.measure(msg.ClockData{T0: t0, T1: client(t1), T2: client(t2)}, t3)
+	}
+}
+
+// every exchanges n exchanges a second apart taking rtt split evenly each way
+func every(n int, rtt time.Duration) []exchange {
+	out := make([]exchange, n)
+	for i := range out {
+		out[i] = exchange{at: time.Duration(i) * time.Second, up: rtt / 2, down: rtt / 2}
+	}
+	return out
+}
+
+func TestClock(t *testing.T) {
+	start := time.Now().UnixNano()
+
+	// slow is a congested round trip, most of it on the way up
+	slow := exchange{up: 400 * time.Millisecond, down: 10 * time.Millisecond}
+	withOutliers := every(clockWindow, 40*time.Millisecond)
+	for i := range withOutliers {
+		if i%3 != 0 {
+			withOutliers[i].up, withOutliers[i].down = slow.up, slow.down
+		}
+	}
+	asymmetric := every(clockWindow, 0)
+	for i := range asymmetric {
+		asymmetric[i].up, asymmetric[i].down = 30*time.Millisecond, 10*time.Millisecond
+	}
+
+	tests := []struct {
+		name      string
+		offset    time.Duration
+		drift     float64
+		exchanges []exchange
+		// the offset is expected within tolerance of the true one at the last exchange, drift within 1 ppm
+		tolerance time.Duration
+		wantDrift float64
+	}{
+		{"ahead", 3 * time.Second, 0, every(clockWarmup, 20*time.Millisecond), 0, 0},
+		{"behind", -250 * time.Millisecond, 0, every(clockWarmup, 20*time.Millisecond), 0, 0},
+		{"single exchange", time.Second, 0, every(1, 20*time.Millisecond), 0, 0},
+		{"slow round trips left out", time.Second, 0, withOutliers, 0, 0},
+		// half the difference between the ways can't be told from an offset
+		{"asymmetric round trip", time.Second, 0, asymmetric, 10 * time.Millisecond, 0},
+		{"drifting", time.Second, 100e-6, every(clockWindow, 20*time.Millisecond), time.Millisecond, 100},
+		{"drift beyond noise", time.Second, 0.01, every(clockWindow, 20*time.Millisecond), 0, maxDrift * 1e6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			simulate(c, start, tt.offset, tt.drift, tt.exchanges)
+
+			info := c.info()
+			if !info.Synced {
+				t.Fatal("not synced")
+			}
+			if math.Abs(info.Drift-tt.wantDrift) > 1 {
+				t.Errorf("drift %.1f ppm, want %.1f", info.Drift, tt.wantDrift)
+			}
+
+			// a client timestamp taken at the last exchange is rebased onto when it happened
+			last := start + int64(tt.exchanges[len(tt.exchanges)-1].at)
+			clientTime := last + int64(tt.offset) + int64(tt.drift*float64(last-start))
+			if tt.drift > maxDrift {
+				// the clamped drift can't follow the client, only the anchor is checked
+				return
+			}
+			if d := time.Duration(c.toServer(clientTime) - last); d.Abs() > tt.tolerance+time.Millisecond {
+				t.Errorf("rebased %v off", d)
+			}
+		})
+	}
+}
+
+func TestClockIgnores(t *testing.T) {
+	now := time.Now().UnixNano()
+
+	tests := []struct {
+		name string
+		d    msg.ClockData
+		t3   int64
+	}{
+		{"no request time", msg.ClockData{T1: now, T2: now}, now},
+		{"request from the future", msg.ClockData{T0: now + 1, T1: now, T2: now}, now},
+		{"stale request", msg.ClockData{T0: now - int64(maxRoundTrip) - 1, T1: now, T2: now}, now},
+		// the client claims to have taken longer to answer than the whole round trip
+		{"negative round trip", msg.ClockData{T0: now - 10, T1: now - 100, T2: now}, now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			c.measure(tt.d, tt.t3)
+			if c.info().Synced {
+				t.Error("measured")
+			}
+			if got := c.toServer(now); got != now {
+				t.Errorf("unsynced clock rebased %d to %d", now, got)
+			}
+		})
+	}
+}
+
+// the oldest measurements leave the window, a client whose clock jumped is followed
+func TestClockWindow(t *testing.T) {
+	start := time.Now().UnixNano()
+	c := newClock()
+	simulate(c, start, time.Second, 0, every(clockWindow, 20*time.Millisecond))
+	later := make([]exchange, clockWindow)
+	for i := range later {
+		later[i] = exchange{at: time.Duration(clockWindow+i) * time.Second, up: 10 * time.Millisecond, down: 10 * time.Millisecond}
+	}
+	simulate(c, start, 2*time.Second, 0, later)
+
+	if len(c.samples) != clockWindow {
+		t.Fatalf("kept %d samples", len(c.samples))
+	}
+	if info := c.info(); (info.Offset - 2*time.Second).Abs() > time.Millisecond {
+		t.Errorf("offset %v after the jump", info.Offset)
+	}
+}
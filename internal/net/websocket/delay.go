@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"log"
+	"path"
+	"time"
+)
+
+// Number of messages a delayed topic holds before dropping the oldest.
+// Drops are logged and counted in TopicInfo.DelayedDropped.
+const maxDelayed = 1 << 16
+
+/*
+spectator delay
+
+- a topic with a delay sends its messages live to authenticated drivers and race control only
+- every message is also kept in a buffer with the time it was delivered
+- the hub's ticker releases the messages that are old enough to the spectators
+- spectators catch up from a snapshot of what was released, not of the live topic
+*/
+
+type delayed struct {
+	at time.Time
+	m  *message
+}
+
+// SetDelay holds back what spectators receive on the topics matching pattern (path.Match syntax) by d,
+// e.g. "events/<id>/*" with 30s keeps competitors from watching the live stream.
+// Authenticated drivers and race control are not delayed, a role the client declares itself is. A delay of 0 removes it.
+func (h *Hub) SetDelay(pattern string, d time.Duration) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	h.tasks <- func() error {
+		if d > 0 {
+			h.delays[pattern] = d
+		} else {
+			delete(h.delays, pattern)
+		}
+
+		now := time.Now()
+		for name, t := range h.topics {
+			t.setDelay(h.delay(name), now)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// delay returns the longest delay matching topic.
+// It must run on the hub's goroutine.
+func (h *Hub) delay(topic string) time.Duration {
+	var d time.Duration
+	for pattern, pd := range h.delays {
+		if ok, _ := path.Match(pattern, topic); ok {
+			d = max(d, pd)
+		}
+	}
+
+	return d
+}
+
+// delays tells if s gets the topic's messages late
+func (t *topic) delays(s *subscriber) bool {
+	return t.delay > 0 && !s.identity.privileged()
+}
+
+func (t *topic) setDelay(d time.Duration, now time.Time) {
+	if d == t.delay {
+		return
+	}
+	if t.delay == 0 {
+		// spectators start from what they already had
+		for k, data := range t.latest {
+			t.latestDelayed[k] = data
+		}
+	}
+	t.delay = d
+	// without a delay everything held back is due, and has to go out before live messages do
+	t.release(now)
+}
+
+// hold keeps m for the spectators until the delay is over
+func (t *topic) hold(m *message, now time.Time) {
+	if len(t.delayed) == maxDelayed {
+		old := t.delayed[0].m
+		t.delayed[0] = delayed{}
+		t.delayed = t.delayed[1:]
+		// spectators miss it, their snapshot doesn't
+		t.snapshotDelayed(old)
+		if old.buf != nil {
+			old.buf.Release()
+		}
+		t.dropped++
+		if t.dropped%maxDelayed == 1 {
+			log.Printf("topic %q: more than %d messages held for spectators, %d dropped so far", t.name, maxDelayed, t.dropped)
+		}
+	}
+	if m.buf != nil {
+		m.buf.Retain(1)
+	}
+	t.delayed = append(t.delayed, delayed{at: now, m: m})
+}
+
+// release sends the held messages whose delay is over to the spectators.
+// It must run on the hub's goroutine.
+func (t *topic) release(now time.Time) {
+	i := 0
+	for ; i < len(t.delayed) && now.Sub(t.delayed[i].at) >= t.delay; i++ {
+		m := t.delayed[i].m
+		t.delayed[i] = delayed{}
+		t.snapshotDelayed(m)
+		if m.gone != "" {
+			continue
+		}
+		for s := range t.subs {
+			if !s.identity.privileged() {
+				t.send(s, m, now)
+			}
+		}
+		if m.buf != nil {
+			m.buf.Release()
+		}
+	}
+	t.delayed = t.delayed[i:]
+}
+
+// snapshotDelayed applies a message spectators are done waiting for to their snapshot
+func (t *topic) snapshotDelayed(m *message) {
+	switch {
+	case m.gone != "":
+		delete(t.latestDelayed, latestKey{source: m.gone, kind: sampleKind})
+	case m.key != nil:
+		t.latestDelayed[*m.key] = m.data
+	}
+}
+
+// drop lets go of the held messages of a topic nobody is left on
+func (t *topic) drop() {
+	for _, d := range t.delayed {
+		if d.m.buf != nil {
+			d.m.buf.Release()
+		}
+	}
+	t.delayed = nil
+}
@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoldDropsOldest(t *testing.T) {
+	tp := newTopic("t")
+	tp.delay = time.Minute
+	now := time.Now()
+
+	key := &latestKey{kind: "race_state"}
+	tp.hold(&message{topic: "t", key: key, data: []byte("first")}, now)
+	for range maxDelayed {
+		tp.hold(&message{topic: "t", data: []byte("sample")}, now)
+	}
+
+	if tp.dropped != 1 || len(tp.delayed) != maxDelayed {
+		t.Fatalf("dropped %d, holding %d", tp.dropped, len(tp.delayed))
+	}
+	// the dropped message still counts for spectators catching up
+	if got := string(tp.latestDelayed[*key]); got != "first" {
+		t.Errorf("delayed snapshot has %q", got)
+	}
+}
+
+func TestDelays(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity Identity
+		want     bool
+	}{
+		{"spectator", Identity{Role: RoleSpectator}, true},
+		{"declared driver", Identity{Role: RoleDriver}, true},
+		{"declared race control", Identity{Role: RoleRaceControl}, true},
+		{"authenticated spectator", Identity{Role: RoleSpectator, Authenticated: true}, true},
+		{"authenticated driver", Identity{Role: RoleDriver, Authenticated: true}, false},
+		{"authenticated race control", Identity{Role: RoleRaceControl, Authenticated: true}, false},
+	}
+
+	tp := newTopic("t")
+	tp.delay = time.Minute
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tp.delays(&subscriber{identity: tt.identity}); got != tt.want {
+				t.Errorf("delayed: %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
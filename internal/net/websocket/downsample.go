@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"math"
+	"time"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// Downsampling is how the samples of a source are reduced to a subscriber's MaxRate.
+type Downsampling string
+
+const (
+	// DownsampleDecimate sends the first sample of every interval and drops the rest.
+	DownsampleDecimate Downsampling = "decimate"
+	// DownsampleAverage sends the average of the samples of every interval,
+	// it goes out with the first sample past the interval.
+	DownsampleAverage Downsampling = "average"
+)
+
+func (d Downsampling) Valid() bool {
+	return d == DownsampleDecimate || d == DownsampleAverage
+}
+
+// downsampler limits the samples of every source sent to a subscriber.
+// It's only used on the hub's goroutine.
+type downsampler struct {
+	interval time.Duration
+	mode     Downsampling
+	sources  map[string]*sourceRate
+}
+
+type sourceRate struct {
+	last time.Time
+	// running sums of the samples not sent yet, for DownsampleAverage
+	n                    int
+	x, y                 float64
+	steering, gas, brake float64
+}
+
+func newDownsampler(rate float64, mode Downsampling) *downsampler {
+	if rate <= 0 {
+		return nil
+	}
+	if !mode.Valid() {
+		mode = DownsampleDecimate
+	}
+
+	return &downsampler{
+		interval: time.Duration(float64(time.Second) / rate),
+		mode:     mode,
+		sources:  make(map[string]*sourceRate),
+	}
+}
+
+// sample returns what to send in place of m, nil if nothing is due yet
+func (d *downsampler) sample(m *message, now time.Time) *message {
+	src, ok := d.sources[m.sample.Source]
+	if !ok {
+		src = &sourceRate{}
+		d.sources[m.sample.Source] = src
+	}
+
+	if d.mode == DownsampleAverage {
+		src.add(m.sample)
+	}
+	if now.Sub(src.last) < d.interval {
+		return nil
+	}
+	src.last = now
+
+	if d.mode == DownsampleDecimate || src.n == 1 {
+		src.reset()
+		return m
+	}
+
+	avg := src.average(m.sample)
+	src.reset()
+	data, err := msg.EncodeSample(avg)
+	if err != nil {
+		return m
+	}
+
+	return &message{topic: m.topic, data: data, sequenced: true, source: avg.Source, seq: avg.Seq, sample: avg}
+}
+
+func (r *sourceRate) add(s *msg.Sample) {
+	r.n++
+	r.x += float64(s.Location.X)
+	r.y += float64(s.Location.Y)
+	r.steering += float64(s.Car.SteeringWheelRotation)
+	r.gas += float64(s.Car.Gas)
+	r.brake += float64(s.Car.Brake)
+}
+
+// average of the samples added since the last reset, the rest is taken from the latest one
+func (r *sourceRate) average(latest *msg.Sample) *msg.Sample {
+	n := float64(r.n)
+	avg := *latest
+	avg.Location = msg.Location{X: float32(r.x / n), Y: float32(r.y / n)}
+	avg.Car.SteeringWheelRotation = int16(math.Round(r.steering / n))
+	avg.Car.Gas = uint8(math.Round(r.gas / n))
+	avg.Car.Brake = uint8(math.Round(r.brake / n))
+
+	return &avg
+}
+
+func (r *sourceRate) reset() {
+	last := r.last
+	*r = sourceRate{last: last}
+}
@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+)
+
+/*
+Framing
+
+- coder/websocket frames every write itself and has no API to write a frame prepared elsewhere
+- without compression a frame is a header and the payload as is, the same bytes for every connection,
+  so a message is framed once, by the first writer that needs it, and those connections write that frame
+- the shared frames go straight to the hijacked socket, frameConn follows the headers of what the library writes
+  so they never land inside one of its frames, like a pong or a batch
+- with deflate negotiated the library compresses every message for each connection, its API doesn't allow sharing
+  the compressed bytes, topics where that costs too much should disable compression
+*/
+
+const (
+	opBinary = 0x2
+	opClose  = 0x8
+	// fin, then the opcode
+	finBinary = 0x80 | opBinary
+	// Largest header of an unmasked frame.
+	maxHeaderSize = 10
+)
+
+// frame returns m as an unmasked binary frame, built once for every connection that writes it.
+// The writers may call it concurrently, each holding its reference to m.buf if any.
+func (m *message) frame() []byte {
+	m.framing.Do(func() {
+		m.framed = appendFrameHeader(make([]byte, 0, maxHeaderSize+len(m.data)), len(m.data))
+		m.framed = append(m.framed, m.data...)
+	})
+
+	return m.framed
+}
+
+func appendFrameHeader(b []byte, n int) []byte {
+	switch {
+	case n < 126:
+		return append(b, finBinary, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, finBinary, 126), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, finBinary, 127), uint64(n))
+	}
+}
+
+// frameConn is the socket under a connection without compression, where the library's frames and the ones
+// the hub framed take turns
+type frameConn struct {
+	net.Conn
+	// turn is held while a frame is on its way, by the library from the first byte of one to the last
+	turn chan struct{}
+	// closeSent is set once the library wrote a close frame, nothing may follow it
+	closeSent atomic.Bool
+
+	// the frame the library is writing, only touched by Write, which the library doesn't call concurrently
+	writing bool
+	header  []byte
+	left    uint64
+}
+
+func newFrameConn(c net.Conn) *frameConn {
+	return &frameConn{Conn: c, turn: make(chan struct{}, 1), header: make([]byte, 0, maxHeaderSize+4)}
+}
+
+// Write writes what the library flushes, holding the turn until the frame it's in ends
+func (c *frameConn) Write(p []byte) (int, error) {
+	if !c.writing {
+		c.turn <- struct{}{}
+		c.writing = true
+	}
+	c.follow(p)
+
+	n, err := c.Conn.Write(p)
+	if err != nil || (c.left == 0 && len(c.header) == 0) {
+		c.writing = false
+		<-c.turn
+	}
+
+	return n, err
+}
+
+// follow advances over bytes the library writes, reading its frame headers to tell where the frames end
+func (c *frameConn) follow(p []byte) {
+	for len(p) > 0 {
+		if c.left > 0 {
+			n := min(uint64(len(p)), c.left)
+			c.left -= n
+			p = p[n:]
+			continue
+		}
+
+		c.header = append(c.header, p[0])
+		p = p[1:]
+		size, length, ok := parseHeader(c.header)
+		if !ok || len(c.header) < size {
+			continue
+		}
+		if c.header[0]&0x0f == opClose {
+			c.closeSent.Store(true)
+		}
+		c.left = length
+		c.header = c.header[:0]
+	}
+}
+
+// parseHeader returns the size of the frame header b starts and the length of the frame's payload,
+// false until b holds enough of it to tell
+func parseHeader(b []byte) (size int, length uint64, ok bool) {
+	if len(b) < 2 {
+		return 0, 0, false
+	}
+
+	size = 2
+	switch l := b[1] & 0x7f; l {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	default:
+		length = uint64(l)
+	}
+	if b[1]&0x80 != 0 {
+		// masked, which servers never are, but the key is part of the header all the same
+		size += 4
+	}
+	if len(b) < size {
+		return size, 0, true
+	}
+
+	switch b[1] & 0x7f {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(b[2:]))
+	case 127:
+		length = binary.BigEndian.Uint64(b[2:])
+	}
+
+	return size, length, true
+}
+
+// writeFrame writes a frame the hub built, once the library isn't in the middle of one
+func (c *frameConn) writeFrame(ctx context.Context, frame []byte) error {
+	select {
+	case c.turn <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.turn }()
+
+	if c.closeSent.Load() {
+		return net.ErrClosed
+	}
+	_, err := c.Conn.Write(frame)
+
+	return err
+}
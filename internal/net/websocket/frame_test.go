@@ -0,0 +1,231 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// discardConn takes whatever is written to it
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// the library's frames keep the turn from their first byte to their last, however they're split across writes
+func TestFrameConnFollow(t *testing.T) {
+	var stream []byte
+	ends := map[int]bool{}
+	for _, n := range []int{0, 5, 125, 126, 300, 0xffff, 0x10000} {
+		stream = appendFrameHeader(stream, n)
+		stream = append(stream, bytes.Repeat([]byte{'x'}, n)...)
+		ends[len(stream)] = true
+	}
+	// a close frame, masked as a client would, which the header's length has to account for
+	stream = append(stream, 0x80|opClose, 0x80|2, 1, 2, 3, 4, 0x03, 0xe8)
+	ends[len(stream)] = true
+
+	for _, chunk := range []int{1, 3, 7, 126, 4096, len(stream)} {
+		c := newFrameConn(discardConn{})
+		for off := 0; off < len(stream); off += chunk {
+			end := min(off+chunk, len(stream))
+			if _, err := c.Write(stream[off:end]); err != nil {
+				t.Fatal(err)
+			}
+			if held := len(c.turn) == 1; held == ends[end] {
+				t.Fatalf("chunks of %d: turn held %v after %d bytes", chunk, held, end)
+			}
+		}
+		if !c.closeSent.Load() {
+			t.Errorf("chunks of %d: close frame not seen", chunk)
+		}
+		if err := c.writeFrame(context.Background(), appendFrameHeader(nil, 0)); err == nil {
+			t.Errorf("chunks of %d: wrote after the close frame", chunk)
+		}
+	}
+}
+
+// messages are written as shared frames on connections without compression, through the library on the others,
+// and arrive whole with pings and pongs going on between them
+func TestServeFrames(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression websocket.CompressionMode
+		framed      bool
+	}{
+		{"uncompressed", websocket.CompressionDisabled, true},
+		{"compressed", websocket.CompressionNoContextTakeover, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			if err := h.ConfigureTopic("*", TopicOptions{Compression: tt.compression}); err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), &websocket.DialOptions{
+				CompressionMode: tt.compression,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.CloseNow()
+			conn.SetReadLimit(1 << 20)
+
+			// the snapshot marker tells the connection joined
+			if _, _, err := conn.Read(ctx); err != nil {
+				t.Fatal(err)
+			}
+			framed := make(chan bool)
+			h.tasks <- func() error {
+				for _, s := range h.connections {
+					framed <- s.framed != nil
+				}
+				return nil
+			}
+			if got := <-framed; got != tt.framed {
+				t.Errorf("framed %v, want %v", got, tt.framed)
+			}
+
+			pingCtx, stop := context.WithCancel(ctx)
+			pinging := make(chan struct{})
+			go func() {
+				defer close(pinging)
+				for conn.Ping(pingCtx) == nil {
+				}
+			}()
+
+			sizes := []int{10, 200, 60000, 3, 65000}
+			for _, n := range sizes {
+				data, err := json.Marshal(strings.Repeat("x", n))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := h.Publish(DefaultTopic, &msg.Message{Type: "test", Data: data}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < len(sizes); {
+				_, bs, err := conn.Read(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				es, err := msg.DecodeAll(bs)
+				if err != nil || len(es) != 1 {
+					t.Fatalf("got %d envelopes, %v", len(es), err)
+				}
+				var m msg.Message
+				if err := json.Unmarshal(es[0].Payload, &m); err != nil {
+					t.Fatal(err)
+				}
+				// the hub syncs the client's clock meanwhile
+				if m.Type != "test" {
+					continue
+				}
+				if len(m.Data) != sizes[i]+2 {
+					t.Errorf("got %d bytes of data, want %d", len(m.Data), sizes[i]+2)
+				}
+				i++
+			}
+			stop()
+			<-pinging
+		})
+	}
+}
+
+// BenchmarkFanOut publishes through the hub to subscribers on real connections and waits until every one has read
+// each message, with the shared frames of connections without compression and with deflate per connection
+func BenchmarkFanOut(b *testing.B) {
+	const (
+		subscribers = 100
+		// published before waiting on the readers, under what a subscriber's queue holds
+		burst = sendQueueSize / 2
+	)
+	data, err := json.Marshal(strings.Repeat("x", 512))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	modes := []struct {
+		name        string
+		compression websocket.CompressionMode
+	}{
+		{"uncompressed", websocket.CompressionDisabled},
+		{"deflate", websocket.CompressionNoContextTakeover},
+	}
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			h := NewHub()
+			if err := h.ConfigureTopic("*", TopicOptions{Compression: mode.compression}); err != nil {
+				b.Fatal(err)
+			}
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			ctx := context.Background()
+
+			conns := make([]*websocket.Conn, subscribers)
+			for i := range conns {
+				conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), &websocket.DialOptions{
+					CompressionMode: mode.compression,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.CloseNow()
+				// the snapshot marker tells the connection joined
+				if _, _, err := conn.Read(ctx); err != nil {
+					b.Fatal(err)
+				}
+				conns[i] = conn
+			}
+
+			errs := make(chan error, subscribers)
+			b.ResetTimer()
+			for sent := 0; sent < b.N; sent += burst {
+				n := min(burst, b.N-sent)
+				for _, conn := range conns {
+					go func() {
+						for read := 0; read < n; {
+							_, bs, err := conn.Read(ctx)
+							if err != nil {
+								errs <- err
+								return
+							}
+							// skips the clock sync
+							if bytes.Contains(bs, []byte(`"bench"`)) {
+								read++
+							}
+						}
+						errs <- nil
+					}()
+				}
+				for range n {
+					if err := h.Publish(DefaultTopic, &msg.Message{Type: "bench", Data: data}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				for range conns {
+					if err := <-errs; err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -4,21 +4,66 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
 )
 
+type message struct {
+	topic string
+	data  []byte
+	// buf backs data when the message is forwarded as it was read, nil for messages the hub encoded.
+	// Every subscriber the message is queued for owns a reference.
+	buf *bufpool.Buffer
+	key *latestKey
+
+	// set for samples, which are delivered in sequence per source
+	sequenced bool
+	source    string
+	seq       uint32
+	conn      uuid.UUID
+	sample    *msg.Sample
+
+	// gone is set on the marker a delayed topic holds for a source whose publisher left, it isn't sent
+	gone string
+
+	// framed is data as a frame, see frame
+	framing sync.Once
+	framed  []byte
+}
+
 type Hub struct {
-	broadcast   chan []byte
+	broadcast   chan *message
 	tasks       chan func() error
-	subscribers map[*subscriber]struct{}
+	topics      map[string]*topic
+	connections map[uuid.UUID]*subscriber
+	bans        *banList
+	admission   *admission.Controller
+
+	rulesMx *sync.RWMutex
+	rules   []topicRule
+
+	// only touched on the hub's goroutine
+	observers map[*observer]struct{}
+	delays    map[string]time.Duration
 }
 
 func NewHub() *Hub {
 	h := &Hub{
-		broadcast:   make(chan []byte),
+		broadcast:   make(chan *message),
 		tasks:       make(chan func() error),
-		subscribers: make(map[*subscriber]struct{}),
+		topics:      make(map[string]*topic),
+		connections: make(map[uuid.UUID]*subscriber),
+		bans:        newBanList(),
+		admission:   admission.New(admission.Limits{}),
+		rulesMx:     &sync.RWMutex{},
+		observers:   make(map[*observer]struct{}),
+		delays:      make(map[string]time.Duration),
 	}
 
 	go h.listen()
@@ -27,6 +72,9 @@ func NewHub() *Hub {
 }
 
 func (h *Hub) listen() {
+	ticker := time.NewTicker(reorderWindow / 2)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case task := <-h.tasks:
@@ -34,22 +82,82 @@ func (h *Hub) listen() {
 				log.Println(err)
 			}
 		case msg := <-h.broadcast:
-			for s := range h.subscribers {
-				s.send <- msg
+			t, ok := h.topics[msg.topic]
+			if !ok {
+				continue
+			}
+			if !msg.sequenced {
+				h.deliver(t, msg)
+				continue
+			}
+			for _, m := range t.sequence(msg, time.Now()) {
+				h.deliver(t, m)
+			}
+		case now := <-ticker.C:
+			for _, t := range h.topics {
+				for _, m := range t.expire(now) {
+					h.deliver(t, m)
+				}
+				t.release(now)
 			}
 		}
 	}
 }
 
+// SetLimits bounds the connections the hub accepts, there is no limit by default.
+func (h *Hub) SetLimits(l admission.Limits) {
+	h.admission.SetLimits(l)
+}
+
 func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	h.Serve(w, r, Subscription{Topic: DefaultTopic})
+}
+
+// Serve upgrades the request and joins the connection to the subscription's topic.
+func (h *Hub) Serve(w http.ResponseWriter, r *http.Request, subscription Subscription) {
+	identity := IdentityFrom(r.Context())
+	if b := h.bans.find(identity, admission.RemoteIP(r.RemoteAddr)); b != nil {
+		http.Error(w, "banned until "+b.Until.Format(time.RFC3339), http.StatusForbidden)
+		return
+	}
+
+	// only an authenticated identity is trusted with the reserved capacity, or to be counted against its own limit
+	// rather than its address, one a client declares could lock the real one out
+	req := admission.Request{IP: admission.RemoteIP(r.RemoteAddr), Privileged: identity.privileged()}
+	if identity.Authenticated {
+		req.Identity = identity.ID
+	}
+	release, err := h.admission.Admit(req)
+	if err != nil {
+		h.admission.Reject(w, err)
+		return
+	}
+	defer release()
+
+	opts := h.topicOptions(subscription.Topic)
+	stats := &connStats{}
+
+	cw := &countingWriter{ResponseWriter: w, stats: stats}
+	c, err := websocket.Accept(cw, r, &websocket.AcceptOptions{
+		InsecureSkipVerify:   true,
+		CompressionMode:      opts.Compression,
+		CompressionThreshold: opts.CompressionThreshold,
+	})
 	if err != nil {
 		// TODO log that there was an error
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	sub := newSubscriber(c)
+	sub, err := newSubscriber(c, subscription, stats)
+	if err != nil {
+		c.Close(websocket.StatusInternalError, "failed to establish connection")
+		return
+	}
+	sub.identity = identity
+	sub.remoteAddr = r.RemoteAddr
+	sub.framed = cw.framed
+	sub.interest = newInterest(subscription.Interest, sub.source())
 
 	defer func() {
 		if err := h.deleteSubscriber(sub); err != nil {
@@ -66,37 +174,106 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// topic returns the topic called name, creating it if needed.
+// It must run on the hub's goroutine.
+func (h *Hub) topic(name string) *topic {
+	t, ok := h.topics[name]
+	if !ok {
+		t = newTopic(name)
+		t.delay = h.delay(name)
+		h.topics[name] = t
+	}
+
+	return t
+}
+
 func (h *Hub) addSubscriber(ctx context.Context, s *subscriber) error {
+	joined := make(chan []*message, 1)
 	h.tasks <- func() error {
-		h.subscribers[s] = struct{}{}
-		return nil
+		t := h.topic(s.topic)
+		t.subs[s] = struct{}{}
+		h.connections[s.id] = s
+		snapshot, err := t.replay(s)
+		joined <- snapshot
+		return err
 	}
+	snapshot := <-joined
 
+	pingCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	go func() {
-		for msg := range s.send {
-			if err := s.write(ctx, msg); err != nil {
-				h.tasks <- func() error { return err }
-				return
-			}
+		if err := s.ping(pingCtx); err != nil && pingCtx.Err() == nil {
+			h.tasks <- func() error { return err }
+		}
+	}()
+
+	go func() {
+		if err := s.writeLoop(ctx, snapshot); err != nil {
+			h.tasks <- func() error { return err }
+		}
+	}()
+
+	go func() {
+		if err := s.syncClock(pingCtx); err != nil && pingCtx.Err() == nil {
+			h.tasks <- func() error { return err }
 		}
 	}()
 
 	for {
-		msg, err := s.read(ctx)
+		buf, err := s.read(ctx)
 		if err != nil {
 			return err
 		}
+		at := time.Now()
 
-		h.broadcast <- msg
+		// the message owns buf from here on, or released it if it was re-encoded
+		m, control := s.stamp(buf)
+		if control != nil {
+			if err := s.handleClock(ctx, control, at); err != nil {
+				return err
+			}
+			continue
+		}
+		if m == nil {
+			continue
+		}
+
+		if s.muted.Load() {
+			if m.buf != nil {
+				m.buf.Release()
+			}
+			continue
+		}
+
+		h.broadcast <- m
 	}
 }
 
 func (h *Hub) deleteSubscriber(s *subscriber) error {
 	h.tasks <- func() error {
-		delete(h.subscribers, s)
+		t := h.topics[s.topic]
+		delete(t.subs, s)
+		h.forget(t, s.id)
+		// a topic keeps what late joiners are replayed, like the state the server publishes between races
+		if len(t.subs) == 0 && len(t.latest) == 0 {
+			t.drop()
+			delete(h.topics, s.topic)
+		}
+		delete(h.connections, s.id)
+		close(s.send)
 		return nil
 	}
 
+	stats := s.stats.snapshot()
+	log.Printf(
+		"topic %q: connection %s closed, sent %d bytes as %d on the wire (ratio %.2f)",
+		s.topic,
+		s.id,
+		stats.BytesSent,
+		stats.WireBytesSent,
+		stats.CompressionRatio(),
+	)
+
 	if err := s.conn.Close(websocket.StatusNormalClosure, ""); err != nil {
 		return err
 	}
@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+)
+
+// only authenticated drivers and race control get the capacity reserved for them
+func TestServeReserved(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity Identity
+		want     int
+	}{
+		{"spectator", Identity{Role: RoleSpectator}, http.StatusServiceUnavailable},
+		{"declared race control", Identity{ID: "rc", Role: RoleRaceControl}, http.StatusServiceUnavailable},
+		{"declared driver", Identity{ID: "d", Role: RoleDriver}, http.StatusServiceUnavailable},
+		{"authenticated race control", Identity{ID: "rc", Role: RoleRaceControl, Authenticated: true}, http.StatusSwitchingProtocols},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			h.SetLimits(admission.Limits{Total: 1, Reserved: 1})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), tt.identity)))
+			}))
+			defer srv.Close()
+
+			conn, res, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if conn != nil {
+				defer conn.CloseNow()
+			}
+			if res == nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package websocket
+
+import "context"
+
+type Role string
+
+const (
+	RoleSpectator   Role = "spectator"
+	RoleDriver      Role = "driver"
+	RoleRaceControl Role = "race_control"
+)
+
+// Identity is who is behind a connection, as decided by the service before the upgrade.
+type Identity struct {
+	ID   string
+	Role Role
+	// Driver is who the connection publishes for, its samples are attributed to them rather than to ID.
+	Driver string
+	// Authenticated is set when ID and Role come from an authenticated session rather than from the client.
+	// A role the client declares itself carries no privileges.
+	Authenticated bool
+}
+
+// privileged tells whether the connection is a driver or race control, which takes an authenticated role
+func (id Identity) privileged() bool {
+	return id.Authenticated && (id.Role == RoleDriver || id.Role == RoleRaceControl)
+}
+
+// raceControl tells whether the connection speaks for race control, which takes an authenticated role
+func (id Identity) raceControl() bool {
+	return id.Authenticated && id.Role == RoleRaceControl
+}
+
+type identityKey struct{}
+
+// WithIdentity attaches the identity the hub uses for the connection upgraded from ctx's request.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the identity attached to ctx, anonymous spectators by default.
+func IdentityFrom(ctx context.Context) Identity {
+	if id, ok := ctx.Value(identityKey{}).(Identity); ok {
+		return id
+	}
+
+	return Identity{Role: RoleSpectator}
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"math"
+	"time"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// DefaultFarInterval is how often a subscriber with an area of interest gets the samples of a car outside of it.
+const DefaultFarInterval = time.Second
+
+// Interest is an area around a car, the samples of cars inside it are sent at full rate
+// and the others at most once per FarInterval.
+type Interest struct {
+	// Radius is in the same unit as the samples' location.
+	Radius float32
+	// Follow is the source the area is centred on, the subscriber's own source if empty.
+	Follow string
+	// FarInterval defaults to DefaultFarInterval.
+	FarInterval time.Duration
+}
+
+// interest is a subscriber's Interest and what it needs to throttle distant cars.
+// It's only used on the hub's goroutine.
+type interest struct {
+	Interest
+	// last is when the latest sample of every distant source was sent
+	last map[string]time.Time
+}
+
+func newInterest(i *Interest, self string) *interest {
+	if i == nil {
+		return nil
+	}
+
+	in := &interest{Interest: *i, last: make(map[string]time.Time)}
+	if in.Follow == "" {
+		in.Follow = self
+	}
+	if in.FarInterval <= 0 {
+		in.FarInterval = DefaultFarInterval
+	}
+
+	return in
+}
+
+// wants tells if s is sent, knowing where every source of the topic was last seen.
+// Until the followed car has been seen nothing is near.
+func (in *interest) wants(s *msg.Sample, locations map[string]msg.Location, now time.Time) bool {
+	if s.Source == in.Follow {
+		return true
+	}
+
+	if center, ok := locations[in.Follow]; ok {
+		dx, dy := float64(s.Location.X-center.X), float64(s.Location.Y-center.Y)
+		if math.Hypot(dx, dy) <= float64(in.Radius) {
+			// back to full rate, the next time it drifts away starts a fresh interval
+			delete(in.last, s.Source)
+			return true
+		}
+	}
+
+	if last, ok := in.last[s.Source]; ok && now.Sub(last) < in.FarInterval {
+		return false
+	}
+	in.last[s.Source] = now
+
+	return true
+}
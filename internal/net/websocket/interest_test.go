@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+func TestNewInterest(t *testing.T) {
+	if newInterest(nil, "me") != nil {
+		t.Error("an interest without one asked for")
+	}
+
+	tests := []struct {
+		name   string
+		in     Interest
+		follow string
+		far    time.Duration
+	}{
+		{"defaults", Interest{Radius: 10}, "me", DefaultFarInterval},
+		{"set", Interest{Radius: 10, Follow: "other", FarInterval: 100 * time.Millisecond}, "other", 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := newInterest(&tt.in, "me")
+			if in.Follow != tt.follow || in.FarInterval != tt.far {
+				t.Errorf("follows %q every %s, want %q every %s", in.Follow, in.FarInterval, tt.follow, tt.far)
+			}
+		})
+	}
+}
+
+// cars near the followed one get every sample, the others one per far interval
+func TestInterestWants(t *testing.T) {
+	const far = time.Second
+	type step struct {
+		source string
+		x      float32
+		at     time.Duration
+		want   bool
+	}
+
+	tests := []struct {
+		name string
+		// where the followed car "me" is, unknown if nil
+		center *msg.Location
+		steps  []step
+	}{
+		{"followed", nil, []step{{"me", 0, 0, true}, {"me", 0, time.Millisecond, true}}},
+		{
+			"nothing near until the followed car is seen", nil,
+			[]step{{"a", 0, 0, true}, {"a", 0, time.Millisecond, false}},
+		},
+		{
+			"near at full rate", &msg.Location{},
+			[]step{{"a", 10, 0, true}, {"a", 10, time.Millisecond, true}, {"a", 10, 2 * time.Millisecond, true}},
+		},
+		{
+			"far throttled", &msg.Location{},
+			[]step{
+				{"a", 100, 0, true},
+				{"a", 100, far / 2, false},
+				{"a", 100, far, true},
+				{"a", 100, far + time.Millisecond, false},
+				// each source is throttled on its own
+				{"b", 100, far + time.Millisecond, true},
+			},
+		},
+		{
+			"coming back near starts a fresh interval", &msg.Location{},
+			[]step{
+				{"a", 100, 0, true},
+				{"a", 10, time.Millisecond, true},
+				{"a", 100, 2 * time.Millisecond, true},
+				{"a", 100, 3 * time.Millisecond, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := newInterest(&Interest{Radius: 10, FarInterval: far}, "me")
+			locations := map[string]msg.Location{}
+			if tt.center != nil {
+				locations["me"] = *tt.center
+			}
+
+			start := time.Now()
+			for i, s := range tt.steps {
+				sample := &msg.Sample{Source: s.source, Location: msg.Location{X: s.x}}
+				if got := in.wants(sample, locations, start.Add(s.at)); got != s.want {
+					t.Errorf("step %d: %s at %g wanted %v, want %v", i, s.source, s.x, got, s.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+)
+
+// Close codes sent to connections removed by race control or by the hub.
+// 4000-4999 are reserved for applications by RFC 6455.
+const (
+	StatusKicked websocket.StatusCode = 4000
+	StatusBanned websocket.StatusCode = 4001
+	// StatusTooSlow closes connections that can't keep up with their topic.
+	StatusTooSlow websocket.StatusCode = 4002
+)
+
+var ErrConnNotFound = errors.New("websocket: connection not found")
+
+// Ban blocks an identity, an IP or both until it expires.
+type Ban struct {
+	ID       uuid.UUID
+	Identity string
+	IP       string
+	Reason   string
+	Until    time.Time
+}
+
+func (b *Ban) matches(id Identity, ip string) bool {
+	return (b.Identity != "" && b.Identity == id.ID) || (b.IP != "" && b.IP == ip)
+}
+
+type banList struct {
+	mx   *sync.Mutex
+	bans map[uuid.UUID]*Ban
+}
+
+func newBanList() *banList {
+	return &banList{mx: &sync.Mutex{}, bans: make(map[uuid.UUID]*Ban)}
+}
+
+// find returns the active ban matching the identity or IP, dropping expired ones on the way
+func (l *banList) find(id Identity, ip string) *Ban {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	now := time.Now()
+	for bid, b := range l.bans {
+		if now.After(b.Until) {
+			delete(l.bans, bid)
+			continue
+		}
+		if b.matches(id, ip) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// Kick starts closing a connection with code and reason.
+func (h *Hub) Kick(id uuid.UUID, code websocket.StatusCode, reason string) error {
+	s, ok := h.subscriber(id)
+	if !ok {
+		return ErrConnNotFound
+	}
+
+	// the close handshake waits on the peer, don't hold the caller for it
+	go func() {
+		if err := s.conn.Close(code, reason); err != nil {
+			h.tasks <- func() error { return err }
+		}
+	}()
+
+	return nil
+}
+
+// Mute stops or resumes forwarding what a connection publishes, it stays connected either way.
+func (h *Hub) Mute(id uuid.UUID, muted bool) error {
+	s, ok := h.subscriber(id)
+	if !ok {
+		return ErrConnNotFound
+	}
+	s.muted.Store(muted)
+
+	return nil
+}
+
+// Ban blocks new connections matching b and kicks the live ones.
+func (h *Hub) Ban(b Ban) (*Ban, error) {
+	if b.Identity == "" && b.IP == "" {
+		return nil, errors.New("websocket: ban needs an identity or an IP")
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	b.ID = id
+
+	h.bans.mx.Lock()
+	h.bans.bans[b.ID] = &b
+	h.bans.mx.Unlock()
+
+	for _, c := range h.Connections() {
+		if b.matches(c.Identity, admission.RemoteIP(c.RemoteAddr)) {
+			// the connection may have gone away in the meantime
+			_ = h.Kick(c.ID, StatusBanned, b.Reason)
+		}
+	}
+
+	return &b, nil
+}
+
+// Unban lifts a ban before it expires.
+func (h *Hub) Unban(id uuid.UUID) bool {
+	h.bans.mx.Lock()
+	defer h.bans.mx.Unlock()
+
+	_, ok := h.bans.bans[id]
+	delete(h.bans.bans, id)
+
+	return ok
+}
+
+// Bans lists the active bans, the ones expiring first come first.
+func (h *Hub) Bans() []Ban {
+	h.bans.mx.Lock()
+	defer h.bans.mx.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(h.bans.bans))
+	for _, b := range h.bans.bans {
+		if now.Before(b.Until) {
+			bans = append(bans, *b)
+		}
+	}
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return a.Until.Compare(b.Until)
+	})
+
+	return bans
+}
+
+func (h *Hub) subscriber(id uuid.UUID) (*subscriber, bool) {
+	res := make(chan *subscriber)
+	h.tasks <- func() error {
+		res <- h.connections[id]
+		return nil
+	}
+
+	s := <-res
+	return s, s != nil
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+type observer struct {
+	pattern string
+	fn      func(topic string, s *msg.Sample)
+	gone    func(topic, source string)
+}
+
+// Observe calls fn with every sample delivered on the topics matching pattern (path.Match syntax),
+// in order per source, and gone if not nil once the connection publishing for a source closes.
+// Both run on the hub's goroutine and must not block.
+// The returned function stops the observation.
+func (h *Hub) Observe(pattern string, fn func(topic string, s *msg.Sample), gone func(topic, source string)) (func(), error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	o := &observer{pattern: pattern, fn: fn, gone: gone}
+	h.tasks <- func() error {
+		h.observers[o] = struct{}{}
+		return nil
+	}
+
+	return func() {
+		h.tasks <- func() error {
+			delete(h.observers, o)
+			return nil
+		}
+	}, nil
+}
+
+// Publish delivers a message from the server itself to the subscribers of topic.
+// Race states, leaderboards and event states are kept for late joiners like the ones connections publish,
+// even if nobody is subscribed yet.
+func (h *Hub) Publish(topic string, m *msg.Message) error {
+	data, err := msg.EncodeMessage(m)
+	if err != nil {
+		return err
+	}
+
+	pm := &message{topic: topic, data: data, key: messageKey(m)}
+	h.tasks <- func() error {
+		h.deliver(h.topic(topic), pm)
+		return nil
+	}
+
+	return nil
+}
+
+// deliver fans m out and shows the samples to the observers.
+// It must run on the hub's goroutine.
+func (h *Hub) deliver(t *topic, m *message) {
+	t.deliver(m)
+
+	if m.sample == nil {
+		return
+	}
+	for o := range h.observers {
+		if ok, _ := path.Match(o.pattern, t.name); ok {
+			o.fn(t.name, m.sample)
+		}
+	}
+}
+
+// forget drops the sources conn was publishing for and tells the observers.
+// It must run on the hub's goroutine.
+func (h *Hub) forget(t *topic, conn uuid.UUID) {
+	for _, source := range t.forget(conn, time.Now()) {
+		for o := range h.observers {
+			if ok, _ := path.Match(o.pattern, t.name); ok && o.gone != nil {
+				o.gone(t.name, source)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// onHub runs fn on the hub's goroutine and waits for it
+func onHub(h *Hub, fn func()) {
+	done := make(chan struct{})
+	h.tasks <- func() error {
+		fn()
+		close(done)
+		return nil
+	}
+	<-done
+}
+
+func TestPublishWithoutSubscribers(t *testing.T) {
+	h := NewHub()
+	if err := h.Publish("events/1/state", &msg.Message{Type: msg.RaceState, Data: json.RawMessage(`{"tick":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	onHub(h, func() {
+		tp, ok := h.topics["events/1/state"]
+		if !ok {
+			t.Fatal("topic not created")
+		}
+		if _, ok := tp.latest[latestKey{kind: string(msg.RaceState)}]; !ok {
+			t.Error("race state not kept for late joiners")
+		}
+	})
+}
+
+func TestObserveGone(t *testing.T) {
+	h := NewHub()
+	conn := uuid.New()
+
+	var samples, gone []string
+	stop, err := h.Observe("events/*/telemetry", func(_ string, s *msg.Sample) {
+		samples = append(samples, s.Source)
+	}, func(_ string, source string) {
+		gone = append(gone, source)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	onHub(h, func() {
+		tp := h.topic("events/1/telemetry")
+		for _, source := range []string{"a", "b"} {
+			m := sampleMsg(conn, source, 1)
+			m.sample = &msg.Sample{Source: source}
+			for _, m := range tp.sequence(m, time.Now()) {
+				h.deliver(tp, m)
+			}
+		}
+		h.forget(tp, conn)
+	})
+
+	slices.Sort(gone)
+	if !slices.Equal(samples, []string{"a", "b"}) || !slices.Equal(gone, []string{"a", "b"}) {
+		t.Fatalf("observed samples of %v and %v gone", samples, gone)
+	}
+}
+
+// an event's state published before anyone follows it is what they catch up from
+func TestPublishedStateReplayed(t *testing.T) {
+	h := NewHub()
+	for _, state := range []string{`{"to":"scheduled"}`, `{"to":"live"}`} {
+		if err := h.Publish("events/1/state", &msg.Message{Type: msg.EventState, Data: json.RawMessage(state)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	onHub(h, func() {
+		snapshot, err := h.topic("events/1/state").replay(&subscriber{identity: Identity{Role: RoleSpectator}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the latest state, then the marker
+		if len(snapshot) != 2 {
+			t.Fatalf("replayed %d messages", len(snapshot))
+		}
+		e, err := msg.Decode(snapshot[0].data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m msg.Message
+		if err := json.Unmarshal(e.Payload, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != msg.EventState || string(m.Data) != `{"to":"live"}` {
+			t.Errorf("replayed %s %s", m.Type, m.Data)
+		}
+	})
+}
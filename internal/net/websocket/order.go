@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+const (
+	// How long an out of order sample waits for the ones before it.
+	reorderWindow = 50 * time.Millisecond
+	// How many samples of a source may wait at most before the missing ones are given up.
+	maxPending = 32
+	// A sequence number this far behind the expected one means the publisher restarted.
+	restartDistance = 1024
+)
+
+// sequencer restores the order of a source's samples, drops duplicates and reports gaps
+type sequencer struct {
+	// conn is the last connection that published for the source
+	conn    uuid.UUID
+	next    uint32
+	started bool
+	pending map[uint32]*pendingSample
+}
+
+type pendingSample struct {
+	m       *message
+	arrived time.Time
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{pending: make(map[uint32]*pendingSample)}
+}
+
+// before compares sequence numbers allowing them to wrap around
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// push takes a sample and returns what can be delivered now, in order, gap markers included
+func (q *sequencer) push(m *message, now time.Time) []*message {
+	q.conn = m.conn
+
+	if !q.started || (before(m.seq, q.next) && q.next-m.seq > restartDistance) {
+		q.started = true
+		q.next = m.seq
+		clear(q.pending)
+	}
+
+	if before(m.seq, q.next) {
+		// duplicate, or too late to be put back in place
+		return nil
+	}
+	if _, ok := q.pending[m.seq]; ok {
+		return nil
+	}
+	q.pending[m.seq] = &pendingSample{m: m, arrived: now}
+
+	var out []*message
+	out = q.drain(out)
+	if len(q.pending) > maxPending {
+		out = q.skip(out)
+	}
+
+	return out
+}
+
+// expire gives up on missing samples once the oldest waiting one has waited long enough
+func (q *sequencer) expire(now time.Time) []*message {
+	var out []*message
+	for len(q.pending) > 0 && now.Sub(q.oldest()) >= reorderWindow {
+		out = q.skip(out)
+	}
+
+	return out
+}
+
+// drain delivers the waiting samples that follow the expected one without a hole
+func (q *sequencer) drain(out []*message) []*message {
+	for {
+		p, ok := q.pending[q.next]
+		if !ok {
+			return out
+		}
+		delete(q.pending, q.next)
+		out = append(out, p.m)
+		q.next++
+	}
+}
+
+// skip reports the hole before the lowest waiting sample and delivers from there
+func (q *sequencer) skip(out []*message) []*message {
+	seqs := make([]uint32, 0, len(q.pending))
+	for seq := range q.pending {
+		seqs = append(seqs, seq)
+	}
+	lowest := slices.MinFunc(seqs, func(a, b uint32) int {
+		return int(int32(a - b))
+	})
+
+	gap, err := q.gap(q.pending[lowest].m, q.next, lowest-1)
+	if err != nil {
+		log.Println(err)
+	} else {
+		out = append(out, gap)
+	}
+
+	q.next = lowest
+	return q.drain(out)
+}
+
+func (q *sequencer) oldest() time.Time {
+	var oldest time.Time
+	for _, p := range q.pending {
+		if oldest.IsZero() || p.arrived.Before(oldest) {
+			oldest = p.arrived
+		}
+	}
+
+	return oldest
+}
+
+func (q *sequencer) gap(m *message, from, to uint32) (*message, error) {
+	data, err := json.Marshal(&msg.GapData{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := msg.EncodeMessage(&msg.Message{Type: msg.Gap, Source: m.source, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &message{topic: m.topic, data: bs, source: m.source}, nil
+}
+
+// sequence runs a sample through its source's sequencer
+func (t *topic) sequence(m *message, now time.Time) []*message {
+	q, ok := t.sources[m.source]
+	if !ok {
+		q = newSequencer()
+		t.sources[m.source] = q
+	}
+
+	return q.push(m, now)
+}
+
+// expire flushes the samples that waited too long for the ones missing before them
+func (t *topic) expire(now time.Time) []*message {
+	var out []*message
+	for _, q := range t.sources {
+		out = append(out, q.expire(now)...)
+	}
+
+	return out
+}
+
+// forget drops the state of the sources conn was publishing for, a new connection for them starts a new sequence.
+// Their last samples leave the snapshot, spectators of a delayed topic see them go once the delay is over.
+// It returns the sources forgotten.
+func (t *topic) forget(conn uuid.UUID, now time.Time) []string {
+	var gone []string
+	for source, q := range t.sources {
+		if q.conn != conn {
+			continue
+		}
+		delete(t.sources, source)
+		delete(t.locations, source)
+		delete(t.latest, latestKey{source: source, kind: sampleKind})
+		if t.delay > 0 {
+			t.hold(&message{topic: t.name, gone: source}, now)
+		} else {
+			delete(t.latestDelayed, latestKey{source: source, kind: sampleKind})
+		}
+		gone = append(gone, source)
+	}
+
+	return gone
+}
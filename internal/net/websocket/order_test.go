@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+func sampleMsg(conn uuid.UUID, source string, seq uint32) *message {
+	return &message{topic: "t", sequenced: true, source: source, seq: seq, conn: conn}
+}
+
+// describe names what the sequencer delivered, "7" for sample 7 and "gap 2-3" for a gap marker
+func describe(t *testing.T, ms []*message) []string {
+	t.Helper()
+
+	var out []string
+	for _, m := range ms {
+		if m.sequenced {
+			out = append(out, fmt.Sprint(m.seq))
+			continue
+		}
+		e, err := msg.Decode(m.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jm msg.Message
+		var gap msg.GapData
+		if err := json.Unmarshal(e.Payload, &jm); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(jm.Data, &gap); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, fmt.Sprintf("gap %d-%d", gap.From, gap.To))
+	}
+
+	return out
+}
+
+func TestSequencer(t *testing.T) {
+	type step struct {
+		// seq is pushed at the step's time, or the sequencer expires when expire is set
+		seq    uint32
+		expire bool
+		at     time.Duration
+	}
+	push := func(seqs ...uint32) []step {
+		steps := make([]step, len(seqs))
+		for i, seq := range seqs {
+			steps[i] = step{seq: seq}
+		}
+		return steps
+	}
+	pending := make([]uint32, maxPending+1)
+	var pendingOut []string
+	for i := range pending {
+		pending[i] = uint32(i + 3)
+		pendingOut = append(pendingOut, fmt.Sprint(i+3))
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		want  []string
+	}{
+		{"in order", push(1, 2, 3), []string{"1", "2", "3"}},
+		{"reordered", push(1, 3, 4, 2), []string{"1", "2", "3", "4"}},
+		{"duplicates", push(1, 2, 2, 1, 3, 3), []string{"1", "2", "3"}},
+		{"pending duplicate", push(1, 3, 3, 2), []string{"1", "2", "3"}},
+		{
+			"gap given up after the reorder window",
+			[]step{{seq: 1}, {seq: 4}, {expire: true, at: reorderWindow - time.Millisecond}, {expire: true, at: reorderWindow}},
+			[]string{"1", "gap 2-3", "4"},
+		},
+		{
+			"too late once given up",
+			[]step{{seq: 1}, {seq: 3}, {expire: true, at: reorderWindow}, {seq: 2, at: reorderWindow}, {seq: 4, at: reorderWindow}},
+			[]string{"1", "gap 2-2", "3", "4"},
+		},
+		{
+			"gap given up when too many wait",
+			append(push(1), push(pending...)...),
+			append([]string{"1", "gap 2-2"}, pendingOut...),
+		},
+		{"restarted publisher", push(2000, 2001, 5, 6), []string{"2000", "2001", "5", "6"}},
+		{"wraps around", push(1<<32-2, 1<<32-1, 0, 1), []string{"4294967294", "4294967295", "0", "1"}},
+	}
+
+	conn := uuid.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSequencer()
+			start := time.Now()
+
+			var got []*message
+			for _, s := range tt.steps {
+				if s.expire {
+					got = append(got, q.expire(start.Add(s.at))...)
+					continue
+				}
+				got = append(got, q.push(sampleMsg(conn, "car", s.seq), start.Add(s.at))...)
+			}
+
+			if out := describe(t, got); !slices.Equal(out, tt.want) {
+				t.Fatalf("got %v, want %v", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestForget(t *testing.T) {
+	left, stays := uuid.New(), uuid.New()
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		delay time.Duration
+	}{
+		{"live", 0},
+		{"delayed", time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := newTopic("t")
+			tp.delay = tt.delay
+			for _, p := range []struct {
+				conn   uuid.UUID
+				source string
+			}{{left, "a"}, {stays, "b"}} {
+				m := sampleMsg(p.conn, p.source, 100)
+				m.key = &latestKey{source: p.source, kind: sampleKind}
+				m.sample = &msg.Sample{Source: p.source}
+				for _, m := range tp.sequence(m, now) {
+					tp.deliver(m)
+				}
+			}
+			// deliver holds messages from the time it runs at
+			at := time.Now().Add(tt.delay)
+			tp.release(at)
+
+			tp.forget(left, at)
+
+			if _, ok := tp.sources["a"]; ok {
+				t.Error("sequencer of a kept")
+			}
+			if _, ok := tp.locations["a"]; ok {
+				t.Error("location of a kept")
+			}
+			if _, ok := tp.latest[latestKey{source: "a", kind: sampleKind}]; ok {
+				t.Error("a still in the snapshot")
+			}
+			if _, ok := tp.latest[latestKey{source: "b", kind: sampleKind}]; !ok {
+				t.Error("b left the snapshot")
+			}
+
+			// spectators of a delayed topic lose a only once they've seen it go
+			_, ok := tp.latestDelayed[latestKey{source: "a", kind: sampleKind}]
+			if want := tt.delay > 0; ok != want {
+				t.Errorf("a in the delayed snapshot: %v, want %v", ok, want)
+			}
+			tp.release(at.Add(tt.delay))
+			if _, ok := tp.latestDelayed[latestKey{source: "a", kind: sampleKind}]; ok {
+				t.Error("a still in the delayed snapshot")
+			}
+
+			// a new connection for a starts over, it isn't taken for a duplicate
+			got := tp.sequence(sampleMsg(uuid.New(), "a", 1), now)
+			if out := describe(t, got); !slices.Equal(out, []string{"1"}) {
+				t.Fatalf("after forget: got %v", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConnInfo describes a live connection.
+type ConnInfo struct {
+	ID          uuid.UUID
+	Identity    Identity
+	RemoteAddr  string
+	Topic       string
+	ConnectedAt time.Time
+	RTT         time.Duration
+	QueueDepth  int
+	Muted       bool
+	Clock       Clock
+	Stats       Stats
+}
+
+// TopicInfo describes a topic with live connections, or state kept for the next ones.
+type TopicInfo struct {
+	Name        string
+	Subscribers int
+	// SpectatorDelay is how late spectators get the topic's messages.
+	SpectatorDelay time.Duration
+	// DelayedDropped counts the messages spectators missed because too many were held back at once.
+	DelayedDropped uint64
+	Stats          Stats
+}
+
+// Connections lists the live connections ordered by ID, which is also connection time.
+func (h *Hub) Connections() []ConnInfo {
+	res := make(chan []ConnInfo)
+	h.tasks <- func() error {
+		conns := make([]ConnInfo, 0, len(h.connections))
+		for _, s := range h.connections {
+			conns = append(conns, s.info())
+		}
+		res <- conns
+		return nil
+	}
+
+	conns := <-res
+	slices.SortFunc(conns, func(a, b ConnInfo) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+
+	return conns
+}
+
+// Connection looks up a single live connection.
+func (h *Hub) Connection(id uuid.UUID) (ConnInfo, bool) {
+	s, ok := h.subscriber(id)
+	if !ok {
+		return ConnInfo{}, false
+	}
+
+	return s.info(), true
+}
+
+// Topics lists the topics with live connections or kept state, summing the traffic of their connections.
+func (h *Hub) Topics() []TopicInfo {
+	res := make(chan []TopicInfo)
+	h.tasks <- func() error {
+		topics := make([]TopicInfo, 0, len(h.topics))
+		for name, t := range h.topics {
+			info := TopicInfo{Name: name, Subscribers: len(t.subs), SpectatorDelay: t.delay, DelayedDropped: t.dropped}
+			for s := range t.subs {
+				info.Stats = info.Stats.add(s.stats.snapshot())
+			}
+			topics = append(topics, info)
+		}
+		res <- topics
+		return nil
+	}
+
+	topics := <-res
+	slices.SortFunc(topics, func(a, b TopicInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return topics
+}
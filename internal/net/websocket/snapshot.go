@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+const sampleKind = "sample"
+
+// latestKey identifies a message that replaces its predecessor in a topic's snapshot:
+// the latest sample of every source, and the latest race state and leaderboard.
+type latestKey struct {
+	source string
+	kind   string
+}
+
+// stamp attributes a published message to s and tells whether the topic's snapshot should keep it.
+// Messages that aren't valid envelopes are forwarded as they are,
+// the ones meant for the hub itself are returned as control instead, and the ones s may not send are dropped.
+func (s *subscriber) stamp(buf *bufpool.Buffer) (m *message, control *msg.Message) {
+	m = &message{topic: s.topic, data: buf.B, buf: buf}
+	defer func() {
+		// re-encoded and control messages don't need what was read anymore
+		if m == nil || m.buf == nil {
+			buf.Release()
+		}
+	}()
+
+	e, err := msg.Decode(buf.B)
+	if err != nil {
+		return m, nil
+	}
+
+	switch e.Typ {
+	case msg.Binary:
+		var sample msg.Sample
+		if err := sample.UnmarshalBinary(e.Payload); err != nil {
+			return m, nil
+		}
+		sample.Source = s.source()
+		sample.Time = s.clock.toServer(sample.Time)
+
+		data, err := msg.EncodeSample(&sample)
+		if err != nil {
+			return m, nil
+		}
+		m.data, m.buf = data, nil
+		m.key = &latestKey{source: sample.Source, kind: sampleKind}
+		m.sequenced = true
+		m.source = sample.Source
+		m.seq = sample.Seq
+		m.conn = s.id
+		m.sample = &sample
+	case msg.JSON:
+		var jm msg.Message
+		if err := json.Unmarshal(e.Payload, &jm); err != nil {
+			return m, nil
+		}
+		if jm.Type == msg.TimeRequest || jm.Type == msg.TimeResponse {
+			return nil, &jm
+		}
+		jm.Source = s.source()
+
+		data, err := msg.EncodeMessage(&jm)
+		if err != nil {
+			return m, nil
+		}
+		m.data, m.buf = data, nil
+		m.key = messageKey(&jm)
+		if m.key != nil && !s.identity.raceControl() {
+			// the race state and leaderboard everyone catches up from come from the service or race control only
+			return nil, nil
+		}
+	}
+
+	return m, nil
+}
+
+// messageKey tells whether a JSON message replaces its predecessor in the snapshot
+func messageKey(m *msg.Message) *latestKey {
+	switch m.Type {
+	case msg.RaceState, msg.Leaderboard, msg.EventState:
+		return &latestKey{kind: string(m.Type)}
+	}
+
+	return nil
+}
+
+// source names the publisher in the messages it sends, its driver or its identity if it has one
+func (s *subscriber) source() string {
+	if s.identity.Driver != "" {
+		return s.identity.Driver
+	}
+	if s.identity.ID != "" {
+		return s.identity.ID
+	}
+
+	return s.id.String()
+}
+
+// replay returns the topic's snapshot for s, followed by a marker telling live messages start.
+// It must run on the hub's goroutine, s writes the snapshot before anything queued after.
+func (t *topic) replay(s *subscriber) ([]*message, error) {
+	latest := t.latest
+	if t.delays(s) {
+		latest = t.latestDelayed
+	}
+	snapshot := make([]*message, 0, len(latest)+1)
+	for _, data := range latest {
+		snapshot = append(snapshot, &message{topic: t.name, data: data})
+	}
+
+	data, err := json.Marshal(map[string]int{"messages": len(latest)})
+	if err != nil {
+		return nil, err
+	}
+
+	marker, err := msg.EncodeMessage(&msg.Message{Type: msg.Snapshot, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(snapshot, &message{topic: t.name, data: marker}), nil
+}
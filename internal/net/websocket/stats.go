@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats is a point in time copy of a connection's traffic counters.
+// Bytes* count message payloads as seen by the hub, Wire* count what actually
+// crossed the socket after framing and compression.
+type Stats struct {
+	MessagesSent      uint64
+	MessagesReceived  uint64
+	BytesSent         uint64
+	BytesReceived     uint64
+	WireBytesSent     uint64
+	WireBytesReceived uint64
+}
+
+// CompressionRatio returns wire bytes sent per payload byte sent.
+// Values below 1 mean compression is paying off, 0 means nothing was sent yet.
+func (s Stats) CompressionRatio() float64 {
+	if s.BytesSent == 0 {
+		return 0
+	}
+
+	return float64(s.WireBytesSent) / float64(s.BytesSent)
+}
+
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		MessagesSent:      s.MessagesSent + o.MessagesSent,
+		MessagesReceived:  s.MessagesReceived + o.MessagesReceived,
+		BytesSent:         s.BytesSent + o.BytesSent,
+		BytesReceived:     s.BytesReceived + o.BytesReceived,
+		WireBytesSent:     s.WireBytesSent + o.WireBytesSent,
+		WireBytesReceived: s.WireBytesReceived + o.WireBytesReceived,
+	}
+}
+
+type connStats struct {
+	messagesSent      atomic.Uint64
+	messagesReceived  atomic.Uint64
+	bytesSent         atomic.Uint64
+	bytesReceived     atomic.Uint64
+	wireBytesSent     atomic.Uint64
+	wireBytesReceived atomic.Uint64
+}
+
+func (c *connStats) sent(n int) {
+	c.messagesSent.Add(1)
+	c.bytesSent.Add(uint64(n))
+}
+
+func (c *connStats) received(n int) {
+	c.messagesReceived.Add(1)
+	c.bytesReceived.Add(uint64(n))
+}
+
+func (c *connStats) snapshot() Stats {
+	return Stats{
+		MessagesSent:      c.messagesSent.Load(),
+		MessagesReceived:  c.messagesReceived.Load(),
+		BytesSent:         c.bytesSent.Load(),
+		BytesReceived:     c.bytesReceived.Load(),
+		WireBytesSent:     c.wireBytesSent.Load(),
+		WireBytesReceived: c.wireBytesReceived.Load(),
+	}
+}
+
+// countingConn counts the bytes read from and written to the hijacked socket
+type countingConn struct {
+	net.Conn
+	stats *connStats
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.wireBytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.wireBytesSent.Add(uint64(n))
+	return n, err
+}
+
+// countingWriter hands a countingConn to the websocket library when it hijacks the connection.
+type countingWriter struct {
+	http.ResponseWriter
+	stats *connStats
+	// framed is set when the connection has no compression, see frameConn
+	framed *frameConn
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	nc, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the handshake response may still sit in the server's write buffer
+	if err := brw.Writer.Flush(); err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	var c net.Conn = &countingConn{Conn: nc, stats: w.stats}
+	// the library answers the extensions it agreed to before hijacking
+	if w.Header().Get("Sec-WebSocket-Extensions") == "" {
+		w.framed = newFrameConn(c)
+		c = w.framed
+	}
+	// the library re-points the reader at the returned conn itself, see coder/websocket accept.go
+	return c, bufio.NewReadWriter(brw.Reader, bufio.NewWriter(c)), nil
+}
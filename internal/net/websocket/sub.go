@@ -2,33 +2,193 @@ package websocket
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
 
 	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
 )
 
+const (
+	// Number of messages queued for a subscriber before the hub gives up on it.
+	sendQueueSize = 64
+	// How often the round trip time to the peer is measured.
+	pingPeriod = 10 * time.Second
+)
+
+// Subscription is what a connection asks of the hub when it joins.
+type Subscription struct {
+	Topic string
+	// FlushInterval batches what is queued for the connection into one message per interval.
+	// 0 writes every message as soon as it is queued.
+	FlushInterval time.Duration
+	// Interest limits the rate of the samples of distant cars, nil sends everything.
+	Interest *Interest
+	// MaxRate is the most samples per second sent for each source, 0 sends them all.
+	MaxRate float64
+	// Downsample is how samples are reduced to MaxRate, DownsampleDecimate if empty.
+	Downsample Downsampling
+}
+
 type subscriber struct {
-	conn *websocket.Conn
-	send chan []byte
+	id          uuid.UUID
+	identity    Identity
+	remoteAddr  string
+	connectedAt time.Time
+	conn        *websocket.Conn
+	topic       string
+	flush       time.Duration
+	send        chan *message
+	stats       *connStats
+	rtt         atomic.Int64
+	muted       atomic.Bool
+	interest    *interest
+	rate        *downsampler
+	clock       *clock
+	// framed writes the frames shared with other connections, nil if the connection compresses
+	framed *frameConn
+	// lagging is set once the hub found the queue full, only touched on the hub's goroutine
+	lagging bool
 }
 
-func newSubscriber(conn *websocket.Conn) *subscriber {
-	return &subscriber{conn: conn, send: make(chan []byte)}
+func newSubscriber(conn *websocket.Conn, sub Subscription, stats *connStats) (*subscriber, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscriber{
+		id:          id,
+		connectedAt: time.Now(),
+		conn:        conn,
+		topic:       sub.Topic,
+		flush:       sub.FlushInterval,
+		send:        make(chan *message, sendQueueSize),
+		stats:       stats,
+		rate:        newDownsampler(sub.MaxRate, sub.Downsample),
+		clock:       newClock(),
+	}, nil
 }
 
+// writeLoop writes the snapshot s joined with, then what the hub queues until the queue is closed
+func (s *subscriber) writeLoop(ctx context.Context, snapshot []*message) error {
+	for _, m := range snapshot {
+		if err := s.writeMessage(ctx, m); err != nil {
+			return err
+		}
+	}
+
+	if s.flush > 0 {
+		return s.writeBatches(ctx)
+	}
+
+	for m := range s.send {
+		err := s.writeMessage(ctx, m)
+		if m.buf != nil {
+			m.buf.Release()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeMessage writes m, as the frame it shares with the other connections without compression if s has none
+func (s *subscriber) writeMessage(ctx context.Context, m *message) error {
+	if s.framed == nil {
+		return s.write(ctx, m.data)
+	}
+	if err := s.framed.writeFrame(ctx, m.frame()); err != nil {
+		return err
+	}
+	s.stats.sent(len(m.data))
+
+	return nil
+}
+
+// write frames bs for this connection alone, through the library
 func (s *subscriber) write(ctx context.Context, bs []byte) error {
 	// TODO: don't use JSON
 	if err := s.conn.Write(ctx, websocket.MessageBinary, bs); err != nil {
 		return err
 	}
+	s.stats.sent(len(bs))
 
 	return nil
 }
 
-func (s *subscriber) read(ctx context.Context) ([]byte, error) {
-	_, bs, err := s.conn.Read(ctx)
+// queue hands m to the writer without blocking the hub. A subscriber too slow to keep its queue
+// from filling up is disconnected rather than holding up everyone else on the hub.
+// It must run on the hub's goroutine.
+func (s *subscriber) queue(m *message) {
+	if s.lagging {
+		return
+	}
+	if m.buf != nil {
+		m.buf.Retain(1)
+	}
+
+	select {
+	case s.send <- m:
+	default:
+		if m.buf != nil {
+			m.buf.Release()
+		}
+		s.lagging = true
+		// the close handshake waits on the peer, the hub doesn't, unregistering follows the reader's error
+		go func() { _ = s.conn.Close(StatusTooSlow, "too slow to keep up") }()
+	}
+}
+
+// read reads the next message into a pooled buffer owned by the caller
+func (s *subscriber) read(ctx context.Context) (*bufpool.Buffer, error) {
+	_, r, err := s.conn.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := bufpool.ReadAll(r, 0)
 	if err != nil {
 		return nil, err
 	}
+	s.stats.received(len(buf.B))
+
+	return buf, nil
+}
+
+// ping measures the round trip time until ctx is done or the peer stops answering
+func (s *subscriber) ping(ctx context.Context) error {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			start := time.Now()
+			if err := s.conn.Ping(ctx); err != nil {
+				return err
+			}
+			s.rtt.Store(int64(time.Since(start)))
+		}
+	}
+}
 
-	return bs, nil
+func (s *subscriber) info() ConnInfo {
+	return ConnInfo{
+		ID:          s.id,
+		Identity:    s.identity,
+		RemoteAddr:  s.remoteAddr,
+		Topic:       s.topic,
+		ConnectedAt: s.connectedAt,
+		RTT:         time.Duration(s.rtt.Load()),
+		QueueDepth:  len(s.send),
+		Muted:       s.muted.Load(),
+		Clock:       s.clock.info(),
+		Stats:       s.stats.snapshot(),
+	}
 }
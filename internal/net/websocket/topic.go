@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"path"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// DefaultTopic is joined by connections that don't ask for a topic.
+const DefaultTopic = "global"
+
+// TopicOptions tunes how connections of a topic are handled.
+type TopicOptions struct {
+	// Compression is offered to the client during the handshake.
+	// High-rate binary topics gain little from deflate and should disable it.
+	Compression websocket.CompressionMode
+	// CompressionThreshold is the minimum message size that gets compressed.
+	// 0 keeps the library default.
+	CompressionThreshold int
+}
+
+var defaultTopicOptions = TopicOptions{
+	Compression: websocket.CompressionNoContextTakeover,
+}
+
+type topic struct {
+	name string
+	subs map[*subscriber]struct{}
+	// latest holds what a subscriber needs to catch up, see replay
+	latest map[latestKey][]byte
+	// sources keeps the samples of every publisher in order, see sequence
+	sources map[string]*sequencer
+	// locations is where every source was last seen, for the subscribers' areas of interest
+	locations map[string]msg.Location
+
+	// delay holds back what spectators receive, see SetDelay
+	delay   time.Duration
+	delayed []delayed
+	// latestDelayed is latest as spectators have seen it
+	latestDelayed map[latestKey][]byte
+	// dropped counts the held messages spectators never got, see maxDelayed
+	dropped uint64
+}
+
+func newTopic(name string) *topic {
+	return &topic{
+		name:      name,
+		subs:      make(map[*subscriber]struct{}),
+		latest:    make(map[latestKey][]byte),
+		sources:   make(map[string]*sequencer),
+		locations: make(map[string]msg.Location),
+
+		latestDelayed: make(map[latestKey][]byte),
+	}
+}
+
+// deliver fans m out to the topic's subscribers, keeping it for the snapshot if needed.
+// It must run on the hub's goroutine.
+func (t *topic) deliver(m *message) {
+	if m.key != nil {
+		t.latest[*m.key] = m.data
+	}
+
+	now := time.Now()
+	if m.sample != nil {
+		t.locations[m.sample.Source] = m.sample.Location
+	}
+	for s := range t.subs {
+		if !t.delays(s) {
+			t.send(s, m, now)
+		}
+	}
+	if t.delay > 0 {
+		t.hold(m, now)
+	}
+	if m.buf != nil {
+		m.buf.Release()
+	}
+}
+
+// send queues m for s, unless s doesn't want the sample or it's due later
+func (t *topic) send(s *subscriber, m *message, now time.Time) {
+	if m.sample != nil {
+		if s.interest != nil && !s.interest.wants(m.sample, t.locations, now) {
+			return
+		}
+		if s.rate != nil {
+			if m = s.rate.sample(m, now); m == nil {
+				return
+			}
+		}
+	}
+	s.queue(m)
+}
+
+type topicRule struct {
+	pattern string
+	opts    TopicOptions
+}
+
+// ConfigureTopic sets the options of every topic matching pattern.
+// Patterns use path.Match syntax, e.g. "events/*/telemetry".
+// Rules are checked in the order they were added, the first match wins.
+func (h *Hub) ConfigureTopic(pattern string, opts TopicOptions) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	h.rulesMx.Lock()
+	h.rules = append(h.rules, topicRule{pattern: pattern, opts: opts})
+	h.rulesMx.Unlock()
+
+	return nil
+}
+
+func (h *Hub) topicOptions(topic string) TopicOptions {
+	h.rulesMx.RLock()
+	defer h.rulesMx.RUnlock()
+
+	for _, r := range h.rules {
+		if ok, _ := path.Match(r.pattern, topic); ok {
+			return r.opts
+		}
+	}
+
+	return defaultTopicOptions
+}
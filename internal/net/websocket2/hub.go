@@ -7,7 +7,8 @@ import (
 	"time"
 
 	"github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
 )
 
 /*
@@ -20,6 +21,8 @@ how does this work?
 - write, writes messages from hub to the connection
 - hub's listen method listens on 3 channels. register, unregister and broadcast.
 - messages pushed to broadcast are written to all connections by the "write" listener
+- there is no polled mode, every connection keeps its two goroutines: websocket4 is the gobwas hub with an epoll
+  mode, nothing serves this one so it keeps the plain design
 */
 
 func read(conn *ConnHandler, hub *Hub) {
@@ -27,13 +30,13 @@ func read(conn *ConnHandler, hub *Hub) {
 		hub.unregister <- conn
 		err := conn.rwc.Close()
 		if err != nil {
-			slog.Error("conn close: %v", err)
+			slog.Error("conn close", "err", err)
 		}
 		slog.Debug("read: conn closed")
 	}()
 
 	if err := conn.setReadDeadLine(pongWait); err != nil {
-		slog.Info("setReadDeadLine: %v\n", err)
+		slog.Info("setReadDeadLine", "err", err)
 		return
 	}
 
@@ -41,7 +44,7 @@ func read(conn *ConnHandler, hub *Hub) {
 		wsMsg, err := conn.read()
 		if err != nil {
 			// TODO: handle error
-			slog.Error("read err: %v\n", err.Error())
+			slog.Error("read", "err", err)
 			break
 		}
 
@@ -66,28 +69,28 @@ func write(conn *ConnHandler) {
 		ticker.Stop()
 		slog.Debug("write: conn closed")
 		if err := conn.rwc.Close(); err != nil {
-			slog.Error("error closing connection: %v", err)
+			slog.Error("error closing connection", "err", err)
 		}
 	}()
 
 	for {
 		select {
-		case msg, ok := <-conn.send:
+		case f, ok := <-conn.send:
 			_ = conn.setWriteDeadLine(writeWait)
 			if !ok {
 				slog.Error("<-conn.send not ok")
-				_ = conn.write(&wsutil.Message{OpCode: ws.OpClose, Payload: []byte{}})
+				_ = conn.write(closeFrame)
 				return
 			}
 
-			if err := conn.write(msg); err != nil {
-				slog.Error("msg err: %v\n", err)
+			if err := conn.write(f); err != nil {
+				slog.Error("msg", "err", err)
 				return
 			}
 		case <-ticker.C:
 			_ = conn.setWriteDeadLine(writeWait)
-			if err := conn.write(&wsutil.Message{OpCode: ws.OpPing, Payload: nil}); err != nil {
-				slog.Error("ticker err: %v\n", err)
+			if err := conn.write(pingFrame); err != nil {
+				slog.Error("ticker", "err", err)
 				return
 			}
 		}
@@ -96,14 +99,28 @@ func write(conn *ConnHandler) {
 
 type Hub struct {
 	register, unregister chan *ConnHandler
-	broadcast            chan *wsutil.Message
+	broadcast            chan *message
 	lock                 *sync.Mutex
 	connections          map[*ConnHandler]bool
 	upgrader             *ws.HTTPUpgrader
 
-	// Capacity of the send channel.
-	// If capacity is 0, the send channel is unbuffered.
-	Capacity uint
+	admission *admission.Controller
+
+	// Identify tells who is connecting, everyone is an anonymous spectator if nil.
+	Identify func(r *http.Request) admission.Request
+}
+
+// SetLimits bounds the connections the hub accepts, NewHub only sets the total.
+func (hub *Hub) SetLimits(l admission.Limits) {
+	hub.admission.SetLimits(l)
+}
+
+func (hub *Hub) identify(r *http.Request) admission.Request {
+	if hub.Identify != nil {
+		return hub.Identify(r)
+	}
+
+	return admission.Request{IP: admission.RemoteIP(r.RemoteAddr)}
 }
 
 // Len returns the number of connections.
@@ -123,7 +140,7 @@ func (hub *Hub) Close() error {
 		close(hub.broadcast)
 	}()
 
-	hub.broadcast <- &wsutil.Message{OpCode: ws.OpClose, Payload: []byte{}} // broadcast close
+	hub.broadcast <- &message{op: ws.OpClose, buf: bufpool.Get(0)} // broadcast close
 	return nil
 }
 
@@ -136,13 +153,13 @@ func NewHub(cap uint) *Hub {
 	hub := &Hub{
 		register:    make(chan *ConnHandler),
 		unregister:  make(chan *ConnHandler),
-		broadcast:   make(chan *wsutil.Message),
+		broadcast:   make(chan *message),
 		lock:        &sync.Mutex{},
 		connections: make(map[*ConnHandler]bool),
 		upgrader:    &ws.HTTPUpgrader{
 			// TODO: may be fields here that worth setting
 		},
-		Capacity: cap,
+		admission: admission.New(admission.Limits{Total: int(cap)}),
 	}
 
 	go hub.listen()
@@ -158,43 +175,63 @@ func (hub *Hub) listen() {
 			hub.lock.Unlock()
 		case conn := <-hub.unregister:
 			slog.Debug("unregister channel handler")
-			delete(hub.connections, conn)
-			close(conn.send)
+			hub.lock.Lock()
+			// the connection may already be gone if it was too slow
+			if hub.connections[conn] {
+				delete(hub.connections, conn)
+				close(conn.send)
+				conn.release()
+			}
+			hub.lock.Unlock()
 		case msg := <-hub.broadcast:
+			// framed once, every connection writes the same bytes
+			f, err := newFrame(msg.op, msg.buf.B)
+			// the frame holds its own copy of the payload
+			msg.buf.Release()
+			if err != nil {
+				slog.Error("frame", "err", err)
+				continue
+			}
+
+			hub.lock.Lock()
 			for conn := range hub.connections {
 				select {
-				case conn.send <- msg:
+				case conn.send <- f:
 				default:
 					// From Gorilla WS
 					// https://github.com/gorilla/websocket/tree/master/examples/chat#hub
 					// If the client’s send buffer is full, then the hub assumes that the client is dead or stuck. In this case, the hub unregisters the client and closes the websocket
 					slog.Debug("conn.send channel buffer possible full\n")
-					slog.Debug("broadcast channel handler: default case:\nopCode: %d\npayload: %+v\n", msg.OpCode, msg.Payload)
+					slog.Debug("broadcast channel handler: default case", "opCode", msg.op)
 					close(conn.send)
+					conn.release()
 					delete(hub.connections, conn)
 				}
 			}
+			hub.lock.Unlock()
 		}
 	}
 }
 
 func (hub *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// TODO check capacity
-	if hub.Capacity > 0 && hub.Len() >= int(hub.Capacity) {
-		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+	release, err := hub.admission.Admit(hub.identify(r))
+	if err != nil {
+		hub.admission.Reject(w, err)
 		return
 	}
 
 	rwc, _, _, err := hub.upgrader.Upgrade(r, w)
 	if err != nil {
+		release()
 		// TODO log that there was an error
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	conn := &ConnHandler{
-		rwc:  rwc,
-		send: make(chan *wsutil.Message, 256), // TODO what's the optimal size?
+		rwc:     rwc,
+		send:    make(chan *frame, 256), // TODO what's the optimal size?
+		release: release,
 	}
 
 	hub.register <- conn
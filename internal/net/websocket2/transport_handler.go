@@ -1,6 +1,7 @@
 package websocket2
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
 )
 
 const (
@@ -17,13 +20,37 @@ const (
 	// Time allowed to read the next pong message from the peer.
 	pongWait   = 60 * time.Second
 	pingPeriod = (pongWait * 9) / 10
+	// Largest message read from the peer, it fits the largest envelope.
+	maxMessageSize = msg.MaxSize
 )
 
 // using websocket for now, will be switching to Quic and WebTransport later
 type ConnHandler struct {
 	rwc net.Conn
+	// reused for every message read from rwc
+	r *wsutil.Reader
 
-	send chan *wsutil.Message
+	send chan *frame
+	// gives the connection's slot back to the hub's admission control
+	release func()
+}
+
+// control frames carry no payload here, they are compiled once for all connections
+var (
+	pingFrame   = mustFrame(ws.OpPing, nil)
+	closeFrame  = mustFrame(ws.OpClose, nil)
+	tooBigFrame = mustFrame(ws.OpClose, ws.NewCloseFrameBody(ws.StatusMessageTooBig, "message too big"))
+)
+
+var errMessageTooBig = errors.New("websocket2: message too big")
+
+func mustFrame(op ws.OpCode, payload []byte) *frame {
+	f, err := newFrame(op, payload)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
 }
 
 func (c *ConnHandler) setWriteDeadLine(d time.Duration) error {
@@ -34,11 +61,25 @@ func (c *ConnHandler) setReadDeadLine(d time.Duration) error {
 	return c.rwc.SetReadDeadline(time.Now().Add(d))
 }
 
-func (c *ConnHandler) read() (*wsutil.Message, error) {
-	r := wsutil.NewServerSideReader(c.rwc)
+// message is a data message read from a connection.
+// Sending it on the hub's broadcast channel hands the buffer over to the hub.
+type message struct {
+	op  ws.OpCode
+	buf *bufpool.Buffer
+}
+
+func (c *ConnHandler) read() (*message, error) {
+	if c.r == nil {
+		c.r = wsutil.NewServerSideReader(c.rwc)
+		c.r.MaxFrameSize = maxMessageSize
+	}
+	r := c.r
 
 	for {
 		h, err := r.NextFrame()
+		if errors.Is(err, wsutil.ErrFrameTooLarge) {
+			return nil, c.tooBig()
+		}
 		if err != nil {
 			return nil, fmt.Errorf("next frame: %w", err)
 		}
@@ -77,17 +118,33 @@ func (c *ConnHandler) read() (*wsutil.Message, error) {
 
 		// TODO the custom handler to parse payload could be done here (?)
 
-		p, err := io.ReadAll(r)
+		// frames are bounded by the reader, a message of many frames is bounded here
+		buf, err := bufpool.ReadAll(io.LimitReader(r, maxMessageSize+1), int(h.Length))
+		if errors.Is(err, wsutil.ErrFrameTooLarge) {
+			return nil, c.tooBig()
+		}
 		if err != nil {
 			return nil, fmt.Errorf("read all: %w", err)
 		}
-		return &wsutil.Message{OpCode: h.OpCode, Payload: p}, nil
+		if len(buf.B) > maxMessageSize {
+			buf.Release()
+			return nil, c.tooBig()
+		}
+		return &message{op: h.OpCode, buf: buf}, nil
 	}
 }
 
-func (c *ConnHandler) write(msg *wsutil.Message) error {
-	frame := ws.NewFrame(msg.OpCode, true, msg.Payload)
-	return ws.WriteFrame(c.rwc, frame)
+// tooBig closes the connection with 1009, the frame goes out in a single write so it can't land inside another
+func (c *ConnHandler) tooBig() error {
+	_ = c.setWriteDeadLine(writeWait)
+	_ = c.write(tooBigFrame)
+
+	return errMessageTooBig
+}
+
+func (c *ConnHandler) write(f *frame) error {
+	_, err := c.rwc.Write(f.bs)
+	return err
 }
 
 func (c *ConnHandler) controlHandler(h ws.Header) error {
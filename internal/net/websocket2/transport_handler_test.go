@@ -0,0 +1,63 @@
+package websocket2
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+func TestReadTooBig(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []ws.Frame
+		// header sent after the frames, without its payload
+		header *ws.Header
+	}{
+		{"oversized length", nil, &ws.Header{Fin: true, OpCode: ws.OpBinary, Length: 1 << 62, Masked: true}},
+		{
+			"reassembled message",
+			[]ws.Frame{
+				ws.MaskFrame(ws.NewFrame(ws.OpBinary, false, make([]byte, maxMessageSize/2+1))),
+				ws.MaskFrame(ws.NewFrame(ws.OpContinuation, true, make([]byte, maxMessageSize/2+1))),
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			c := &ConnHandler{rwc: server}
+			errc := make(chan error, 1)
+			go func() {
+				_, err := c.read()
+				errc <- err
+			}()
+
+			go func() {
+				for _, f := range tt.frames {
+					_ = ws.WriteFrame(client, f)
+				}
+				if tt.header != nil {
+					_ = ws.WriteHeader(client, *tt.header)
+				}
+			}()
+
+			f, err := ws.ReadFrame(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code, _ := ws.ParseCloseFrameData(f.Payload); f.Header.OpCode != ws.OpClose || code != ws.StatusMessageTooBig {
+				t.Errorf("got %v %d, want a close frame with %d", f.Header.OpCode, code, ws.StatusMessageTooBig)
+			}
+			if err := <-errc; !errors.Is(err, errMessageTooBig) {
+				t.Errorf("got %v, want %v", err, errMessageTooBig)
+			}
+		})
+	}
+}
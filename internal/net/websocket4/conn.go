@@ -1,16 +1,20 @@
 package websocket4
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
 )
 
 const (
@@ -18,14 +22,37 @@ const (
 	writeWait = 10 * time.Second
 	// Time allowed to read the next pong message from the peer.
 	pongWait = 10 * time.Second
+	// Number of frames queued for a connection before the hub gives up on it.
+	sendQueueSize = 256
 )
 
 type Conn struct {
 	id uuid.UUID
-	mx *sync.Mutex
-	nc net.Conn
-	// find the optimal buffer size
-	// send chan *wsutil.Message
+	// mx serializes writes to nc
+	mx   *sync.Mutex
+	nc   net.Conn
+	send chan *frame
+	// gives the connection's slot back to the hub's admission control
+	release func()
+	// largest message read, see Hub.MaxMessageSize
+	maxMessageSize int64
+
+	// set when the connection is served by the hub's poller instead of its own goroutines
+	pool     *workerPool
+	fd       int
+	raw      syscall.RawConn
+	drop     func()
+	detached atomic.Bool
+	lastSeen atomic.Int64
+	queueMx  sync.Mutex
+	queue    []*frame
+	flushing bool
+	closed   bool
+	// message being reassembled from fragments
+	fragmented ws.OpCode
+	fragments  *bufpool.Buffer
+	// what was read of a frame that hasn't fully arrived, only touched by the connection's read task
+	in *bufpool.Buffer
 }
 
 func newConn(nc net.Conn) (*Conn, error) {
@@ -35,12 +62,59 @@ func newConn(nc net.Conn) (*Conn, error) {
 	}
 
 	return &Conn{
-		id: id,
-		mx: &sync.Mutex{},
-		nc: nc,
+		id:             id,
+		mx:             &sync.Mutex{},
+		nc:             nc,
+		send:           make(chan *frame, sendQueueSize),
+		maxMessageSize: DefaultMaxMessageSize,
 	}, nil
 }
 
+// enqueue hands f to the connection's writer, false if the connection can't keep up
+func (c *Conn) enqueue(f *frame, errc chan error) bool {
+	if c.pool == nil {
+		select {
+		case c.send <- f:
+			return true
+		default:
+			return false
+		}
+	}
+
+	c.queueMx.Lock()
+	if len(c.queue) >= sendQueueSize {
+		c.queueMx.Unlock()
+		return false
+	}
+	c.queue = append(c.queue, f)
+	start := !c.flushing
+	c.flushing = true
+	c.queueMx.Unlock()
+
+	if start {
+		c.pool.schedule(func() { c.flush(errc) })
+	}
+
+	return true
+}
+
+// close tells the writer no more frames will come
+func (c *Conn) close() {
+	if c.release != nil {
+		c.release()
+	}
+
+	if c.pool == nil {
+		close(c.send)
+		return
+	}
+
+	c.queueMx.Lock()
+	c.closed = true
+	c.queue = nil
+	c.queueMx.Unlock()
+}
+
 func (c *Conn) setWriteDeadLine(d time.Duration) error {
 	return c.nc.SetWriteDeadline(time.Now().Add(d))
 }
@@ -77,28 +151,20 @@ func (c *Conn) handleClose(h ws.Header) error {
 	return nil
 }
 
-// write has two tasks
-// read from connection and write to `ch` channel
-// listen on `ch` channel and write to connection only if the ID of `c` of type `*Conn` doesn't match the id of sender
-func (c *Conn) write(ch chan *wsutil.Message, errc chan error) {
-	for msg := range ch {
-		if err := wsutil.WriteServerMessage(c.nc, msg.OpCode, msg.Payload); err != nil {
-			errc <- err
+// write writes the frames queued by the hub until the hub closes the queue.
+// Frames are shared with every other connection, they are written as they are.
+func (c *Conn) write(errc chan error) {
+	for f := range c.send {
+		// under mx, the reader may write a close frame of its own
+		if err := c.writeBytes(f.bs); err != nil {
+			errc <- c.formatErr(err)
 			return
 		}
-		// connection-specific message handling
-		/*
-			case msg := <-c.send:
-				if err := wsutil.WriteServerMessage(c.nc, msg.OpCode, msg.Payload); err != nil {
-					errc <- err
-					return
-				}
-		*/
 	}
 }
 
 // TODO: don't return on error, use error channel instead
-func (c *Conn) read(ch chan *wsutil.Message, unregister chan uuid.UUID, errc chan error) {
+func (c *Conn) read(ch chan *message, unregister chan uuid.UUID, errc chan error) {
 	defer func() {
 		unregister <- c.id
 		err := c.nc.Close()
@@ -115,9 +181,13 @@ func (c *Conn) read(ch chan *wsutil.Message, unregister chan uuid.UUID, errc cha
 	}
 
 	cr := wsutil.NewServerSideReader(c.nc)
+	cr.MaxFrameSize = c.maxMessageSize
 
 	for {
 		header, err := cr.NextFrame()
+		if errors.Is(err, wsutil.ErrFrameTooLarge) {
+			err = c.tooBig()
+		}
 		if err != nil {
 			errc <- c.formatErr(err)
 			return
@@ -141,13 +211,21 @@ func (c *Conn) read(ch chan *wsutil.Message, unregister chan uuid.UUID, errc cha
 			continue
 		}
 
-		p, err := io.ReadAll(cr)
+		// frames are bounded by the reader, a message of many frames is bounded here
+		buf, err := bufpool.ReadAll(io.LimitReader(cr, c.maxMessageSize+1), int(header.Length))
+		if err == nil && int64(len(buf.B)) > c.maxMessageSize {
+			buf.Release()
+			err = errMessageTooBig
+		}
+		if errors.Is(err, wsutil.ErrFrameTooLarge) || errors.Is(err, errMessageTooBig) {
+			err = c.tooBig()
+		}
 		if err != nil {
 			errc <- c.formatErr(err)
 			return
 		}
 
-		ch <- &wsutil.Message{OpCode: header.OpCode, Payload: p}
+		ch <- &message{op: header.OpCode, buf: buf}
 	}
 
 }
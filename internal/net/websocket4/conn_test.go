@@ -0,0 +1,74 @@
+package websocket4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/google/uuid"
+)
+
+func TestReadTooBig(t *testing.T) {
+	const max = 100
+
+	tests := []struct {
+		name   string
+		frames []ws.Frame
+		// header sent after the frames, without its payload
+		header *ws.Header
+	}{
+		{"oversized length", nil, &ws.Header{Fin: true, OpCode: ws.OpBinary, Length: 1 << 62, Masked: true}},
+		{
+			"reassembled message",
+			[]ws.Frame{
+				ws.MaskFrame(ws.NewFrame(ws.OpBinary, false, make([]byte, 60))),
+				ws.MaskFrame(ws.NewFrame(ws.OpContinuation, true, make([]byte, 60))),
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+
+			c, err := newConn(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.maxMessageSize = max
+
+			ch := make(chan *message, 1)
+			unregister := make(chan uuid.UUID, 1)
+			errc := make(chan error, 2)
+			go c.read(ch, unregister, errc)
+
+			go func() {
+				for _, f := range tt.frames {
+					_ = ws.WriteFrame(client, f)
+				}
+				if tt.header != nil {
+					_ = ws.WriteHeader(client, *tt.header)
+				}
+			}()
+
+			f, err := ws.ReadFrame(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Header.OpCode != ws.OpClose {
+				t.Fatalf("got %v, want a close frame", f.Header.OpCode)
+			}
+			if code, _ := ws.ParseCloseFrameData(f.Payload); code != ws.StatusMessageTooBig {
+				t.Errorf("closed with %d, want %d", code, ws.StatusMessageTooBig)
+			}
+			if id := <-unregister; id != c.id {
+				t.Errorf("unregistered %v", id)
+			}
+			if len(ch) > 0 {
+				t.Error("message too big handed to the hub")
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package websocket4
+
+import (
+	"github.com/gobwas/ws"
+)
+
+// frame is a message compiled into its wire representation once.
+// The same bytes are written to every connection, so they must never be modified.
+type frame struct {
+	op ws.OpCode
+	bs []byte
+}
+
+func newFrame(op ws.OpCode, payload []byte) (*frame, error) {
+	bs, err := ws.CompileFrame(ws.NewFrame(op, true, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return &frame{op: op, bs: bs}, nil
+}
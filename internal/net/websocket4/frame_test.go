@@ -0,0 +1,95 @@
+package websocket4
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// BenchmarkFanOut broadcasts through the hub to subscribers on the other end of in-memory connections,
+// an operation ends when every subscriber has read the frame.
+func BenchmarkFanOut(b *testing.B) {
+	payload := make([]byte, 64) // about the size of an encoded sample
+
+	for _, n := range []int{100, 1_000, 10_000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			h := newHub(0)
+			got := make(chan struct{}, n)
+			for range n {
+				server, client := net.Pipe()
+				conn, err := newConn(server)
+				if err != nil {
+					b.Fatal(err)
+				}
+				h.connections[conn.id] = conn
+				go conn.write(h.errc)
+				go func() {
+					for {
+						hdr, err := ws.ReadHeader(client)
+						if err != nil {
+							return
+						}
+						if _, err := io.CopyN(io.Discard, client, hdr.Length); err != nil {
+							return
+						}
+						got <- struct{}{}
+					}
+				}()
+				b.Cleanup(func() {
+					_ = client.Close()
+					_ = server.Close()
+				})
+			}
+			b.Cleanup(func() {
+				for _, conn := range h.connections {
+					conn.close()
+				}
+			})
+
+			b.ReportAllocs()
+			for b.Loop() {
+				h.broadcastMsg(ws.OpBinary, payload)
+				for range n {
+					<-got
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFraming compares framing a broadcast for every subscriber with framing it once, writes left out.
+func BenchmarkFraming(b *testing.B) {
+	payload := make([]byte, 64)
+
+	for _, n := range []int{100, 1_000, 10_000} {
+		b.Run(fmt.Sprintf("per-subscriber/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				for range n {
+					if err := wsutil.WriteServerMessage(io.Discard, ws.OpBinary, payload); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("pre-framed/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				f, err := newFrame(ws.OpBinary, payload)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for range n {
+					if _, err := io.Discard.Write(f.bs); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
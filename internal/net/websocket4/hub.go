@@ -2,49 +2,95 @@ package websocket4
 
 import (
 	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
 	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+	"github.com/pmoieni/project-racer-server/internal/net/netpoll"
 )
 
 const (
 	pingPeriod = (pongWait * 9) / 10
 )
 
+// message is a data message read from a connection.
+// Sending it on the hub's broadcast channel hands the buffer over to the hub.
+type message struct {
+	op  ws.OpCode
+	buf *bufpool.Buffer
+}
+
 type Hub struct {
 	mx         *sync.Mutex
 	register   chan *Conn
 	unregister chan uuid.UUID
 	// should only allow messages with `ws.OpText` or `ws.OpBinary`
-	broadcast   chan *wsutil.Message
+	broadcast   chan *message
 	errc        chan error
 	connections map[uuid.UUID]*Conn
 	upgrader    *ws.HTTPUpgrader
+	// set by NewPolledHub
+	poller *netpoll.Poller
+	pool   *workerPool
+
+	admission *admission.Controller
 
-	// Capacity of the send channel.
-	// If capacity is 0, the send channel is unbuffered.
-	Capacity uint
+	// Identify tells who is connecting, everyone is an anonymous spectator if nil.
+	Identify func(r *http.Request) admission.Request
+	// MaxMessageSize is the largest message read from a connection, DefaultMaxMessageSize if 0.
+	// A bigger one closes the connection with 1009.
+	MaxMessageSize int64
+}
+
+// DefaultMaxMessageSize fits the largest envelope.
+const DefaultMaxMessageSize = msg.MaxSize
+
+func (h *Hub) maxMessageSize() int64 {
+	if h.MaxMessageSize > 0 {
+		return h.MaxMessageSize
+	}
+
+	return DefaultMaxMessageSize
+}
+
+// SetLimits bounds the connections the hub accepts, NewHub only sets the total.
+func (h *Hub) SetLimits(l admission.Limits) {
+	h.admission.SetLimits(l)
+}
+
+func (h *Hub) identify(r *http.Request) admission.Request {
+	if h.Identify != nil {
+		return h.Identify(r)
+	}
+
+	return admission.Request{IP: admission.RemoteIP(r.RemoteAddr)}
 }
 
 func NewHub(cap uint) *Hub {
-	hub := &Hub{
+	hub := newHub(cap)
+
+	go hub.listen()
+
+	return hub
+}
+
+func newHub(cap uint) *Hub {
+	return &Hub{
 		mx:          &sync.Mutex{},
 		register:    make(chan *Conn),
 		unregister:  make(chan uuid.UUID),
-		broadcast:   make(chan *wsutil.Message),
+		broadcast:   make(chan *message),
 		errc:        make(chan error),
 		connections: make(map[uuid.UUID]*Conn),
 		upgrader:    &ws.HTTPUpgrader{},
-		Capacity:    cap,
+		admission:   admission.New(admission.Limits{Total: int(cap)}),
 	}
-
-	go hub.listen()
-
-	return hub
 }
 
 // Len returns the number of connections.
@@ -69,47 +115,63 @@ func (h *Hub) listen() {
 			h.mx.Unlock()
 		case cid := <-h.unregister:
 			h.mx.Lock()
-			// close(conn.send)
-			delete(h.connections, cid)
+			if conn, ok := h.connections[cid]; ok {
+				conn.close()
+				delete(h.connections, cid)
+			}
 			h.mx.Unlock()
+		case msg := <-h.broadcast:
+			h.broadcastMsg(msg.op, msg.buf.B)
+			// the frame holds its own copy of the payload
+			msg.buf.Release()
 		case err := <-h.errc:
 			log.Println(err)
-		case <-ticker.C:
-			h.broadcast <- &wsutil.Message{OpCode: ws.OpPing, Payload: nil}
+		case now := <-ticker.C:
+			h.broadcastMsg(ws.OpPing, nil)
+			if h.poller != nil {
+				h.sweep(now)
+			}
 		}
 	}
 }
 
-/*
-func (h *Hub) broadcastMsg(msg *wsutil.Message) {
-	for conn := range h.connections {
-		select {
-		case conn.send <- msg:
-		default:
+// broadcastMsg frames the message once and queues the same frame for every connection
+func (h *Hub) broadcastMsg(op ws.OpCode, payload []byte) {
+	f, err := newFrame(op, payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for cid, conn := range h.connections {
+		if !conn.enqueue(f, h.errc) {
 			// From Gorilla WS
 			// https://github.com/gorilla/websocket/tree/master/examples/chat#hub
 			// If the client’s send buffer is full, then the hub assumes that the client is dead or stuck. In this case, the hub unregisters the client and closes the websocket
-			slog.Debug("conn.send channel buffer possible full\n")
-			slog.Debug("broadcast channel handler: default case:\nopCode: %d\npayload: %+v\n", msg.OpCode, msg.Payload)
-			h.mx.Lock()
-			close(conn.send)
-			delete(h.connections, conn)
-			h.mx.Unlock()
+			slog.Debug("conn.send channel buffer possibly full", "conn", cid)
+			if conn.pool != nil {
+				h.detach(conn)
+			}
+			conn.close()
+			delete(h.connections, cid)
 		}
 	}
 }
-*/
 
 func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println("http handler")
-	// TODO check capacity
-	if h.Capacity > 0 && h.Len() >= int(h.Capacity) {
-		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+	release, err := h.admission.Admit(h.identify(r))
+	if err != nil {
+		h.admission.Reject(w, err)
 		return
 	}
 
 	nc, _, _, err := h.upgrader.Upgrade(r, w)
 	if err != nil {
+		release()
 		// TODO log that there was an error
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -117,13 +179,20 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	conn, err := newConn(nc)
 	if err != nil {
+		release()
 		http.Error(w, "failed to establish connection", http.StatusInternalServerError)
 		return
 	}
+	conn.release = release
+	conn.maxMessageSize = h.maxMessageSize()
 
-	go conn.read(h.broadcast, h.unregister, h.errc)
-	go conn.write(h.broadcast, h.errc)
+	if h.poller != nil && h.poll(conn) {
+		return
+	}
 
 	h.register <- conn
 
+	go conn.read(h.broadcast, h.unregister, h.errc)
+	go conn.write(h.errc)
+
 }
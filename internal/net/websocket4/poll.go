@@ -0,0 +1,398 @@
+package websocket4
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/pmoieni/project-racer-server/internal/net/bufpool"
+	"github.com/pmoieni/project-racer-server/internal/net/netpoll"
+)
+
+/*
+polled mode
+
+- the hub owns a netpoll.Poller and a small pool of workers
+- an upgraded connection is handed to the poller instead of getting read and write goroutines
+- when it becomes readable, a worker reads what has arrived without blocking, handles the complete frames and
+  re-arms it, a frame cut short waits in the connection's buffer for the next readiness event
+- frames queued for it are written by a worker scheduled on the first queued frame
+- an idle connection holds no goroutine and no buffer, only its entry in the hub
+- a connection has at most one read and one flush scheduled at a time, the one-shot poller and the flushing
+  flag see to that, so the pool's queue never holds more than two tasks per admitted connection
+*/
+
+// Bytes a polled connection reads at once, a frame bigger than that grows its buffer.
+const readChunk = 4 << 10
+
+// workerPool runs tasks on a fixed set of goroutines
+type workerPool struct {
+	mx    *sync.Mutex
+	ready *sync.Cond
+	tasks []func()
+}
+
+func newWorkerPool(workers int) *workerPool {
+	mx := &sync.Mutex{}
+	p := &workerPool{mx: mx, ready: sync.NewCond(mx)}
+	for range workers {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		p.mx.Lock()
+		for len(p.tasks) == 0 {
+			p.ready.Wait()
+		}
+		task := p.tasks[0]
+		p.tasks[0] = nil
+		p.tasks = p.tasks[1:]
+		p.mx.Unlock()
+
+		task()
+	}
+}
+
+// schedule never blocks and never starts a goroutine: the hub and the poller call it and must keep going.
+// The queue is bounded by the connections, see above.
+func (p *workerPool) schedule(task func()) {
+	p.mx.Lock()
+	p.tasks = append(p.tasks, task)
+	p.mx.Unlock()
+	p.ready.Signal()
+}
+
+// NewPolledHub is like NewHub, but connections are watched by an epoll based poller
+// and served from `workers` goroutines instead of two goroutines each.
+// Connections the poller can't watch, TLS ones for instance, are served the usual way.
+func NewPolledHub(cap uint, workers int) (*Hub, error) {
+	if workers <= 0 {
+		return nil, errors.New("websocket4: polled hub needs at least one worker")
+	}
+
+	poller, err := netpoll.New()
+	if err != nil {
+		return nil, err
+	}
+
+	hub := newHub(cap)
+	hub.poller = poller
+	hub.pool = newWorkerPool(workers)
+
+	go hub.listen()
+
+	return hub, nil
+}
+
+// poll hands conn to the poller, false if it has to be served by goroutines
+func (h *Hub) poll(conn *Conn) bool {
+	fd, err := netpoll.Fd(conn.nc)
+	if err != nil {
+		return false
+	}
+	raw, err := netpoll.Raw(conn.nc)
+	if err != nil {
+		return false
+	}
+
+	conn.fd = fd
+	conn.raw = raw
+	conn.pool = h.pool
+	conn.drop = func() { h.dropPolled(conn) }
+	conn.lastSeen.Store(time.Now().UnixNano())
+
+	h.register <- conn
+
+	if err := h.poller.Start(fd, func(e netpoll.Event) {
+		h.pool.schedule(func() { h.readPolled(conn, e) })
+	}); err != nil {
+		h.errc <- conn.formatErr(err)
+		h.dropPolled(conn)
+	}
+
+	return true
+}
+
+// readPolled runs on the pool when conn is readable, it never waits for bytes that haven't arrived
+func (h *Hub) readPolled(conn *Conn, e netpoll.Event) {
+	if e&netpoll.EventRead == 0 {
+		h.dropPolled(conn)
+		return
+	}
+
+	msgs, err := conn.readAvailable()
+	for _, msg := range msgs {
+		h.broadcast <- msg
+	}
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			h.errc <- conn.formatErr(err)
+		}
+		conn.releaseIn()
+		h.dropPolled(conn)
+		return
+	}
+
+	if conn.detached.Load() {
+		return
+	}
+	if err := h.poller.Resume(conn.fd); err != nil {
+		h.errc <- conn.formatErr(err)
+		h.dropPolled(conn)
+	}
+}
+
+func (h *Hub) dropPolled(conn *Conn) {
+	if h.detach(conn) {
+		h.unregister <- conn.id
+	}
+}
+
+// detach stops watching conn before closing it, so its descriptor can't be reused under the poller.
+// Only the first call does anything.
+func (h *Hub) detach(conn *Conn) bool {
+	if !conn.detached.CompareAndSwap(false, true) {
+		return false
+	}
+	_ = h.poller.Stop(conn.fd)
+	_ = conn.nc.Close()
+
+	return true
+}
+
+// sweep drops polled connections that stopped answering pings.
+// Goroutine-served connections rely on their read deadline instead.
+func (h *Hub) sweep(now time.Time) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for cid, conn := range h.connections {
+		if conn.pool == nil || now.Sub(time.Unix(0, conn.lastSeen.Load())) < 2*pongWait {
+			continue
+		}
+		slog.Debug("polled conn timed out", "conn", cid)
+		if h.detach(conn) {
+			conn.close()
+			delete(h.connections, cid)
+		}
+	}
+}
+
+var errMessageTooBig = errors.New("websocket4: message too big")
+
+// readAvailable reads what has arrived without waiting for more and returns the messages it completed.
+// A frame cut short stays in c.in until the next readiness event, a connection that isn't in the middle of one
+// holds no buffer.
+func (c *Conn) readAvailable() ([]*message, error) {
+	var msgs []*message
+	for {
+		if c.in == nil {
+			c.in = bufpool.Get(readChunk)
+			c.in.B = c.in.B[:0]
+		}
+		if len(c.in.B) == cap(c.in.B) {
+			c.grow(len(c.in.B) + readChunk)
+		}
+
+		n, err := netpoll.Read(c.raw, c.in.B[len(c.in.B):cap(c.in.B)])
+		c.in.B = c.in.B[:len(c.in.B)+n]
+		for {
+			msg, ok, ferr := c.nextFrame()
+			if msg != nil {
+				msgs = append(msgs, msg)
+			}
+			if ferr != nil {
+				return msgs, ferr
+			}
+			if !ok {
+				break
+			}
+		}
+
+		switch {
+		case errors.Is(err, netpoll.ErrWouldBlock):
+			if len(c.in.B) == 0 {
+				c.releaseIn()
+			}
+			return msgs, nil
+		case err != nil:
+			return msgs, err
+		case n == 0:
+			return msgs, io.EOF
+		}
+	}
+}
+
+// nextFrame handles the first frame in c.in and drops it from there, false if it hasn't fully arrived yet.
+// It returns a message once its last fragment is handled.
+func (c *Conn) nextFrame() (msg *message, ok bool, err error) {
+	b := c.in.B
+	header, err := ws.ReadHeader(bytes.NewReader(b))
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the length comes from the client, nothing is allocated before it's checked
+	size := header.Length
+	if header.OpCode == ws.OpContinuation && c.fragments != nil {
+		size += int64(len(c.fragments.B))
+	}
+	if size > c.maxMessageSize || (header.OpCode.IsControl() && header.Length > ws.MaxControlFramePayloadSize) {
+		return nil, false, c.tooBig()
+	}
+
+	start := ws.HeaderSize(header)
+	end := start + int(header.Length)
+	if len(b) < end {
+		if cap(b) < end {
+			c.grow(end)
+		}
+		return nil, false, nil
+	}
+	// only a complete frame tells the peer is alive, one trickling in a byte at a time doesn't
+	c.lastSeen.Store(time.Now().UnixNano())
+
+	payload := b[start:end]
+	if header.Masked {
+		ws.Cipher(payload, header.Mask, 0)
+	}
+	msg, err = c.handleFrame(header, payload)
+	c.in.B = b[:copy(b, b[end:])]
+
+	return msg, true, err
+}
+
+// handleFrame takes a frame whose payload is only valid during the call.
+// It returns a message once its last fragment is handled, nil otherwise.
+func (c *Conn) handleFrame(header ws.Header, payload []byte) (*message, error) {
+	if header.OpCode.IsControl() {
+		return nil, c.handleControl(header, payload)
+	}
+
+	switch {
+	case header.OpCode == ws.OpContinuation:
+		if c.fragments == nil {
+			return nil, ws.ErrProtocolContinuationUnexpected
+		}
+		// messages rarely come in fragments, they are reassembled in a buffer of their own
+		c.fragments.B = append(c.fragments.B, payload...)
+	case header.OpCode&(ws.OpText|ws.OpBinary) != 0:
+		if c.fragments != nil {
+			return nil, ws.ErrProtocolContinuationExpected
+		}
+		c.fragmented = header.OpCode
+		c.fragments = bufpool.Get(len(payload))
+		copy(c.fragments.B, payload)
+	default:
+		return nil, ws.ErrProtocolOpCodeReserved
+	}
+
+	if !header.Fin {
+		return nil, nil
+	}
+
+	msg := &message{op: c.fragmented, buf: c.fragments}
+	c.fragmented, c.fragments = 0, nil
+
+	return msg, nil
+}
+
+// grow moves what c.in holds to a buffer of at least n bytes
+func (c *Conn) grow(n int) {
+	b := bufpool.Get(n)
+	b.B = b.B[:copy(b.B, c.in.B)]
+	c.in.Release()
+	c.in = b
+}
+
+func (c *Conn) releaseIn() {
+	if c.in != nil {
+		c.in.Release()
+		c.in = nil
+	}
+}
+
+// tooBig closes the connection with 1009 and lets go of the message being reassembled
+func (c *Conn) tooBig() error {
+	if c.fragments != nil {
+		c.fragments.Release()
+		c.fragmented, c.fragments = 0, nil
+	}
+	_ = c.writeFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusMessageTooBig, "message too big")))
+
+	return errMessageTooBig
+}
+
+// handleControl answers control frames itself, the goroutine mode leaves that to the reader
+func (c *Conn) handleControl(h ws.Header, payload []byte) error {
+	if err := c.controlHandler(h); err != nil {
+		return err
+	}
+
+	switch h.OpCode {
+	case ws.OpPing:
+		return c.writeFrame(ws.NewPongFrame(payload))
+	case ws.OpClose:
+		_ = c.writeFrame(ws.NewCloseFrame(payload))
+		return io.EOF
+	}
+
+	return nil
+}
+
+func (c *Conn) writeFrame(f ws.Frame) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if err := c.setWriteDeadLine(writeWait); err != nil {
+		return err
+	}
+
+	return ws.WriteFrame(c.nc, f)
+}
+
+// flush writes the queued frames of a polled connection, it runs on the worker pool
+func (c *Conn) flush(errc chan error) {
+	for {
+		c.queueMx.Lock()
+		frames := c.queue
+		c.queue = nil
+		if len(frames) == 0 || c.closed {
+			c.flushing = false
+			c.queueMx.Unlock()
+			return
+		}
+		c.queueMx.Unlock()
+
+		for _, f := range frames {
+			if err := c.writeBytes(f.bs); err != nil {
+				errc <- c.formatErr(err)
+				c.drop()
+				return
+			}
+		}
+	}
+}
+
+func (c *Conn) writeBytes(bs []byte) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if err := c.setWriteDeadLine(writeWait); err != nil {
+		return err
+	}
+
+	_, err := c.nc.Write(bs)
+	return err
+}
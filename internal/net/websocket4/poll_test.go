@@ -0,0 +1,216 @@
+package websocket4
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/pmoieni/project-racer-server/internal/net/netpoll"
+)
+
+// polledPair returns a connection read the way the poller's workers read it, and its peer
+func polledPair(t *testing.T) (*Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	c, err := newConn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.raw, err = netpoll.Raw(server); err != nil {
+		t.Skip(err)
+	}
+
+	return c, client
+}
+
+// readAvailable reads c like readiness events would until it completed n messages or failed
+func readAvailable(t *testing.T, c *Conn, n int) ([]*message, error) {
+	t.Helper()
+	var msgs []*message
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		read, err := c.readAvailable()
+		msgs = append(msgs, read...)
+		if len(msgs) >= n || err != nil {
+			return msgs, err
+		}
+	}
+	t.Fatalf("%d messages read, want %d", len(msgs), n)
+	return nil, nil
+}
+
+func TestReadAvailableTooBig(t *testing.T) {
+	const max = 100
+
+	tests := []struct {
+		name string
+		// frames sent before the one too big, read in full
+		before []ws.Frame
+		header ws.Header
+	}{
+		{"control frame", nil, ws.Header{Fin: true, OpCode: ws.OpPing, Length: ws.MaxControlFramePayloadSize + 1}},
+		{"message", nil, ws.Header{Fin: true, OpCode: ws.OpBinary, Length: max + 1}},
+		{"length that doesn't fit in memory", nil, ws.Header{Fin: true, OpCode: ws.OpBinary, Length: 1 << 62}},
+		{
+			"reassembled message",
+			[]ws.Frame{ws.NewFrame(ws.OpBinary, false, make([]byte, 60))},
+			ws.Header{Fin: true, OpCode: ws.OpContinuation, Length: 60},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := polledPair(t)
+			c.maxMessageSize = max
+
+			for _, f := range tt.before {
+				if err := ws.WriteFrame(client, f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// only the header, nothing is read past it
+			if err := ws.WriteHeader(client, tt.header); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := readAvailable(t, c, 1); !errors.Is(err, errMessageTooBig) {
+				t.Errorf("got %v, want %v", err, errMessageTooBig)
+			}
+			f, err := ws.ReadFrame(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Header.OpCode != ws.OpClose {
+				t.Fatalf("got %v, want a close frame", f.Header.OpCode)
+			}
+			if code, _ := ws.ParseCloseFrameData(f.Payload); code != ws.StatusMessageTooBig {
+				t.Errorf("closed with %d, want %d", code, ws.StatusMessageTooBig)
+			}
+			if c.fragments != nil {
+				t.Error("reassembled fragments kept")
+			}
+		})
+	}
+}
+
+// a message as big as allowed still makes it through, fragmented or not
+func TestReadAvailableMaxSize(t *testing.T) {
+	c, client := polledPair(t)
+	c.maxMessageSize = 100
+
+	go func() {
+		_ = wsutil.WriteClientMessage(client, ws.OpBinary, make([]byte, 100))
+		_ = ws.WriteFrame(client, ws.MaskFrame(ws.NewFrame(ws.OpBinary, false, make([]byte, 50))))
+		_ = ws.WriteFrame(client, ws.MaskFrame(ws.NewFrame(ws.OpContinuation, true, make([]byte, 50))))
+	}()
+
+	msgs, err := readAvailable(t, c, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range msgs {
+		if len(m.buf.B) != 100 {
+			t.Errorf("message %d has %d bytes", i, len(m.buf.B))
+		}
+		m.buf.Release()
+	}
+}
+
+// a frame that arrives in pieces is put together over several readiness events, none of which waits for the rest
+func TestReadAvailablePartial(t *testing.T) {
+	c, client := polledPair(t)
+
+	payload := make([]byte, 3*readChunk)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	frame, err := ws.CompileFrame(ws.MaskFrame(ws.NewFrame(ws.OpBinary, true, payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a byte of the header, the rest of it with some of the payload, then all but the last byte
+	last := len(frame) - 1
+	for _, part := range [][]byte{frame[:1], frame[1:100], frame[100:last]} {
+		if _, err := client.Write(part); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			// give the bytes time to arrive, then read them
+			time.Sleep(10 * time.Millisecond)
+			msgs, err := c.readAvailable()
+			if err == nil && len(msgs) > 0 {
+				err = errors.New("message completed early")
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("read waited for the rest of the frame")
+		}
+	}
+
+	if _, err := client.Write(frame[last:]); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := readAvailable(t, c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msgs[0].buf.B; len(got) != len(payload) || got[len(got)-1] != payload[len(payload)-1] {
+		t.Errorf("got %d bytes", len(got))
+	}
+	msgs[0].buf.Release()
+	if c.in != nil {
+		t.Error("idle connection kept its read buffer")
+	}
+}
+
+// tasks wait in the queue rather than getting goroutines of their own, however many are scheduled
+func TestWorkerPoolSchedule(t *testing.T) {
+	const tasks = 1000
+	p := newWorkerPool(2)
+	gate := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(tasks)
+
+	before := runtime.NumGoroutine()
+	for range tasks {
+		p.schedule(func() {
+			<-gate
+			wg.Done()
+		})
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines started", n-before)
+	}
+
+	close(gate)
+	wg.Wait()
+}
@@ -0,0 +1,405 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+type connResponse struct {
+	ID                string    `json:"id"`
+	Identity          string    `json:"identity,omitempty"`
+	Role              string    `json:"role"`
+	Driver            string    `json:"driver,omitempty"`
+	RemoteAddr        string    `json:"remote_addr"`
+	Topics            []string  `json:"topics"`
+	ConnectedAt       time.Time `json:"connected_at"`
+	RTTMillis         float64   `json:"rtt_ms"`
+	QueueDepth        int       `json:"queue_depth"`
+	Muted             bool      `json:"muted"`
+	ClockSynced       bool      `json:"clock_synced"`
+	ClockOffsetMillis float64   `json:"clock_offset_ms"`
+	ClockDriftPPM     float64   `json:"clock_drift_ppm"`
+	MessagesSent      uint64    `json:"messages_sent"`
+	MessagesReceived  uint64    `json:"messages_received"`
+	BytesSent         uint64    `json:"bytes_sent"`
+	BytesReceived     uint64    `json:"bytes_received"`
+	WireBytesSent     uint64    `json:"wire_bytes_sent"`
+	WireBytesReceived uint64    `json:"wire_bytes_received"`
+	CompressionRatio  float64   `json:"compression_ratio"`
+}
+
+type topicResponse struct {
+	Name             string  `json:"name"`
+	Subscribers      int     `json:"subscribers"`
+	SpectatorDelay   string  `json:"spectator_delay"`
+	DelayedDropped   uint64  `json:"delayed_dropped"`
+	BytesSent        uint64  `json:"bytes_sent"`
+	WireBytesSent    uint64  `json:"wire_bytes_sent"`
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+func newConnResponse(c ws.ConnInfo) *connResponse {
+	return &connResponse{
+		ID:                c.ID.String(),
+		Identity:          c.Identity.ID,
+		Role:              string(c.Identity.Role),
+		Driver:            c.Identity.Driver,
+		RemoteAddr:        c.RemoteAddr,
+		Topics:            []string{c.Topic},
+		ConnectedAt:       c.ConnectedAt,
+		RTTMillis:         float64(c.RTT) / float64(time.Millisecond),
+		QueueDepth:        c.QueueDepth,
+		Muted:             c.Muted,
+		ClockSynced:       c.Clock.Synced,
+		ClockOffsetMillis: float64(c.Clock.Offset) / float64(time.Millisecond),
+		ClockDriftPPM:     c.Clock.Drift,
+		MessagesSent:      c.Stats.MessagesSent,
+		MessagesReceived:  c.Stats.MessagesReceived,
+		BytesSent:         c.Stats.BytesSent,
+		BytesReceived:     c.Stats.BytesReceived,
+		WireBytesSent:     c.Stats.WireBytesSent,
+		WireBytesReceived: c.Stats.WireBytesReceived,
+		CompressionRatio:  c.Stats.CompressionRatio(),
+	}
+}
+
+// handleAdmin serves h on pattern to race control.
+// Without an authenticator nobody could prove to be race control, the endpoint isn't served at all.
+func (s *TelemetryService) handleAdmin(pattern string, h http.HandlerFunc) {
+	if s.auth == nil {
+		return
+	}
+	s.HandleFunc(pattern, raceControlOnly(s, h))
+}
+
+func listConns(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conns := hub.Connections()
+		res := make([]*connResponse, 0, len(conns))
+		for _, c := range conns {
+			res = append(res, newConnResponse(c))
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+func getConn(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid connection id", http.StatusBadRequest)
+			return
+		}
+
+		c, ok := hub.Connection(id)
+		if !ok {
+			http.Error(w, "connection not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, newConnResponse(c))
+	}
+}
+
+func listTopics(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		topics := hub.Topics()
+		res := make([]*topicResponse, 0, len(topics))
+		for _, t := range topics {
+			res = append(res, &topicResponse{
+				Name:             t.Name,
+				Subscribers:      t.Subscribers,
+				SpectatorDelay:   t.SpectatorDelay.String(),
+				DelayedDropped:   t.DelayedDropped,
+				BytesSent:        t.Stats.BytesSent,
+				WireBytesSent:    t.Stats.WireBytesSent,
+				CompressionRatio: t.Stats.CompressionRatio(),
+			})
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+type kickRequest struct {
+	// Code must be an application close code (4000-4999), ws.StatusKicked if omitted.
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (p *kickRequest) validate() error {
+	if p.Code != 0 && (p.Code < 4000 || p.Code > 4999) {
+		return errors.New("code must be between 4000 and 4999")
+	}
+	return nil
+}
+
+type muteRequest struct {
+	Muted bool `json:"muted"`
+}
+
+func (p *muteRequest) validate() error {
+	return nil
+}
+
+type banRequest struct {
+	Identity string `json:"identity"`
+	IP       string `json:"ip"`
+	Reason   string `json:"reason"`
+	// Duration is a time.ParseDuration string such as "30m".
+	Duration string `json:"duration"`
+
+	duration time.Duration
+}
+
+func (p *banRequest) validate() error {
+	d, err := time.ParseDuration(p.Duration)
+	if err != nil || d <= 0 {
+		return errors.New("duration must be a positive duration such as 30m")
+	}
+	p.duration = d
+	return nil
+}
+
+type banResponse struct {
+	ID       string    `json:"id"`
+	Identity string    `json:"identity,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Reason   string    `json:"reason"`
+	Until    time.Time `json:"until"`
+}
+
+func newBanResponse(b *ws.Ban) *banResponse {
+	return &banResponse{
+		ID:       b.ID.String(),
+		Identity: b.Identity,
+		IP:       b.IP,
+		Reason:   b.Reason,
+		Until:    b.Until,
+	}
+}
+
+func kickConn(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid connection id", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodeParams[*kickRequest](w, r)
+		if !ok {
+			return
+		}
+
+		code := ws.StatusKicked
+		if req.Code != 0 {
+			code = websocket.StatusCode(req.Code)
+		}
+
+		if err := hub.Kick(id, code, req.Reason); err != nil {
+			writeHubErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func muteConn(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid connection id", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodeParams[*muteRequest](w, r)
+		if !ok {
+			return
+		}
+
+		if err := hub.Mute(id, req.Muted); err != nil {
+			writeHubErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func listBans(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bans := hub.Bans()
+		res := make([]*banResponse, 0, len(bans))
+		for i := range bans {
+			res = append(res, newBanResponse(&bans[i]))
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+func createBan(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeParams[*banRequest](w, r)
+		if !ok {
+			return
+		}
+
+		b, err := hub.Ban(ws.Ban{
+			Identity: req.Identity,
+			IP:       req.IP,
+			Reason:   req.Reason,
+			Until:    time.Now().Add(req.duration),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, newBanResponse(b))
+	}
+}
+
+func deleteBan(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid ban id", http.StatusBadRequest)
+			return
+		}
+
+		if !hub.Unban(id) {
+			http.Error(w, "ban not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type stateRequest struct {
+	State EventState `json:"state"`
+}
+
+func (p *stateRequest) validate() error {
+	if !p.State.Valid() {
+		return errors.New("state must be one of draft, scheduled, registration_open, live, finished or cancelled")
+	}
+	return nil
+}
+
+func setEventState(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid event id", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodeParams[*stateRequest](w, r)
+		if !ok {
+			return
+		}
+
+		ev, err := s.TransitionEvent(r.Context(), id, req.State)
+		if err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeStoreErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusOK, ev)
+	}
+}
+
+type flagRequest struct {
+	Flag Flag `json:"flag"`
+}
+
+func (p *flagRequest) validate() error {
+	if !p.Flag.valid() {
+		return errors.New("flag must be one of green, yellow, red or chequered")
+	}
+	return nil
+}
+
+func setFlag(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid event id", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodeParams[*flagRequest](w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.SetFlag(id, req.Flag); err != nil {
+			if errors.Is(err, ErrEventNotRunning) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type delayRequest struct {
+	// Delay is a time.ParseDuration string such as "30s", "0s" removes the delay.
+	Delay string `json:"delay"`
+
+	delay time.Duration
+}
+
+func (p *delayRequest) validate() error {
+	d, err := time.ParseDuration(p.Delay)
+	if err != nil || d < 0 || d > maxSpectatorDelay {
+		return fmt.Errorf("delay must be a duration between 0s and %s", maxSpectatorDelay)
+	}
+	p.delay = d
+	return nil
+}
+
+func setSpectatorDelay(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid event id", http.StatusBadRequest)
+			return
+		}
+
+		req, ok := decodeParams[*delayRequest](w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.SetSpectatorDelay(id, req.delay); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeHubErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, ws.ErrConnNotFound) {
+		http.Error(w, "connection not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// headers are already out, an encoding error can't be reported anymore
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,186 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+// request sends a request to srv with the bearer token if any
+func request(t *testing.T, srv string, method, path, token string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+func TestAdminIntrospectionAuth(t *testing.T) {
+	paths := []string{"/admin/connections", "/admin/connections/" + uuid.NewString(), "/admin/topics"}
+
+	tests := []struct {
+		name  string
+		auth  Authenticator
+		token string
+		want  []int
+	}{
+		{"no authenticator", nil, "", []int{http.StatusNotFound, http.StatusNotFound, http.StatusNotFound}},
+		{"anonymous", testTokens(t), "", []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized}},
+		{"unknown token", testTokens(t), "guess", []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized}},
+		{"spectator", testTokens(t), "fan", []int{http.StatusForbidden, http.StatusForbidden, http.StatusForbidden}},
+		{"driver", testTokens(t), "driver", []int{http.StatusForbidden, http.StatusForbidden, http.StatusForbidden}},
+		{"race control", testTokens(t), "rc", []int{http.StatusOK, http.StatusNotFound, http.StatusOK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := serve(t, &driversRepo{}, tt.auth)
+			for i, path := range paths {
+				if res := request(t, srv.URL, "GET", path, tt.token, nil); res.StatusCode != tt.want[i] {
+					t.Errorf("%s: got %d, want %d", path, res.StatusCode, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// connections lists the hub's connections once there are want of them
+func connections(t *testing.T, srv string, want int) []connResponse {
+	t.Helper()
+	// the hub registers a connection after its handshake
+	var conns []connResponse
+	for deadline := time.Now().Add(time.Second); len(conns) != want && time.Now().Before(deadline); {
+		res := request(t, srv, "GET", "/admin/connections", "rc", nil)
+		if err := json.NewDecoder(res.Body).Decode(&conns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(conns) != want {
+		t.Fatalf("got %d connections, want %d", len(conns), want)
+	}
+
+	return conns
+}
+
+func TestListConns(t *testing.T) {
+	_, srv := serve(t, &driversRepo{}, testTokens(t))
+	dial(t, srv, "/ws/events/1/state", "fan")
+
+	conns := connections(t, srv.URL, 1)
+	if c := conns[0]; c.Identity != "fan" || c.Role != "spectator" || len(c.Topics) != 1 || c.Topics[0] != "events/1/state" {
+		t.Errorf("got %+v", c)
+	}
+
+	res := request(t, srv.URL, "GET", "/admin/connections/"+conns[0].ID, "rc", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got %d getting the connection", res.StatusCode)
+	}
+}
+
+func TestModerationAuth(t *testing.T) {
+	routes := []struct{ method, path, body string }{
+		{"POST", "/admin/connections/" + uuid.NewString() + "/kick", `{}`},
+		{"PUT", "/admin/connections/" + uuid.NewString() + "/mute", `{"muted": true}`},
+		{"GET", "/admin/bans", ""},
+		{"POST", "/admin/bans", `{"ip": "192.0.2.1", "duration": "1m"}`},
+		{"DELETE", "/admin/bans/" + uuid.NewString(), ""},
+		{"PUT", "/admin/events/" + uuid.NewString() + "/flag", `{"flag": "red"}`},
+		{"PUT", "/admin/events/" + uuid.NewString() + "/delay", `{"delay": "30s"}`},
+	}
+
+	tests := []struct {
+		name  string
+		auth  Authenticator
+		token string
+		want  int
+	}{
+		{"no authenticator", nil, "rc", http.StatusNotFound},
+		{"anonymous", testTokens(t), "", http.StatusUnauthorized},
+		{"spectator", testTokens(t), "fan", http.StatusForbidden},
+		{"driver", testTokens(t), "driver", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := serve(t, &driversRepo{}, tt.auth)
+			for _, route := range routes {
+				res := request(t, srv.URL, route.method, route.path, tt.token, strings.NewReader(route.body))
+				if res.StatusCode != tt.want {
+					t.Errorf("%s %s: got %d, want %d", route.method, route.path, res.StatusCode, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestModerationBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"ban", "POST", "/admin/bans", `{"ip": "192.0.2.1", "reason": "spam", "duration": "30m"}`, http.StatusCreated},
+		{"ban unknown field", "POST", "/admin/bans", `{"ip": "192.0.2.1", "duration": "30m", "forever": true}`, http.StatusBadRequest},
+		{"ban without duration", "POST", "/admin/bans", `{"ip": "192.0.2.1"}`, http.StatusUnprocessableEntity},
+		{"ban nobody", "POST", "/admin/bans", `{"duration": "30m"}`, http.StatusBadRequest},
+		{"ban too big", "POST", "/admin/bans", `{"reason": "` + strings.Repeat("x", maxBodySize) + `", "duration": "30m"}`, http.StatusBadRequest},
+		{"kick unknown code", "POST", "/admin/connections/" + uuid.NewString() + "/kick", `{"code": 1000}`, http.StatusUnprocessableEntity},
+		{"kick unknown connection", "POST", "/admin/connections/" + uuid.NewString() + "/kick", `{}`, http.StatusNotFound},
+		{"mute not json", "PUT", "/admin/connections/" + uuid.NewString() + "/mute", `muted`, http.StatusBadRequest},
+		{"flag unknown", "PUT", "/admin/events/" + uuid.NewString() + "/flag", `{"flag": "blue"}`, http.StatusUnprocessableEntity},
+		{"flag not running", "PUT", "/admin/events/" + uuid.NewString() + "/flag", `{"flag": "red"}`, http.StatusNotFound},
+		{"delay too long", "PUT", "/admin/events/" + uuid.NewString() + "/delay", `{"delay": "1h"}`, http.StatusUnprocessableEntity},
+		{"delay", "PUT", "/admin/events/" + uuid.NewString() + "/delay", `{"delay": "30s"}`, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := serve(t, &driversRepo{}, testTokens(t))
+			if res := request(t, srv.URL, tt.method, tt.path, "rc", strings.NewReader(tt.body)); res.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestKickConn(t *testing.T) {
+	_, srv := serve(t, &driversRepo{}, testTokens(t))
+	conn, _ := dial(t, srv, "/ws/events/1/state", "fan")
+	id := connections(t, srv.URL, 1)[0].ID
+
+	res := request(t, srv.URL, "POST", "/admin/connections/"+id+"/kick", "rc", strings.NewReader(`{"reason": "abuse"}`))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %d", res.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			if code := websocket.CloseStatus(err); code != ws.StatusKicked {
+				t.Errorf("closed with %v, want %v", err, ws.StatusKicked)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package telemetry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+var ErrUnauthenticated = errors.New("invalid or expired credentials")
+
+// Authenticator verifies who is behind a request from the token or session it carries.
+type Authenticator interface {
+	// Authenticate returns the identity the credentials belong to, with Authenticated set.
+	// ok is false when the request carries no credentials, ErrUnauthenticated when they don't verify.
+	Authenticate(r *http.Request) (id ws.Identity, ok bool, err error)
+}
+
+// bearer returns the token of the request, from its Authorization header or, since browsers can't set headers on a
+// websocket handshake, from the access_token query parameter
+func bearer(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, found := strings.Cut(h, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+		return strings.TrimSpace(token), true
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, true
+	}
+
+	return "", false
+}
+
+// Tokens authenticates bearer tokens issued ahead of time, each to an identity and a role.
+// Only the digests of the tokens are kept.
+type Tokens map[[sha256.Size]byte]ws.Identity
+
+type tokenEntry struct {
+	Identity string  `json:"identity"`
+	Role     ws.Role `json:"role"`
+}
+
+// LoadTokens reads a JSON object of tokens to the identity and role they were issued to.
+func LoadTokens(path string) (Tokens, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]tokenEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	tokens := make(Tokens, len(entries))
+	for token, e := range entries {
+		if err := tokens.Add(token, e.Identity, e.Role); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return tokens, nil
+}
+
+// Add issues token to identity with role.
+func (t Tokens) Add(token, identity string, role ws.Role) error {
+	switch {
+	case token == "":
+		return errors.New("empty token")
+	case identity == "":
+		return errors.New("token without an identity")
+	}
+	switch role {
+	case ws.RoleSpectator, ws.RoleDriver, ws.RoleRaceControl:
+	default:
+		return fmt.Errorf("identity %s: unknown role %q", identity, role)
+	}
+	t[sha256.Sum256([]byte(token))] = ws.Identity{ID: identity, Role: role, Authenticated: true}
+
+	return nil
+}
+
+func (t Tokens) Authenticate(r *http.Request) (ws.Identity, bool, error) {
+	token, ok := bearer(r)
+	if !ok {
+		return ws.Identity{}, false, nil
+	}
+	id, ok := t[sha256.Sum256([]byte(token))]
+	if !ok {
+		return ws.Identity{}, true, ErrUnauthenticated
+	}
+
+	return id, true, nil
+}
+
+// identify tells who is connecting, anonymous spectators unless the request carries credentials that verify
+func (s *TelemetryService) identify(r *http.Request) (ws.Identity, error) {
+	if s.auth == nil {
+		return ws.Identity{Role: ws.RoleSpectator}, nil
+	}
+	id, ok, err := s.auth.Authenticate(r)
+	if err != nil {
+		return ws.Identity{}, err
+	}
+	if !ok {
+		return ws.Identity{Role: ws.RoleSpectator}, nil
+	}
+
+	return id, nil
+}
+
+func writeAuthErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// raceControlOnly serves next to authenticated race control only
+func raceControlOnly(s *TelemetryService, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.identify(r)
+		if err != nil {
+			writeAuthErr(w, err)
+			return
+		}
+		if !id.Authenticated {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if id.Role != ws.RoleRaceControl {
+			http.Error(w, "race control only", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+// testTokens issues a token per role
+func testTokens(t *testing.T) Tokens {
+	t.Helper()
+	tokens := Tokens{}
+	for token, id := range map[string]ws.Identity{
+		"fan":    {ID: "fan", Role: ws.RoleSpectator},
+		"driver": {ID: "d", Role: ws.RoleDriver},
+		"rc":     {ID: "rc", Role: ws.RoleRaceControl},
+	} {
+		if err := tokens.Add(token, id.ID, id.Role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tokens
+}
+
+func TestIdentify(t *testing.T) {
+	anonymous := ws.Identity{Role: ws.RoleSpectator}
+
+	tests := []struct {
+		name   string
+		auth   Authenticator
+		target string
+		header string
+		want   ws.Identity
+		err    error
+	}{
+		{"no authenticator", nil, "/ws?access_token=rc", "", anonymous, nil},
+		{"declared role", testTokens(t), "/ws?identity=rc&role=race_control", "", anonymous, nil},
+		{"no credentials", testTokens(t), "/ws", "", anonymous, nil},
+		{"header", testTokens(t), "/ws", "Bearer driver", ws.Identity{ID: "d", Role: ws.RoleDriver, Authenticated: true}, nil},
+		{"query", testTokens(t), "/ws?access_token=rc", "", ws.Identity{ID: "rc", Role: ws.RoleRaceControl, Authenticated: true}, nil},
+		{"spectator", testTokens(t), "/ws", "bearer fan", ws.Identity{ID: "fan", Role: ws.RoleSpectator, Authenticated: true}, nil},
+		{"unknown token", testTokens(t), "/ws", "Bearer guess", ws.Identity{}, ErrUnauthenticated},
+		{"unknown query token", testTokens(t), "/ws?access_token=guess", "", ws.Identity{}, ErrUnauthenticated},
+		{"other scheme", testTokens(t), "/ws", "Basic cmM6cmM=", anonymous, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &TelemetryService{auth: tt.auth}
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			id, err := s.identify(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if id != tt.want {
+				t.Errorf("got %+v, want %+v", id, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", `{"secret": {"identity": "rc", "role": "race_control"}}`, false},
+		{"unknown role", `{"secret": {"identity": "rc", "role": "marshal"}}`, true},
+		{"no identity", `{"secret": {"role": "driver"}}`, true},
+		{"empty token", `{"": {"identity": "rc", "role": "race_control"}}`, true},
+		{"not json", `secret=rc`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			tokens, err := LoadTokens(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			r := httptest.NewRequest("GET", "/ws?access_token=secret", nil)
+			if id, _, err := tokens.Authenticate(r); err != nil || id.ID != "rc" {
+				t.Errorf("got %+v, %v", id, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"errors"
+	"math"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// course is the closed centreline of a track, used to tell how far around the lap a car is.
+type course struct {
+	points []msg.Location
+	// cum[i] is the distance from points[0] to points[i] along the centreline
+	cum    []float64
+	length float64
+	// start is the distance from points[0] to the start/finish line
+	start float64
+}
+
+// newCourse follows the centreline of g, which was validated when it was stored
+func newCourse(g *Geometry) (*course, error) {
+	if len(g.Centreline) < minCentrelinePoints {
+		return nil, errors.New("course needs a centreline")
+	}
+	start, err := g.cross(&g.StartFinish, g.cumulative())
+	if err != nil {
+		return nil, err
+	}
+
+	// the closing point is left out, the last point joins the first
+	n := len(g.Centreline) - 1
+	c := &course{points: make([]msg.Location, n), cum: make([]float64, n), start: start}
+	for i, p := range g.Centreline[:n] {
+		c.points[i] = msg.Location{X: float32(p.X), Y: float32(p.Y)}
+	}
+	for i := range c.points {
+		c.cum[i] = c.length
+		c.length += dist(c.points[i], c.points[(i+1)%n])
+	}
+	if c.length == 0 {
+		return nil, errors.New("course has no length")
+	}
+
+	return c, nil
+}
+
+// distance returns how far from the start/finish line the closest point of the centreline to p is
+func (c *course) distance(p msg.Location) float64 {
+	best, at := math.Inf(1), 0.0
+	for i, a := range c.points {
+		b := c.points[(i+1)%len(c.points)]
+		t, d := project(p, a, b)
+		if d < best {
+			best = d
+			at = c.cum[i] + t*dist(a, b)
+		}
+	}
+
+	at = math.Mod(at-c.start, c.length)
+	if at < 0 {
+		at += c.length
+	}
+
+	return at
+}
+
+// project returns where along ab the closest point to p is, as a fraction of ab, and how far p is from it
+func project(p, a, b msg.Location) (float64, float64) {
+	abx, aby := float64(b.X-a.X), float64(b.Y-a.Y)
+	apx, apy := float64(p.X-a.X), float64(p.Y-a.Y)
+
+	t := 0.0
+	if l2 := abx*abx + aby*aby; l2 > 0 {
+		t = math.Max(0, math.Min(1, (apx*abx+apy*aby)/l2))
+	}
+
+	return t, math.Hypot(apx-t*abx, apy-t*aby)
+}
+
+func dist(a, b msg.Location) float64 {
+	return math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y))
+}
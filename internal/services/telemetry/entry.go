@@ -0,0 +1,202 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+/*
+entries
+
+- drivers enter an event sharing a car of one of its classes, each driver once per event
+- a team's entry only has drivers of the team
+- entries are taken while the event is open for registration and inside its registration window if it has one
+- a class with an entry limit takes no more entries than that, withdrawn entries don't count
+- entries can be withdrawn until the event goes live, they are kept for the record
+*/
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrEntriesLocked      = errors.New("entries can't change once the event is live")
+	// ErrInvalidEntry wraps why an entry can't race in the event.
+	ErrInvalidEntry = errors.New("invalid entry")
+)
+
+// RegistrationOpen tells whether ev takes entries at t.
+func (ev *Event) RegistrationOpen(t time.Time) bool {
+	if ev.State != EventRegistrationOpen {
+		return false
+	}
+	if ev.RegistrationOpensAt != nil && t.Before(*ev.RegistrationOpensAt) {
+		return false
+	}
+	if ev.RegistrationClosesAt != nil && !t.Before(*ev.RegistrationClosesAt) {
+		return false
+	}
+
+	return true
+}
+
+// Entrant is what an entry is checked against, as read in the transaction that makes it.
+type Entrant struct {
+	Event *Event
+	// Car and Team are nil if they don't exist.
+	Car  *Car
+	Team *Team
+	// Drivers are the drivers of the entry that exist.
+	Drivers []uuid.UUID
+}
+
+// check tells why p can't enter the event of e at t, if it can't
+func (e *Entrant) check(p *EntryParams, t time.Time) error {
+	if !e.Event.RegistrationOpen(t) {
+		return ErrRegistrationClosed
+	}
+
+	if !slices.ContainsFunc(e.Event.Classes, func(c *Class) bool { return c.ID == p.ClassID }) {
+		return fmt.Errorf("%w: class %s does not race in the event", ErrInvalidEntry, p.ClassID)
+	}
+
+	if e.Car == nil {
+		return fmt.Errorf("%w: car %s does not exist", ErrInvalidEntry, p.CarID)
+	}
+	if e.Car.Class == nil || e.Car.Class.ID != p.ClassID {
+		return fmt.Errorf("%w: car %s does not belong to class %s", ErrInvalidEntry, p.CarID, p.ClassID)
+	}
+
+	for _, id := range p.Drivers {
+		if !slices.Contains(e.Drivers, id) {
+			return fmt.Errorf("%w: driver %s does not exist", ErrInvalidEntry, id)
+		}
+	}
+	if p.TeamID != nil {
+		if e.Team == nil {
+			return fmt.Errorf("%w: team %s does not exist", ErrInvalidEntry, *p.TeamID)
+		}
+		for _, id := range p.Drivers {
+			if !e.Team.drives(id) {
+				return fmt.Errorf("%w: driver %s does not drive for team %s", ErrInvalidEntry, id, e.Team.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Enter registers an entry in an event.
+// It's checked in the transaction that makes it, the event can't close registration or the team drop a driver meanwhile.
+func (s *TelemetryService) Enter(ctx context.Context, eventID uuid.UUID, p *EntryParams) (*Entry, error) {
+	now := time.Now()
+	return s.repo.CreateEntry(ctx, eventID, p, func(e *Entrant) error {
+		return e.check(p, now)
+	})
+}
+
+// Withdraw withdraws an entry of an event, ErrNotFound if the entry isn't one of the event's.
+func (s *TelemetryService) Withdraw(ctx context.Context, eventID, entryID uuid.UUID) (*Entry, error) {
+	entry, err := s.repo.GetEntry(ctx, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.EventID != eventID {
+		return nil, store.ErrNotFound
+	}
+
+	ev, err := s.repo.GetEvent(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	switch ev.State {
+	case EventLive, EventFinished, EventCancelled:
+		return nil, ErrEntriesLocked
+	}
+
+	return s.repo.WithdrawEntry(ctx, entryID)
+}
+
+func getEntry(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, entryID, ok := entryPath(w, r)
+		if !ok {
+			return
+		}
+
+		entry, err := s.repo.GetEntry(r.Context(), entryID)
+		if err == nil && entry.EventID != eventID {
+			err = store.ErrNotFound
+		}
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusOK, entry)
+	}
+}
+
+func createEntry(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		p, ok := decodeParams[*EntryParams](w, r)
+		if !ok {
+			return
+		}
+
+		entry, err := s.Enter(r.Context(), eventID, p)
+		if err != nil {
+			writeEntryErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusCreated, entry)
+	}
+}
+
+func withdrawEntry(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, entryID, ok := entryPath(w, r)
+		if !ok {
+			return
+		}
+
+		entry, err := s.Withdraw(r.Context(), eventID, entryID)
+		if err != nil {
+			writeEntryErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusOK, entry)
+	}
+}
+
+func entryPath(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	eventID, ok := pathID(w, r)
+	if !ok {
+		return eventID, uuid.Nil, false
+	}
+	entryID, err := uuid.Parse(r.PathValue("entry"))
+	if err != nil {
+		http.Error(w, "invalid entry id", http.StatusBadRequest)
+		return eventID, entryID, false
+	}
+
+	return eventID, entryID, true
+}
+
+func writeEntryErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidEntry):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrRegistrationClosed), errors.Is(err, ErrEntriesLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeStoreErr(w, err)
+	}
+}
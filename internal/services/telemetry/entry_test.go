@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// entrantRepo makes entries the check accepts of one entrant
+type entrantRepo struct {
+	TelemetryRepo
+	entrant *Entrant
+}
+
+func (r *entrantRepo) CreateEntry(_ context.Context, eventID uuid.UUID, p *EntryParams, check func(*Entrant) error) (*Entry, error) {
+	if err := check(r.entrant); err != nil {
+		return nil, err
+	}
+	return &Entry{ID: uuid.New(), EventID: eventID, Class: &Class{ID: p.ClassID}, Car: r.entrant.Car, Drivers: p.Drivers}, nil
+}
+
+// entrant is an event open for registration with a class, a car of it, a team and its driver
+func entrant(t time.Time) (*Entrant, *EntryParams) {
+	class, driver, team := uuid.New(), uuid.New(), uuid.New()
+	opens, closes := t.Add(-time.Hour), t.Add(time.Hour)
+	e := &Entrant{
+		Event: &Event{
+			ID:                   uuid.New(),
+			State:                EventRegistrationOpen,
+			Classes:              []*Class{{ID: class}},
+			RegistrationOpensAt:  &opens,
+			RegistrationClosesAt: &closes,
+		},
+		Car:     &Car{ID: uuid.New(), Class: &Class{ID: class}},
+		Team:    &Team{ID: team, Members: []*TeamMember{{Role: TeamDriver, DriverID: &driver}}},
+		Drivers: []uuid.UUID{driver},
+	}
+
+	return e, &EntryParams{ClassID: class, CarID: e.Car.ID, Drivers: []uuid.UUID{driver}, TeamID: &team}
+}
+
+func TestEntrantCheck(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(*Entrant, *EntryParams)
+		want   error
+	}{
+		{"valid", func(*Entrant, *EntryParams) {}, nil},
+		{"privateer", func(_ *Entrant, p *EntryParams) { p.TeamID = nil }, nil},
+		{"scheduled", func(e *Entrant, _ *EntryParams) { e.Event.State = EventScheduled }, ErrRegistrationClosed},
+		{"live", func(e *Entrant, _ *EntryParams) { e.Event.State = EventLive }, ErrRegistrationClosed},
+		{"before the window", func(e *Entrant, _ *EntryParams) {
+			opens := now.Add(time.Minute)
+			e.Event.RegistrationOpensAt = &opens
+		}, ErrRegistrationClosed},
+		{"after the window", func(e *Entrant, _ *EntryParams) {
+			closes := now
+			e.Event.RegistrationClosesAt = &closes
+		}, ErrRegistrationClosed},
+		{"class not racing", func(e *Entrant, p *EntryParams) { p.ClassID = uuid.New() }, ErrInvalidEntry},
+		{"no car", func(e *Entrant, _ *EntryParams) { e.Car = nil }, ErrInvalidEntry},
+		{"car of another class", func(e *Entrant, _ *EntryParams) { e.Car.Class = &Class{ID: uuid.New()} }, ErrInvalidEntry},
+		{"no driver", func(e *Entrant, _ *EntryParams) { e.Drivers = nil }, ErrInvalidEntry},
+		{"no team", func(e *Entrant, _ *EntryParams) { e.Team = nil }, ErrInvalidEntry},
+		{"driver of another team", func(e *Entrant, _ *EntryParams) { e.Team.Members = nil }, ErrInvalidEntry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, p := entrant(now)
+			tt.change(e, p)
+			if err := e.check(p, now); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// entries are checked against what the repo reads in its transaction, not against an earlier read
+func TestCreateEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Entrant, *EntryParams)
+		want   int
+	}{
+		{"valid", func(*Entrant, *EntryParams) {}, http.StatusCreated},
+		{"closed", func(e *Entrant, _ *EntryParams) { e.Event.State = EventScheduled }, http.StatusConflict},
+		{"invalid", func(e *Entrant, _ *EntryParams) { e.Car = nil }, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, p := entrant(time.Now())
+			tt.change(e, p)
+			_, srv := serve(t, &entrantRepo{entrant: e}, nil)
+
+			body, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := request(t, srv.URL, "POST", "/events/"+e.Event.ID.String()+"/entries", "", strings.NewReader(string(body)))
+			if res.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
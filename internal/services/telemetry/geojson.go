@@ -0,0 +1,347 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+/*
+GeoJSON
+
+- a geometry is a FeatureCollection of LineStrings told apart by their "kind" property
+- "centreline" carries the width and the origin, "start_finish", "sector", "pit_entry" and "pit_exit" are gates
+- sectors are in lap order, or in the order of their "index" property when they have one
+- coordinates are WGS 84 longitudes and latitudes, projected from and to the track's plane around its origin
+- the plane is flat, which is centimetres off over the few kilometres a track spans
+- importing a centreline without an origin places the plane at its first point
+*/
+
+const GeoJSONContentType = "application/geo+json"
+
+// Mean radius of the Earth in metres.
+const earthRadius = 6371008.8
+
+var ErrNotPlaced = errors.New("track geometry has no origin")
+
+const (
+	kindCentreline  = "centreline"
+	kindStartFinish = "start_finish"
+	kindSector      = "sector"
+	kindPitEntry    = "pit_entry"
+	kindPitExit     = "pit_exit"
+)
+
+type geoCollection struct {
+	Type     string        `json:"type"`
+	Features []*geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string        `json:"type"`
+	Geometry   geoLineString `json:"geometry"`
+	Properties geoProperties `json:"properties"`
+}
+
+type geoLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+type geoProperties struct {
+	Kind  string `json:"kind"`
+	Index *int   `json:"index,omitempty"`
+	// on the centreline
+	Width  float64     `json:"width,omitempty"`
+	Length float64     `json:"length,omitempty"`
+	Origin *[2]float64 `json:"origin,omitempty"`
+	// on gates
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// GeoJSON returns g as a GeoJSON FeatureCollection, ErrNotPlaced if g has no origin.
+func (g *Geometry) GeoJSON() ([]byte, error) {
+	if g.Origin == nil {
+		return nil, ErrNotPlaced
+	}
+	o := *g.Origin
+
+	centreline := &geoFeature{
+		Type:     "Feature",
+		Geometry: geoLineString{Type: "LineString", Coordinates: make([][2]float64, len(g.Centreline))},
+		Properties: geoProperties{
+			Kind:   kindCentreline,
+			Width:  g.Width,
+			Length: g.Length,
+			Origin: &[2]float64{o.Lon, o.Lat},
+		},
+	}
+	for i, p := range g.Centreline {
+		centreline.Geometry.Coordinates[i] = o.toGeo(p)
+	}
+
+	fc := geoCollection{Type: "FeatureCollection", Features: []*geoFeature{centreline}}
+	gate := func(kind string, index *int, gate *Gate) {
+		distance := gate.Distance
+		fc.Features = append(fc.Features, &geoFeature{
+			Type:       "Feature",
+			Geometry:   geoLineString{Type: "LineString", Coordinates: [][2]float64{o.toGeo(gate.A), o.toGeo(gate.B)}},
+			Properties: geoProperties{Kind: kind, Index: index, Distance: &distance},
+		})
+	}
+	gate(kindStartFinish, nil, &g.StartFinish)
+	for i := range g.Sectors {
+		gate(kindSector, &i, &g.Sectors[i])
+	}
+	if g.PitEntry != nil {
+		gate(kindPitEntry, nil, g.PitEntry)
+	}
+	if g.PitExit != nil {
+		gate(kindPitExit, nil, g.PitExit)
+	}
+
+	return json.Marshal(fc)
+}
+
+// GeometryFromGeoJSON reads a geometry from a GeoJSON FeatureCollection and validates it.
+func GeometryFromGeoJSON(data []byte) (*Geometry, error) {
+	var fc geoCollection
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, err
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, errors.New("GeoJSON must be a FeatureCollection")
+	}
+
+	var centreline *geoFeature
+	for _, f := range fc.Features {
+		if f == nil || f.Type != "Feature" || f.Geometry.Type != "LineString" {
+			return nil, errors.New("GeoJSON features must all be LineStrings")
+		}
+		if f.Properties.Kind == kindCentreline {
+			if centreline != nil {
+				return nil, errors.New("GeoJSON has more than one centreline")
+			}
+			centreline = f
+		}
+	}
+	if centreline == nil || len(centreline.Geometry.Coordinates) == 0 {
+		return nil, errors.New("GeoJSON has no centreline")
+	}
+
+	first := centreline.Geometry.Coordinates[0]
+	o := GeoPoint{Lon: first[0], Lat: first[1]}
+	if c := centreline.Properties.Origin; c != nil {
+		o = GeoPoint{Lon: c[0], Lat: c[1]}
+	}
+	g := &Geometry{Width: centreline.Properties.Width, Origin: &o}
+	for _, c := range centreline.Geometry.Coordinates {
+		g.Centreline = append(g.Centreline, o.fromGeo(c))
+	}
+
+	type sector struct {
+		index int
+		gate  Gate
+	}
+	var sectors []sector
+	hasStart := false
+	for i, f := range fc.Features {
+		if f == centreline {
+			continue
+		}
+		if len(f.Geometry.Coordinates) != 2 {
+			return nil, fmt.Errorf("GeoJSON %s must be a line between two points", f.Properties.Kind)
+		}
+		gate := Gate{A: o.fromGeo(f.Geometry.Coordinates[0]), B: o.fromGeo(f.Geometry.Coordinates[1])}
+
+		switch f.Properties.Kind {
+		case kindStartFinish:
+			if hasStart {
+				return nil, errors.New("GeoJSON has more than one start_finish")
+			}
+			g.StartFinish, hasStart = gate, true
+		case kindSector:
+			index := i
+			if f.Properties.Index != nil {
+				index = *f.Properties.Index
+			}
+			sectors = append(sectors, sector{index: index, gate: gate})
+		case kindPitEntry:
+			g.PitEntry = &gate
+		case kindPitExit:
+			g.PitExit = &gate
+		default:
+			return nil, fmt.Errorf("GeoJSON feature kind %q is unknown", f.Properties.Kind)
+		}
+	}
+	if !hasStart {
+		return nil, errors.New("GeoJSON has no start_finish")
+	}
+	slices.SortStableFunc(sectors, func(a, b sector) int { return a.index - b.index })
+	for _, s := range sectors {
+		g.Sectors = append(g.Sectors, s.gate)
+	}
+
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// toGeo projects a point of the plane around o to [longitude, latitude]
+func (o GeoPoint) toGeo(p Point) [2]float64 {
+	lat := o.Lat + p.Y/earthRadius*180/math.Pi
+	lon := o.Lon + p.X/(earthRadius*math.Cos(o.Lat*math.Pi/180))*180/math.Pi
+
+	return [2]float64{lon, lat}
+}
+
+// fromGeo is the inverse of toGeo
+func (o GeoPoint) fromGeo(c [2]float64) Point {
+	return Point{
+		X: (c[0] - o.Lon) * math.Pi / 180 * earthRadius * math.Cos(o.Lat*math.Pi/180),
+		Y: (c[1] - o.Lat) * math.Pi / 180 * earthRadius,
+	}
+}
+
+// wantsGeoJSON tells whether a request asks for GeoJSON rather than plain JSON
+func wantsGeoJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "geojson" || strings.Contains(r.Header.Get("Accept"), GeoJSONContentType)
+}
+
+// geometryTags returns the ETags of g in each representation getGeometry answers it in
+func geometryTags(g *Geometry) ([]string, error) {
+	if g == nil {
+		return nil, nil
+	}
+	body, err := json.Marshal(g)
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{etag(body)}
+	if g.Origin != nil {
+		if body, err = g.GeoJSON(); err != nil {
+			return nil, err
+		}
+		tags = append(tags, etag(body))
+	}
+
+	return tags, nil
+}
+
+// writeGeometry writes g with its ETag, as GeoJSON if asked and g is placed
+func writeGeometry(w http.ResponseWriter, r *http.Request, status int, g *Geometry) {
+	if !wantsGeoJSON(r) || g.Origin == nil {
+		writeEntity(w, r, status, g)
+		return
+	}
+
+	body, err := g.GeoJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	if r.Method == http.MethodGet && matchesETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", GeoJSONContentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// getGeometry answers the geometry of a track, as GeoJSON if asked
+func getGeometry(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		track, err := s.repo.GetTrack(r.Context(), id)
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		if track.Geometry == nil {
+			http.Error(w, "track has no geometry", http.StatusNotFound)
+			return
+		}
+		if wantsGeoJSON(r) && track.Geometry.Origin == nil {
+			http.Error(w, ErrNotPlaced.Error(), http.StatusConflict)
+			return
+		}
+		writeGeometry(w, r, http.StatusOK, track.Geometry)
+	}
+}
+
+// putGeometry replaces the geometry of a track, sent as JSON or as GeoJSON.
+// If-Match is checked against the geometry's ETags, not the track's, and the new geometry is answered like
+// getGeometry would so its ETag goes in the next If-Match.
+func putGeometry(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		g, err := decodeGeometry(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		current, err := s.repo.GetTrack(r.Context(), id)
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		tags, err := geometryTags(current.Geometry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ifMatch(w, r, tags...) {
+			return
+		}
+
+		// the name is the one read, a rename made meanwhile fails the update rather than being undone
+		track, err := s.repo.UpdateTrack(r.Context(), id, &TrackParams{Name: current.Name, Geometry: g}, current.Version)
+		if err != nil {
+			writeParamsErr(w, err)
+			return
+		}
+		writeGeometry(w, r, http.StatusOK, track.Geometry)
+	}
+}
+
+// decodeGeometry reads and validates a geometry in the format of contentType
+func decodeGeometry(contentType string, body []byte) (*Geometry, error) {
+	if mt, _, _ := mime.ParseMediaType(contentType); mt == GeoJSONContentType {
+		return GeometryFromGeoJSON(body)
+	}
+
+	g := &Geometry{}
+	if err := json.Unmarshal(body, g); err != nil {
+		return nil, err
+	}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
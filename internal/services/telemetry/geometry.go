@@ -0,0 +1,267 @@
+package telemetry
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+/*
+track geometry
+
+- coordinates are metres in the plane cars report their location in, x east and y north
+- the centreline runs in the direction of racing and is a closed loop, its last point is its first
+- gates are lines across the track: the start/finish line, the sector boundaries and the pit lane entry and exit
+- every gate crosses the centreline, where it does is its distance from the start/finish line
+- sector boundaries follow each other around the lap, the start/finish line ends the last sector
+- Origin places the plane on the globe, GeoJSON needs it
+*/
+
+// Bounds of a centreline. The self-intersection check compares the segments side by side from west to east,
+// a centreline zigzagging across its whole width still makes it compare every pair.
+const (
+	minCentrelinePoints = 4
+	maxCentrelinePoints = 5000
+)
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Gate is a line across the track.
+type Gate struct {
+	A Point `json:"a"`
+	B Point `json:"b"`
+	// Distance is how far around the lap from the start/finish line the gate is, derived from the centreline.
+	Distance float64 `json:"distance"`
+}
+
+// GeoPoint is a WGS 84 position in degrees.
+type GeoPoint struct {
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+}
+
+type Geometry struct {
+	Centreline []Point `json:"centreline"`
+	// Width is the width of the track in metres.
+	Width       float64 `json:"width"`
+	StartFinish Gate    `json:"start_finish"`
+	// Sectors are the boundaries between sectors in lap order, a lap has one more sector than boundaries.
+	Sectors  []Gate `json:"sectors"`
+	PitEntry *Gate  `json:"pit_entry,omitempty"`
+	PitExit  *Gate  `json:"pit_exit,omitempty"`
+	// Origin is where the plane's (0, 0) is on the globe, nil if the track isn't placed.
+	Origin *GeoPoint `json:"origin,omitempty"`
+
+	// Length is the length of a lap along the centreline, derived.
+	Length float64 `json:"length"`
+}
+
+// Validate checks that g describes a track and computes its derived fields.
+func (g *Geometry) Validate() error {
+	n := len(g.Centreline)
+	if n < minCentrelinePoints || n > maxCentrelinePoints {
+		return fmt.Errorf("centreline must have between %d and %d points", minCentrelinePoints, maxCentrelinePoints)
+	}
+	for _, p := range g.Centreline {
+		if !p.finite() {
+			return errors.New("centreline points must be finite")
+		}
+	}
+	if g.Centreline[0] != g.Centreline[n-1] {
+		return errors.New("centreline must be a closed loop, its last point must be its first")
+	}
+	for i := 1; i < n; i++ {
+		if g.Centreline[i] == g.Centreline[i-1] {
+			return fmt.Errorf("centreline points %d and %d are the same", i-1, i)
+		}
+	}
+	if i, j, ok := g.selfIntersection(); ok {
+		return fmt.Errorf("centreline crosses itself, segments %d and %d intersect", i, j)
+	}
+	if !(g.Width > 0) || math.IsInf(g.Width, 0) {
+		return errors.New("width must be positive")
+	}
+	if g.Origin != nil && (math.Abs(g.Origin.Lat) > 85 || math.Abs(g.Origin.Lon) > 180) {
+		return errors.New("origin must be a longitude and a latitude within 85 degrees of the equator")
+	}
+
+	// distances along the centreline from its first point, made relative to the start/finish line after
+	cum := g.cumulative()
+	g.Length = cum[n-1]
+
+	start, err := g.cross(&g.StartFinish, cum)
+	if err != nil {
+		return fmt.Errorf("start_finish %w", err)
+	}
+	g.StartFinish.Distance = 0
+
+	for i := range g.Sectors {
+		d, err := g.cross(&g.Sectors[i], cum)
+		if err != nil {
+			return fmt.Errorf("sector %d %w", i, err)
+		}
+		g.Sectors[i].Distance = g.around(d - start)
+		if g.Sectors[i].Distance == 0 {
+			return fmt.Errorf("sector %d is on the start/finish line", i)
+		}
+		if i > 0 && g.Sectors[i].Distance <= g.Sectors[i-1].Distance {
+			return fmt.Errorf("sector %d must come after sector %d around the lap", i, i-1)
+		}
+	}
+	for _, pit := range []struct {
+		name string
+		gate *Gate
+	}{{"pit_entry", g.PitEntry}, {"pit_exit", g.PitExit}} {
+		if pit.gate == nil {
+			continue
+		}
+		d, err := g.cross(pit.gate, cum)
+		if err != nil {
+			return fmt.Errorf("%s %w", pit.name, err)
+		}
+		pit.gate.Distance = g.around(d - start)
+	}
+
+	return nil
+}
+
+// cumulative returns the distance from the first point of the centreline to each of its points
+func (g *Geometry) cumulative() []float64 {
+	cum := make([]float64, len(g.Centreline))
+	for i := 1; i < len(g.Centreline); i++ {
+		cum[i] = cum[i-1] + g.Centreline[i-1].dist(g.Centreline[i])
+	}
+
+	return cum
+}
+
+// around wraps a distance along the centreline into [0, Length)
+func (g *Geometry) around(d float64) float64 {
+	d = math.Mod(d, g.Length)
+	if d < 0 {
+		d += g.Length
+	}
+
+	return d
+}
+
+// cross returns the distance from the first point of the centreline to where gate crosses it.
+// A gate crossing more than once, over a hairpin, is taken where it's closest to its middle.
+func (g *Geometry) cross(gate *Gate, cum []float64) (float64, error) {
+	if !gate.A.finite() || !gate.B.finite() || gate.A == gate.B {
+		return 0, errors.New("must be a line between two distinct points")
+	}
+
+	mid := Point{(gate.A.X + gate.B.X) / 2, (gate.A.Y + gate.B.Y) / 2}
+	best, at := math.Inf(1), 0.0
+	for i := 0; i+1 < len(g.Centreline); i++ {
+		a, b := g.Centreline[i], g.Centreline[i+1]
+		t, ok := intersect(a, b, gate.A, gate.B)
+		if !ok {
+			continue
+		}
+		p := Point{a.X + t*(b.X-a.X), a.Y + t*(b.Y-a.Y)}
+		if d := p.dist(mid); d < best {
+			best = d
+			at = cum[i] + t*a.dist(b)
+		}
+	}
+	if math.IsInf(best, 1) {
+		return 0, errors.New("does not cross the centreline")
+	}
+
+	return at, nil
+}
+
+// selfIntersection returns two segments of the centreline that intersect, segments next to each other only share their end.
+// Segments are swept by their west end, each is only compared with the ones starting before its east end.
+func (g *Geometry) selfIntersection() (int, int, bool) {
+	segments := len(g.Centreline) - 1
+	west := func(i int) float64 { return math.Min(g.Centreline[i].X, g.Centreline[i+1].X) }
+	east := func(i int) float64 { return math.Max(g.Centreline[i].X, g.Centreline[i+1].X) }
+	order := make([]int, segments)
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i, j int) int { return cmp.Compare(west(i), west(j)) })
+
+	for k, i := range order {
+		for _, j := range order[k+1:] {
+			if west(j) > east(i) {
+				break
+			}
+			i, j := min(i, j), max(i, j)
+			a, b, c, d := g.Centreline[i], g.Centreline[i+1], g.Centreline[j], g.Centreline[j+1]
+			if j == i+1 || (i == 0 && j == segments-1) {
+				// neighbours share a point, they only overlap if one doubles back over the other
+				if collinearOverlap(a, b, c, d) {
+					return i, j, true
+				}
+				continue
+			}
+			if _, ok := intersect(a, b, c, d); ok {
+				return i, j, true
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// intersect tells whether the segments ab and cd meet, and where as a fraction of ab
+func intersect(a, b, c, d Point) (float64, bool) {
+	r := Point{b.X - a.X, b.Y - a.Y}
+	s := Point{d.X - c.X, d.Y - c.Y}
+	ac := Point{c.X - a.X, c.Y - a.Y}
+
+	denom := cross(r, s)
+	if denom == 0 {
+		if cross(ac, r) != 0 {
+			// parallel
+			return 0, false
+		}
+		// collinear, they meet if their projections on ab overlap
+		rr := r.X*r.X + r.Y*r.Y
+		t0 := (ac.X*r.X + ac.Y*r.Y) / rr
+		t1 := t0 + (s.X*r.X+s.Y*r.Y)/rr
+		lo, hi := math.Min(t0, t1), math.Max(t0, t1)
+		if hi < 0 || lo > 1 {
+			return 0, false
+		}
+		return math.Max(lo, 0), true
+	}
+
+	t := cross(ac, s) / denom
+	u := cross(ac, r) / denom
+
+	return t, t >= 0 && t <= 1 && u >= 0 && u <= 1
+}
+
+// collinearOverlap tells whether segments sharing an end double back on each other
+func collinearOverlap(a, b, c, d Point) bool {
+	r := Point{b.X - a.X, b.Y - a.Y}
+	s := Point{d.X - c.X, d.Y - c.Y}
+	if cross(r, s) != 0 {
+		return false
+	}
+
+	// collinear and joined end to end, they overlap if they point opposite ways
+	return r.X*s.X+r.Y*s.Y < 0
+}
+
+func cross(a, b Point) float64 {
+	return a.X*b.Y - a.Y*b.X
+}
+
+func (p Point) dist(q Point) float64 {
+	return math.Hypot(q.X-p.X, q.Y-p.Y)
+}
+
+func (p Point) finite() bool {
+	return !math.IsNaN(p.X) && !math.IsInf(p.X, 0) && !math.IsNaN(p.Y) && !math.IsInf(p.Y, 0)
+}
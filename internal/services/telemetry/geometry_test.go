@@ -0,0 +1,347 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func loop(points ...Point) []Point {
+	return append(points, points[0])
+}
+
+// square is a 100 m square lap, anticlockwise from the origin with its start/finish line 50 m in
+func square() *Geometry {
+	return &Geometry{
+		Centreline:  loop(Point{0, 0}, Point{100, 0}, Point{100, 100}, Point{0, 100}),
+		Width:       10,
+		StartFinish: Gate{A: Point{50, -5}, B: Point{50, 5}},
+	}
+}
+
+// circle is a round lap of n points
+func circle(n int, radius float64) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		points[i] = Point{radius * math.Cos(a), radius * math.Sin(a)}
+	}
+	return loop(points...)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		edit   func(g *Geometry)
+		errors string
+	}{
+		{"closed loop", func(g *Geometry) {}, ""},
+		{"open loop", func(g *Geometry) { g.Centreline = g.Centreline[:4] }, "closed loop"},
+		{"too few points", func(g *Geometry) { g.Centreline = loop(Point{0, 0}, Point{100, 0}) }, "between"},
+		{"too many points", func(g *Geometry) { g.Centreline = circle(maxCentrelinePoints, 1000) }, "between"},
+		{"repeated point", func(g *Geometry) {
+			g.Centreline = loop(Point{0, 0}, Point{100, 0}, Point{100, 0}, Point{100, 100}, Point{0, 100})
+		}, "are the same"},
+		{"not finite", func(g *Geometry) { g.Centreline[2].X = math.NaN() }, "finite"},
+		{"figure of eight", func(g *Geometry) {
+			g.Centreline = loop(Point{0, 0}, Point{100, 0}, Point{0, 100}, Point{100, 100})
+		}, "crosses itself"},
+		{"doubles back", func(g *Geometry) {
+			g.Centreline = loop(Point{0, 0}, Point{100, 0}, Point{60, 0}, Point{60, 100})
+		}, "crosses itself"},
+		{"no width", func(g *Geometry) { g.Width = 0 }, "width"},
+		{"start off the track", func(g *Geometry) { g.StartFinish = Gate{A: Point{50, 10}, B: Point{50, 20}} }, "start_finish does not cross"},
+		{"start is a point", func(g *Geometry) { g.StartFinish = Gate{A: Point{50, 0}, B: Point{50, 0}} }, "start_finish must be a line"},
+		{"sectors in lap order", func(g *Geometry) {
+			g.Sectors = []Gate{{A: Point{95, 50}, B: Point{105, 50}}, {A: Point{50, 95}, B: Point{50, 105}}}
+		}, ""},
+		{"sectors out of order", func(g *Geometry) {
+			g.Sectors = []Gate{{A: Point{50, 95}, B: Point{50, 105}}, {A: Point{95, 50}, B: Point{105, 50}}}
+		}, "sector 1 must come after sector 0"},
+		{"sector on the start/finish line", func(g *Geometry) {
+			g.Sectors = []Gate{{A: Point{50, -5}, B: Point{50, 5}}}
+		}, "sector 0 is on the start/finish line"},
+		{"placed off the globe", func(g *Geometry) { g.Origin = &GeoPoint{Lat: 89} }, "origin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := square()
+			tt.edit(g)
+			err := g.Validate()
+			if tt.errors == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errors) {
+				t.Fatalf("got %v, want an error about %q", err, tt.errors)
+			}
+		})
+	}
+}
+
+func TestValidateDistances(t *testing.T) {
+	g := square()
+	g.Sectors = []Gate{{A: Point{95, 50}, B: Point{105, 50}}, {A: Point{50, 95}, B: Point{50, 105}}}
+	// before the start/finish line, the pit lane runs along the last straight
+	g.PitEntry = &Gate{A: Point{-5, 50}, B: Point{5, 50}}
+	g.PitExit = &Gate{A: Point{25, -5}, B: Point{25, 5}}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Length != 400 {
+		t.Errorf("length %g", g.Length)
+	}
+	for name, got := range map[string]float64{
+		"start_finish": g.StartFinish.Distance,
+		"sector 0":     g.Sectors[0].Distance,
+		"sector 1":     g.Sectors[1].Distance,
+		"pit_entry":    g.PitEntry.Distance,
+		"pit_exit":     g.PitExit.Distance,
+	} {
+		want := map[string]float64{"start_finish": 0, "sector 0": 100, "sector 1": 200, "pit_entry": 300, "pit_exit": 375}[name]
+		if got != want {
+			t.Errorf("%s at %g, want %g", name, got, want)
+		}
+	}
+}
+
+// a gate across a hairpin crosses both sides of it, it's taken on the side its middle is on
+func TestValidateHairpin(t *testing.T) {
+	tests := []struct {
+		name string
+		gate Gate
+		want float64
+	}{
+		{"way out", Gate{A: Point{100, -5}, B: Point{100, 18}}, 80},
+		{"way back", Gate{A: Point{100, 2}, B: Point{100, 25}}, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Geometry{
+				// out along y = 0 and back along y = 20
+				Centreline:  loop(Point{0, 0}, Point{200, 0}, Point{200, 20}, Point{0, 20}),
+				Width:       10,
+				StartFinish: Gate{A: Point{20, -3}, B: Point{20, 3}},
+				Sectors:     []Gate{tt.gate},
+			}
+			if err := g.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if d := g.Sectors[0].Distance; d != tt.want {
+				t.Errorf("sector at %g, want %g", d, tt.want)
+			}
+		})
+	}
+}
+
+// the sweep finds the same crossings as comparing every pair of segments
+func TestSelfIntersection(t *testing.T) {
+	brute := func(g *Geometry) bool {
+		n := len(g.Centreline) - 1
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				a, b, c, d := g.Centreline[i], g.Centreline[i+1], g.Centreline[j], g.Centreline[j+1]
+				if j == i+1 || (i == 0 && j == n-1) {
+					if collinearOverlap(a, b, c, d) {
+						return true
+					}
+					continue
+				}
+				if _, ok := intersect(a, b, c, d); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+	crossing := 0
+	for range 2000 {
+		points := make([]Point, 4+r.IntN(8))
+		for i := range points {
+			// a coarse grid makes collinear and touching segments common
+			points[i] = Point{float64(r.IntN(6)), float64(r.IntN(6))}
+		}
+		g := &Geometry{Centreline: loop(points...)}
+
+		i, j, got := g.selfIntersection()
+		if want := brute(g); got != want {
+			t.Fatalf("%v: crosses itself %v, want %v", g.Centreline, got, want)
+		}
+		if got {
+			crossing++
+			if i >= j {
+				t.Fatalf("%v: segments %d and %d", g.Centreline, i, j)
+			}
+		}
+	}
+	if crossing == 0 || crossing == 2000 {
+		t.Fatalf("%d of the laps cross themselves, the test doesn't tell anything", crossing)
+	}
+}
+
+func TestGeoJSONRoundTrip(t *testing.T) {
+	g := square()
+	g.Sectors = []Gate{{A: Point{95, 50}, B: Point{105, 50}}, {A: Point{50, 95}, B: Point{50, 105}}}
+	g.PitEntry = &Gate{A: Point{-5, 50}, B: Point{5, 50}}
+	g.Origin = &GeoPoint{Lon: 7.42, Lat: 43.73}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := g.GeoJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := GeometryFromGeoJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const eps = 1e-6
+	near := func(a, b Point) bool { return a.dist(b) < eps }
+	if len(got.Centreline) != len(g.Centreline) {
+		t.Fatalf("%d points, want %d", len(got.Centreline), len(g.Centreline))
+	}
+	for i := range g.Centreline {
+		if !near(got.Centreline[i], g.Centreline[i]) {
+			t.Errorf("point %d is %v, want %v", i, got.Centreline[i], g.Centreline[i])
+		}
+	}
+	if got.Width != g.Width || *got.Origin != *g.Origin || math.Abs(got.Length-g.Length) > eps {
+		t.Errorf("got width %g, origin %v, length %g", got.Width, *got.Origin, got.Length)
+	}
+	gates := []struct {
+		name      string
+		got, want *Gate
+	}{
+		{"start_finish", &got.StartFinish, &g.StartFinish},
+		{"sector 0", &got.Sectors[0], &g.Sectors[0]},
+		{"sector 1", &got.Sectors[1], &g.Sectors[1]},
+		{"pit_entry", got.PitEntry, g.PitEntry},
+	}
+	for _, gate := range gates {
+		if gate.got == nil || !near(gate.got.A, gate.want.A) || !near(gate.got.B, gate.want.B) ||
+			math.Abs(gate.got.Distance-gate.want.Distance) > eps {
+			t.Errorf("%s is %v, want %v", gate.name, gate.got, gate.want)
+		}
+	}
+	if got.PitExit != nil {
+		t.Errorf("pit_exit %v appeared", got.PitExit)
+	}
+
+	g.Origin = nil
+	if _, err := g.GeoJSON(); err != ErrNotPlaced {
+		t.Errorf("unplaced geometry exported: %v", err)
+	}
+}
+
+func BenchmarkValidate(b *testing.B) {
+	g := &Geometry{
+		Centreline:  circle(maxCentrelinePoints-1, 1000),
+		Width:       12,
+		StartFinish: Gate{A: Point{990, 1}, B: Point{1010, 1}},
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := g.Validate(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// a PUT of the geometry matches the ETag a GET of it answered, in either representation, and answers the next one
+func TestGeometryIfMatch(t *testing.T) {
+	g := square()
+	g.Origin = &GeoPoint{Lon: 7.42, Lat: 43.73}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geoBody, err := g.GeoJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		get     string
+		ifMatch func(got, track string) string
+		send    []byte
+		as      string
+		want    int
+	}{
+		{"json", "", func(got, _ string) string { return got }, body, "application/json", http.StatusOK},
+		{"geojson", "?format=geojson", func(got, _ string) string { return got }, geoBody, GeoJSONContentType, http.StatusOK},
+		{"geojson sent as json", "?format=geojson", func(got, _ string) string { return got }, body, "application/json", http.StatusOK},
+		{"any", "", func(string, string) string { return "*" }, body, "application/json", http.StatusOK},
+		{"track's", "", func(_, track string) string { return track }, body, "application/json", http.StatusPreconditionFailed},
+		{"stale", "", func(string, string) string { return `"stale"` }, body, "application/json", http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := uuid.New()
+			repo := &tracksRepo{track: &Track{ID: id, Name: "ring", Geometry: g, Version: 3}}
+			_, srv := serve(t, repo, nil)
+			path := "/tracks/" + id.String()
+
+			res := request(t, srv.URL, "GET", path+"/geometry"+tt.get, "", nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got %d getting the geometry", res.StatusCode)
+			}
+			tag := res.Header.Get("ETag")
+			track := request(t, srv.URL, "GET", path, "", nil).Header.Get("ETag")
+
+			put := func(tag string) *http.Response {
+				req, err := http.NewRequest("PUT", srv.URL+path+"/geometry"+tt.get, bytes.NewReader(tt.send))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", tt.as)
+				req.Header.Set("If-Match", tag)
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+				return res
+			}
+
+			res = put(tt.ifMatch(tag, track))
+			if res.StatusCode != tt.want {
+				t.Fatalf("got %d, want %d", res.StatusCode, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				if repo.track.Version != 3 {
+					t.Error("track updated")
+				}
+				return
+			}
+			// the answer's ETag is the one a GET of the geometry just written answers
+			got := request(t, srv.URL, "GET", path+"/geometry"+tt.get, "", nil).Header.Get("ETag")
+			if next := res.Header.Get("ETag"); next != got {
+				t.Errorf("answered ETag %s, a GET answers %s", next, got)
+			}
+			if res := put(res.Header.Get("ETag")); res.StatusCode != http.StatusOK {
+				t.Errorf("got %d putting with the answered ETag", res.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+/*
+event lifecycle
+
+- events are created as drafts, race control schedules them and opens registration
+- the scheduler takes scheduled events live at StartsAt and finishes live ones at EndsAt
+- it also opens and closes registration with the registration window of the events that have one
+- an event can be cancelled until it's finished, finished and cancelled events never change again
+- every transition is published on the event's state topic as an event_state message
+- the race runtime follows the state, it runs while the event is live
+- the scheduler backs off while the events can't be listed, e.g. when the database is down
+*/
+
+// How often the scheduler looks for events due to start or end.
+const scheduleInterval = time.Second
+
+// Longest the scheduler waits between attempts while events can't be listed, it doubles from scheduleInterval.
+const maxScheduleBackoff = time.Minute
+
+type EventState string
+
+const (
+	EventDraft            EventState = "draft"
+	EventScheduled        EventState = "scheduled"
+	EventRegistrationOpen EventState = "registration_open"
+	EventLive             EventState = "live"
+	EventFinished         EventState = "finished"
+	EventCancelled        EventState = "cancelled"
+)
+
+// transitions lists the states each state can move to
+var transitions = map[EventState][]EventState{
+	EventDraft:            {EventScheduled, EventCancelled},
+	EventScheduled:        {EventDraft, EventRegistrationOpen, EventLive, EventCancelled},
+	EventRegistrationOpen: {EventScheduled, EventLive, EventCancelled},
+	EventLive:             {EventFinished, EventCancelled},
+	EventFinished:         {},
+	EventCancelled:        {},
+}
+
+var ErrInvalidTransition = errors.New("invalid event state transition")
+
+func (s EventState) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanBecome tells whether an event in state s may move to state to.
+func (s EventState) CanBecome(to EventState) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Editable tells whether an event in state s may still be edited, which it can't once it went live.
+func (s EventState) Editable() bool {
+	return s == EventDraft || s == EventScheduled || s == EventRegistrationOpen
+}
+
+// StateChange is the data of an event_state message.
+type StateChange struct {
+	Event uuid.UUID  `json:"event"`
+	From  EventState `json:"from"`
+	To    EventState `json:"to"`
+	At    time.Time  `json:"at"`
+}
+
+// TransitionEvent moves an event to another state.
+func (s *TelemetryService) TransitionEvent(ctx context.Context, id uuid.UUID, to EventState) (*Event, error) {
+	ev, err := s.repo.GetEvent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.transition(ctx, ev, to)
+}
+
+func (s *TelemetryService) transition(ctx context.Context, ev *Event, to EventState) (*Event, error) {
+	if !ev.State.CanBecome(to) {
+		return nil, fmt.Errorf("%w from %s to %s", ErrInvalidTransition, ev.State, to)
+	}
+
+	next, err := s.repo.SetEventState(ctx, ev.ID, ev.State, to)
+	if err != nil {
+		return nil, err
+	}
+	s.follow(next)
+	s.notify(StateChange{Event: next.ID, From: ev.State, To: next.State, At: time.Now()})
+
+	return next, nil
+}
+
+// follow starts or stops the race runtime of ev to match its state
+func (s *TelemetryService) follow(ev *Event) {
+	switch ev.State {
+	case EventLive:
+		if err := s.StartEvent(ev); err != nil && !errors.Is(err, ErrEventRunning) {
+			s.log.Error(fmt.Sprintf("event %s: could not start the race: %v", ev.ID, err))
+		}
+	case EventFinished, EventCancelled:
+		s.StopEvent(ev.ID)
+	}
+}
+
+func (s *TelemetryService) notify(c StateChange) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("event %s: %v", c.Event, err))
+		return
+	}
+	_ = s.hub.Publish(stateTopic(c.Event), &msg.Message{Type: msg.EventState, Data: data})
+}
+
+// schedule moves events at their start and end times until ctx is done
+func (s *TelemetryService) schedule(ctx context.Context) {
+	wait := scheduleInterval
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	for {
+		if err := s.reconcile(ctx, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait = min(2*wait, maxScheduleBackoff)
+			s.log.Error(fmt.Sprintf("scheduler: %v, retrying in %s", err, wait))
+		} else {
+			wait = scheduleInterval
+		}
+
+		t.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// reconcile applies the transitions due at now, it gives up on the first list of events that fails.
+// Live events are resumed too, their runtime is lost when the server restarts.
+func (s *TelemetryService) reconcile(ctx context.Context, now time.Time) error {
+	if err := s.eachEvent(ctx, &EventFilter{State: EventScheduled, RegistrationOpenAt: now}, func(ev *Event) {
+		if _, err := s.transition(ctx, ev, EventRegistrationOpen); err != nil && !errors.Is(err, store.ErrConflict) {
+			s.log.Error(fmt.Sprintf("event %s: could not open registration: %v", ev.ID, err))
+		}
+	}); err != nil {
+		return err
+	}
+	if err := s.eachEvent(ctx, &EventFilter{State: EventRegistrationOpen, RegistrationClosedAt: now}, func(ev *Event) {
+		if _, err := s.transition(ctx, ev, EventScheduled); err != nil && !errors.Is(err, store.ErrConflict) {
+			s.log.Error(fmt.Sprintf("event %s: could not close registration: %v", ev.ID, err))
+		}
+	}); err != nil {
+		return err
+	}
+
+	for _, state := range []EventState{EventScheduled, EventRegistrationOpen} {
+		if err := s.eachEvent(ctx, &EventFilter{State: state, StartsBefore: now}, func(ev *Event) {
+			if _, err := s.transition(ctx, ev, EventLive); err != nil && !errors.Is(err, store.ErrConflict) {
+				s.log.Error(fmt.Sprintf("event %s: could not go live: %v", ev.ID, err))
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	return s.eachEvent(ctx, &EventFilter{State: EventLive}, func(ev *Event) {
+		if ev.EndsAt.After(now) {
+			s.follow(ev)
+			return
+		}
+		// a conflict means race control or another replica got there first
+		if _, err := s.transition(ctx, ev, EventFinished); err != nil && !errors.Is(err, store.ErrConflict) {
+			s.log.Error(fmt.Sprintf("event %s: could not finish: %v", ev.ID, err))
+		}
+	})
+}
+
+// eachEvent calls fn with every event f selects, page by page
+func (s *TelemetryService) eachEvent(ctx context.Context, f *EventFilter, fn func(*Event)) error {
+	f.Limit = maxPageSize
+	for {
+		events, err := s.repo.ListEvents(ctx, f)
+		if err != nil {
+			return fmt.Errorf("could not list %s events: %w", f.State, err)
+		}
+		for _, ev := range events {
+			fn(ev)
+		}
+		if len(events) < f.Limit {
+			return nil
+		}
+		f.After = events[len(events)-1].ID
+	}
+}
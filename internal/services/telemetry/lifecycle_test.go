@@ -0,0 +1,122 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/lib"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+// downRepo is a repository whose database can't be reached
+type downRepo struct {
+	TelemetryRepo
+	listed chan struct{}
+}
+
+func (r *downRepo) ListEvents(context.Context, *EventFilter) ([]*Event, error) {
+	r.listed <- struct{}{}
+	return nil, errors.New("connection refused")
+}
+
+func TestScheduleBacksOff(t *testing.T) {
+	repo := &downRepo{listed: make(chan struct{}, 8)}
+	s := &TelemetryService{repo: repo, log: lib.NewLogger("telemetry")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.schedule(ctx)
+		close(done)
+	}()
+
+	<-repo.listed
+	// the next attempt waits twice the interval, and a failed one stops at its first list
+	select {
+	case <-repo.listed:
+		t.Fatal("listed again right away")
+	case <-time.After(scheduleInterval + scheduleInterval/2):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler still running once its context is done")
+	}
+}
+
+func TestEditable(t *testing.T) {
+	tests := []struct {
+		state EventState
+		want  bool
+	}{
+		{EventDraft, true},
+		{EventScheduled, true},
+		{EventRegistrationOpen, true},
+		{EventLive, false},
+		{EventFinished, false},
+		{EventCancelled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			if got := tt.state.Editable(); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// draftRepo has one event, in draft
+type draftRepo struct {
+	TelemetryRepo
+	event *Event
+}
+
+func (r *draftRepo) GetEvent(_ context.Context, id uuid.UUID) (*Event, error) {
+	if id != r.event.ID {
+		return nil, store.ErrNotFound
+	}
+	return r.event, nil
+}
+
+func (r *draftRepo) SetEventState(_ context.Context, id uuid.UUID, from, to EventState) (*Event, error) {
+	if id != r.event.ID || from != r.event.State {
+		return nil, store.ErrConflict
+	}
+	return &Event{ID: id, State: to}, nil
+}
+
+func TestSetEventState(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  Authenticator
+		token string
+		state EventState
+		want  int
+	}{
+		{"no authenticator", nil, "rc", EventScheduled, http.StatusNotFound},
+		{"anonymous", testTokens(t), "", EventScheduled, http.StatusUnauthorized},
+		{"driver", testTokens(t), "driver", EventScheduled, http.StatusForbidden},
+		{"race control", testTokens(t), "rc", EventScheduled, http.StatusOK},
+		{"invalid transition", testTokens(t), "rc", EventLive, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &draftRepo{event: &Event{ID: uuid.New(), State: EventDraft}}
+			_, srv := serve(t, repo, tt.auth)
+
+			body := strings.NewReader(`{"state": "` + string(tt.state) + `"}`)
+			if res := request(t, srv.URL, "PUT", "/admin/events/"+repo.event.ID.String()+"/state", tt.token, body); res.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
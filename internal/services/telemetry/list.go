@@ -0,0 +1,235 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+lists
+
+- GET /{name} lists an entity in pages of ?limit= items, 50 by default and 200 at most
+- a page ends with "next", the ID to pass as ?after= for the following page, it's missing on the last one
+- items are ordered by ID, pages stay stable while items are created since new IDs sort last
+*/
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// keyed entities can be paged through by ID
+type keyed interface {
+	key() uuid.UUID
+}
+
+func (t *Track) key() uuid.UUID  { return t.ID }
+func (c *Car) key() uuid.UUID    { return c.ID }
+func (c *Class) key() uuid.UUID  { return c.ID }
+func (e *Event) key() uuid.UUID  { return e.ID }
+func (e *Entry) key() uuid.UUID  { return e.ID }
+func (d *Driver) key() uuid.UUID { return d.ID }
+func (t *Team) key() uuid.UUID   { return t.ID }
+
+type listResponse[T any] struct {
+	Items []T        `json:"items"`
+	Next  *uuid.UUID `json:"next,omitempty"`
+}
+
+// listEntities serves a page of list, filter reads the filter from the query string
+func listEntities[T keyed, F any](filter func(*query, Page) F, list func(context.Context, F) ([]T, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := &query{Values: r.URL.Query(), path: r.PathValue}
+		p := q.page()
+		limit := p.Limit
+		// one more than asked for tells whether there's a next page
+		p.Limit++
+		f := filter(q, p)
+		if q.err != nil {
+			http.Error(w, q.err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items, err := list(r.Context(), f)
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+
+		res := listResponse[T]{Items: items}
+		if len(items) > limit {
+			res.Items = items[:limit]
+			next := items[limit-1].key()
+			res.Next = &next
+		}
+		if res.Items == nil {
+			res.Items = []T{}
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// query reads typed parameters, the first invalid one is kept in err and the rest are skipped
+type query struct {
+	url.Values
+	path func(string) string
+	err  error
+}
+
+func (q *query) fail(name, v string, err error) {
+	q.err = fmt.Errorf("invalid %s %q: %w", name, v, err)
+}
+
+func (q *query) uuid(name string) uuid.UUID {
+	v := q.Get(name)
+	if v == "" || q.err != nil {
+		return uuid.Nil
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		q.fail(name, v, err)
+	}
+
+	return id
+}
+
+// pathID reads an ID from the path rather than the query string
+func (q *query) pathID(name string) uuid.UUID {
+	v := q.path(name)
+	if q.err != nil {
+		return uuid.Nil
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		q.fail(name, v, err)
+	}
+
+	return id
+}
+
+// time reads an RFC 3339 timestamp
+func (q *query) time(name string) time.Time {
+	v := q.Get(name)
+	if v == "" || q.err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		q.fail(name, v, err)
+	}
+
+	return t
+}
+
+func (q *query) bool(name string) *bool {
+	v := q.Get(name)
+	if v == "" || q.err != nil {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		q.fail(name, v, err)
+		return nil
+	}
+
+	return &b
+}
+
+func (q *query) float(name string) *float64 {
+	v := q.Get(name)
+	if v == "" || q.err != nil {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		err = errors.New("must be finite")
+	}
+	if err != nil {
+		q.fail(name, v, err)
+		return nil
+	}
+
+	return &f
+}
+
+func (q *query) state(name string) EventState {
+	v := EventState(q.Get(name))
+	if v != "" && q.err == nil && !v.Valid() {
+		q.fail(name, string(v), errors.New("unknown event state"))
+	}
+
+	return v
+}
+
+func (q *query) page() Page {
+	p := Page{After: q.uuid("after"), Limit: defaultPageSize}
+	if v := q.Get("limit"); v != "" && q.err == nil {
+		n, err := strconv.Atoi(v)
+		if err == nil && (n < 1 || n > maxPageSize) {
+			err = fmt.Errorf("must be between 1 and %d", maxPageSize)
+		}
+		if err != nil {
+			q.fail("limit", v, err)
+		}
+		p.Limit = n
+	}
+
+	return p
+}
+
+func trackFilter(q *query, p Page) *TrackFilter {
+	return &TrackFilter{Page: p, Name: q.Get("name")}
+}
+
+func carFilter(q *query, p Page) *CarFilter {
+	return &CarFilter{Page: p, ClassID: q.uuid("class")}
+}
+
+func classFilter(_ *query, p Page) *ClassFilter {
+	return &ClassFilter{Page: p}
+}
+
+func eventFilter(q *query, p Page) *EventFilter {
+	return &EventFilter{
+		Page:         p,
+		State:        q.state("state"),
+		StartsAfter:  q.time("starts_after"),
+		StartsBefore: q.time("starts_before"),
+		EndsAfter:    q.time("ends_after"),
+		EndsBefore:   q.time("ends_before"),
+		TrackID:      q.uuid("track"),
+		ClassID:      q.uuid("class"),
+		IsHighReward: q.bool("high_reward"),
+	}
+}
+
+func driverFilter(q *query, p Page) *DriverFilter {
+	return &DriverFilter{Page: p, Name: q.Get("name"), Country: q.Get("country")}
+}
+
+func teamFilter(q *query, p Page) *TeamFilter {
+	return &TeamFilter{Page: p, Name: q.Get("name"), Identity: q.Get("identity"), DriverID: q.uuid("driver")}
+}
+
+func entryFilter(q *query, p Page) *EntryFilter {
+	f := &EntryFilter{
+		Page:     p,
+		EventID:  q.pathID("id"),
+		ClassID:  q.uuid("class"),
+		DriverID: q.uuid("driver"),
+		TeamID:   q.uuid("team"),
+	}
+	if withdrawn := q.bool("withdrawn"); withdrawn != nil {
+		f.IncludeWithdrawn = *withdrawn
+	}
+
+	return f
+}
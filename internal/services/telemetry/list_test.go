@@ -0,0 +1,181 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// listRepo pages through tracks in ID order as the store does and keeps the last event filter it was asked for
+type listRepo struct {
+	TelemetryRepo
+	tracks []*Track
+	events *EventFilter
+}
+
+func (r *listRepo) ListTracks(_ context.Context, f *TrackFilter) ([]*Track, error) {
+	var page []*Track
+	for _, t := range r.tracks {
+		if len(page) == f.Limit {
+			break
+		}
+		if t.ID.String() > f.After.String() {
+			page = append(page, t)
+		}
+	}
+	return page, nil
+}
+
+func (r *listRepo) ListEvents(_ context.Context, f *EventFilter) ([]*Event, error) {
+	r.events = f
+	return nil, nil
+}
+
+// following next from the first page lists every item once, the last page has no next
+func TestListPages(t *testing.T) {
+	repo := &listRepo{}
+	for i := range 5 {
+		id, err := uuid.NewV7()
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.tracks = append(repo.tracks, &Track{ID: id, Name: fmt.Sprint(i)})
+	}
+	_, srv := serve(t, repo, nil)
+
+	tests := []struct {
+		limit int
+		pages []int
+	}{
+		{2, []int{2, 2, 1}},
+		{5, []int{5}},
+		{defaultPageSize, []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.limit), func(t *testing.T) {
+			var (
+				pages []int
+				seen  int
+				after string
+			)
+			for {
+				res := request(t, srv.URL, http.MethodGet, fmt.Sprintf("/tracks?limit=%d%s", tt.limit, after), "", nil)
+				var page listResponse[*Track]
+				err := json.NewDecoder(res.Body).Decode(&page)
+				res.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, track := range page.Items {
+					if track.ID != repo.tracks[seen].ID {
+						t.Fatalf("got track %s, want %s", track.Name, repo.tracks[seen].Name)
+					}
+					seen++
+				}
+				pages = append(pages, len(page.Items))
+				if page.Next == nil {
+					break
+				}
+				if *page.Next != repo.tracks[seen-1].ID {
+					t.Fatalf("next is %s, want the last item's ID", page.Next)
+				}
+				after = "&after=" + page.Next.String()
+			}
+
+			if fmt.Sprint(pages) != fmt.Sprint(tt.pages) {
+				t.Errorf("got pages of %v, want %v", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	repo := &listRepo{}
+	_, srv := serve(t, repo, nil)
+	track, after := uuid.New(), uuid.New()
+	starts := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	yes := true
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		// the filter the repo is asked for, a page one longer than the limit
+		want *EventFilter
+	}{
+		{"defaults", "", http.StatusOK, &EventFilter{Page: Page{Limit: defaultPageSize + 1}}},
+		{
+			"filtered",
+			fmt.Sprintf("?after=%s&limit=10&state=%s&starts_after=%s&track=%s&high_reward=true",
+				after, EventScheduled, starts.Format(time.RFC3339), track),
+			http.StatusOK,
+			&EventFilter{
+				Page:         Page{After: after, Limit: 11},
+				State:        EventScheduled,
+				StartsAfter:  starts,
+				TrackID:      track,
+				IsHighReward: &yes,
+			},
+		},
+		{"limit too small", "?limit=0", http.StatusBadRequest, nil},
+		{"limit too big", fmt.Sprintf("?limit=%d", maxPageSize+1), http.StatusBadRequest, nil},
+		{"limit not a number", "?limit=ten", http.StatusBadRequest, nil},
+		{"cursor not an ID", "?after=42", http.StatusBadRequest, nil},
+		{"unknown state", "?state=paused", http.StatusBadRequest, nil},
+		{"time not RFC 3339", "?starts_after=yesterday", http.StatusBadRequest, nil},
+		{"not a bool", "?high_reward=maybe", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo.events = nil
+			res := request(t, srv.URL, http.MethodGet, "/events"+tt.query, "", nil)
+			var page struct {
+				Items json.RawMessage `json:"items"`
+			}
+			if res.StatusCode == http.StatusOK {
+				if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("got %d, want %d", res.StatusCode, tt.status)
+			}
+			if tt.want == nil {
+				if repo.events != nil {
+					t.Error("listed with an invalid filter")
+				}
+				return
+			}
+
+			// an empty page is still a list
+			if string(page.Items) != "[]" {
+				t.Errorf("got items %s", page.Items)
+			}
+			got, want := *repo.events, *tt.want
+			if fmt.Sprint(deref(got.IsHighReward)) != fmt.Sprint(deref(want.IsHighReward)) {
+				t.Errorf("got high reward %v, want %v", deref(got.IsHighReward), deref(want.IsHighReward))
+			}
+			got.IsHighReward, want.IsHighReward = nil, nil
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// deref is what b points to, nil for none
+func deref(b *bool) any {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
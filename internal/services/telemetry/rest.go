@@ -0,0 +1,423 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+// Bodies bigger than this are rejected, a track geometry is the largest thing sent.
+const maxBodySize = 1 << 20
+
+const maxNameLength = 200
+
+// params are request bodies that can tell whether they make sense
+type params interface {
+	validate() error
+}
+
+// versioned entities tell the version a write made on a condition checked against them requires
+type versioned interface {
+	version() Version
+}
+
+func (t *Track) version() Version  { return t.Version }
+func (c *Car) version() Version    { return c.Version }
+func (c *Class) version() Version  { return c.Version }
+func (e *Event) version() Version  { return e.Version }
+func (d *Driver) version() Version { return d.Version }
+func (t *Team) version() Version   { return t.Version }
+
+// resource is what the CRUD endpoints of an entity need from the repo
+type resource[T versioned, P params] struct {
+	get    func(context.Context, uuid.UUID) (T, error)
+	create func(context.Context, P) (T, error)
+	update func(context.Context, uuid.UUID, P, Version) (T, error)
+	delete func(context.Context, uuid.UUID, Version) error
+}
+
+// handleResource registers create, read, update and delete of an entity under /{name}
+func handleResource[T versioned, P params](mux *http.ServeMux, name string, res resource[T, P]) {
+	mux.HandleFunc("POST /"+name, createEntity(res))
+	mux.HandleFunc("GET /"+name+"/{id}", getEntity(res))
+	mux.HandleFunc("PUT /"+name+"/{id}", updateEntity(res))
+	mux.HandleFunc("DELETE /"+name+"/{id}", deleteEntity(res))
+}
+
+func getEntity[T versioned, P params](res resource[T, P]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		v, err := res.get(r.Context(), id)
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusOK, v)
+	}
+}
+
+func createEntity[T versioned, P params](res resource[T, P]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := decodeParams[P](w, r)
+		if !ok {
+			return
+		}
+
+		v, err := res.create(r.Context(), p)
+		if err != nil {
+			writeParamsErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusCreated, v)
+	}
+}
+
+func updateEntity[T versioned, P params](res resource[T, P]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		p, ok := decodeParams[P](w, r)
+		if !ok {
+			return
+		}
+
+		// looked up first so a missing entity isn't mistaken for a missing reference in p
+		current, err := res.get(r.Context(), id)
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		version, ok := checkIfMatch(w, r, current)
+		if !ok {
+			return
+		}
+
+		v, err := res.update(r.Context(), id, p, version)
+		if err != nil {
+			writeParamsErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusOK, v)
+	}
+}
+
+func deleteEntity[T versioned, P params](res resource[T, P]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		var version Version
+		if r.Header.Get("If-Match") != "" {
+			current, err := res.get(r.Context(), id)
+			if err != nil {
+				writeStoreErr(w, err)
+				return
+			}
+			if version, ok = checkIfMatch(w, r, current); !ok {
+				return
+			}
+		}
+
+		if err := res.delete(r.Context(), id, version); err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return id, false
+	}
+
+	return id, true
+}
+
+// decodeParams reads the body into a new P, which is always a pointer to a struct
+func decodeParams[P params](w http.ResponseWriter, r *http.Request) (P, bool) {
+	// allocated up front, a "null" body would leave a nil pointer otherwise
+	p := reflect.New(reflect.TypeFor[P]().Elem()).Interface().(P)
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return p, false
+	}
+	if err := p.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return p, false
+	}
+
+	return p, true
+}
+
+/*
+ETags
+
+- the ETag of an entity is a hash of its JSON representation, so it changes with anything a client can see
+- GET answers 304 when If-None-Match has the current one
+- PUT and DELETE answer 412 when If-Match doesn't, or when the entity is updated between the check and the write:
+  the write requires the version of the entity the check was made against
+- a track's geometry has the ETags of its own JSON and GeoJSON representations, either one matches on PUT
+*/
+
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeEntity writes v with its ETag
+func writeEntity(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	if r.Method == http.MethodGet && matchesETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// checkIfMatch answers 412 if the request's If-Match doesn't match current, true if the request can go on.
+// The write must then require the returned version, which is 0 without If-Match.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current versioned) (Version, bool) {
+	if r.Header.Get("If-Match") == "" {
+		return 0, true
+	}
+
+	body, err := json.Marshal(current)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if !ifMatch(w, r, etag(body)) {
+		return 0, false
+	}
+
+	return current.version(), true
+}
+
+// ifMatch answers 412 unless the request's If-Match is absent or lists one of tags, true if the request can go on.
+// Without tags there is nothing to match, not even *.
+func ifMatch(w http.ResponseWriter, r *http.Request, tags ...string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || slices.ContainsFunc(tags, func(tag string) bool { return matchesETag(header, tag) }) {
+		return true
+	}
+	http.Error(w, "entity was modified", http.StatusPreconditionFailed)
+
+	return false
+}
+
+// matchesETag tells if a If-Match or If-None-Match header lists tag, weak tags compare like strong ones
+func matchesETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeStoreErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, store.ErrPreconditionFailed):
+		http.Error(w, "entity was modified", http.StatusPreconditionFailed)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeParamsErr is writeStoreErr for writes, where ErrNotFound means the body refers to something missing
+func writeParamsErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeStoreErr(w, err)
+}
+
+func validateName(field, v string) error {
+	if strings.TrimSpace(v) == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if utf8.RuneCountInString(v) > maxNameLength {
+		return fmt.Errorf("%s must be at most %d characters", field, maxNameLength)
+	}
+
+	return nil
+}
+
+func (p *TrackParams) validate() error {
+	if err := validateName("name", p.Name); err != nil {
+		return err
+	}
+	if p.Geometry != nil {
+		if err := p.Geometry.Validate(); err != nil {
+			return fmt.Errorf("geometry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *CarParams) validate() error {
+	if err := validateName("name", p.Name); err != nil {
+		return err
+	}
+	if p.ClassID == uuid.Nil {
+		return errors.New("class_id is required")
+	}
+
+	return nil
+}
+
+func (p *ClassParams) validate() error {
+	return validateName("title", p.Title)
+}
+
+// highest racing number, they have at most three digits
+const maxRacingNumber = 999
+
+func (p *DriverParams) validate() error {
+	if err := validateName("display_name", p.DisplayName); err != nil {
+		return err
+	}
+	if len(p.Country) != 2 || !isUpper(p.Country[0]) || !isUpper(p.Country[1]) {
+		return errors.New("country must be an ISO 3166-1 alpha-2 code such as IT")
+	}
+	if p.Number > maxRacingNumber {
+		return fmt.Errorf("number must be at most %d", maxRacingNumber)
+	}
+	if utf8.RuneCountInString(p.Identity) > maxNameLength {
+		return fmt.Errorf("identity must be at most %d characters", maxNameLength)
+	}
+
+	return nil
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+// most drivers sharing the car of an entry, endurance crews have three or four
+const maxEntryDrivers = 8
+
+func (p *EntryParams) validate() error {
+	switch uuid.Nil {
+	case p.ClassID:
+		return errors.New("class_id is required")
+	case p.CarID:
+		return errors.New("car_id is required")
+	}
+	if len(p.Drivers) == 0 || len(p.Drivers) > maxEntryDrivers {
+		return fmt.Errorf("drivers must list 1 to %d drivers", maxEntryDrivers)
+	}
+	for i, id := range p.Drivers {
+		if id == uuid.Nil || slices.Contains(p.Drivers[:i], id) {
+			return errors.New("drivers must be distinct driver IDs")
+		}
+	}
+	if p.TeamID != nil && *p.TeamID == uuid.Nil {
+		return errors.New("team_id must be a team ID or null")
+	}
+
+	return nil
+}
+
+func (p *TeamParams) validate() error {
+	if err := validateName("name", p.Name); err != nil {
+		return err
+	}
+	for _, m := range p.Members {
+		if m == nil {
+			return errors.New("members must not be null")
+		}
+		switch m.Role {
+		case TeamDriver:
+			if m.DriverID == nil || *m.DriverID == uuid.Nil || m.Identity != "" {
+				return errors.New("drivers are members by driver_id only")
+			}
+		case TeamEngineer, TeamManager:
+			if m.DriverID != nil {
+				return fmt.Errorf("%ss are members by identity only", m.Role)
+			}
+			if err := validateName("identity", m.Identity); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("role must be one of %s, %s or %s", TeamDriver, TeamEngineer, TeamManager)
+		}
+	}
+
+	return nil
+}
+
+func (p *EventParams) validate() error {
+	if err := validateName("title", p.Title); err != nil {
+		return err
+	}
+	if p.TrackID == uuid.Nil {
+		return errors.New("track_id is required")
+	}
+	if p.StartsAt.IsZero() || p.EndsAt.IsZero() {
+		return errors.New("starts_at and ends_at are required")
+	}
+	if p.EndsAt.Before(p.StartsAt) {
+		return errors.New("ends_at must not be before starts_at")
+	}
+	for id, limit := range p.EntryLimits {
+		if !slices.Contains(p.Classes, id) {
+			return fmt.Errorf("entry_limits names %s, which is not one of classes", id)
+		}
+		if limit == 0 {
+			return errors.New("entry_limits must be positive")
+		}
+	}
+	if opens, closes := p.RegistrationOpensAt, p.RegistrationClosesAt; closes != nil {
+		if opens != nil && closes.Before(*opens) {
+			return errors.New("registration_closes_at must not be before registration_opens_at")
+		}
+		if closes.After(p.StartsAt) {
+			return errors.New("registration_closes_at must not be after starts_at")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+// carsRepo holds a single car, which another writer updates right after it's read if racing is set
+type carsRepo struct {
+	TelemetryRepo
+	car     Car
+	racing  bool
+	written Version
+}
+
+func (r *carsRepo) GetCar(context.Context, uuid.UUID) (*Car, error) {
+	c := r.car
+	if r.racing {
+		r.car.Version++
+	}
+	return &c, nil
+}
+
+func (r *carsRepo) UpdateCar(_ context.Context, id uuid.UUID, p *CarParams, version Version) (*Car, error) {
+	if version != 0 && version != r.car.Version {
+		return nil, store.ErrPreconditionFailed
+	}
+	r.written = version
+	r.car = Car{ID: id, Name: p.Name, Class: r.car.Class, Version: r.car.Version + 1}
+	return &r.car, nil
+}
+
+func (r *carsRepo) DeleteCar(_ context.Context, _ uuid.UUID, version Version) error {
+	if version != 0 && version != r.car.Version {
+		return store.ErrPreconditionFailed
+	}
+	r.written = version
+	return nil
+}
+
+func TestIfMatch(t *testing.T) {
+	car := Car{ID: uuid.New(), Name: "GT3", Class: &Class{ID: uuid.New(), Title: "GT"}, Version: 7}
+	body, err := json.Marshal(&car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := etag(body)
+
+	tests := []struct {
+		name    string
+		method  string
+		ifMatch string
+		racing  bool
+		want    int
+		written Version
+	}{
+		{"update", "PUT", "", false, http.StatusOK, 0},
+		{"update current", "PUT", current, false, http.StatusOK, 7},
+		{"update any", "PUT", "*", false, http.StatusOK, 7},
+		{"update stale", "PUT", `"stale"`, false, http.StatusPreconditionFailed, 0},
+		{"update raced", "PUT", current, true, http.StatusPreconditionFailed, 0},
+		{"update raced without If-Match", "PUT", "", true, http.StatusOK, 0},
+		{"delete", "DELETE", "", false, http.StatusNoContent, 0},
+		{"delete current", "DELETE", current, false, http.StatusNoContent, 7},
+		{"delete stale", "DELETE", `"stale"`, false, http.StatusPreconditionFailed, 0},
+		{"delete raced", "DELETE", current, true, http.StatusPreconditionFailed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &carsRepo{car: car, racing: tt.racing}
+			_, srv := serve(t, repo, nil)
+
+			req, err := http.NewRequest(tt.method, srv.URL+"/cars/"+car.ID.String(), strings.NewReader(
+				`{"name": "GT3 R", "class_id": "`+car.Class.ID.String()+`"}`,
+			))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", res.StatusCode, tt.want)
+			}
+			if repo.written != tt.written {
+				t.Errorf("wrote requiring version %d, want %d", repo.written, tt.written)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+const (
+	// How often the race state of a running event is advanced and published.
+	tickRate = 100 * time.Millisecond
+	// How far back the progress of every car is remembered to compute gaps.
+	gapWindow = 10 * time.Minute
+	// How long a car can go without a sample before its standing is stale.
+	staleAfter = 2 * time.Second
+)
+
+type Flag string
+
+const (
+	FlagGreen     Flag = "green"
+	FlagYellow    Flag = "yellow"
+	FlagRed       Flag = "red"
+	FlagChequered Flag = "chequered"
+)
+
+func (f Flag) valid() bool {
+	switch f {
+	case FlagGreen, FlagYellow, FlagRed, FlagChequered:
+		return true
+	}
+
+	return false
+}
+
+// RaceState is the authoritative state of a running event, published once per tick.
+type RaceState struct {
+	Event uuid.UUID      `json:"event"`
+	Tick  uint64         `json:"tick"`
+	Time  time.Time      `json:"time"`
+	Flag  Flag           `json:"flag"`
+	Laps  uint           `json:"laps"`
+	Cars  []*CarStanding `json:"cars"`
+}
+
+// CarStanding is where a car is in the race, cars are ordered by position.
+type CarStanding struct {
+	Source   string `json:"source"`
+	Position int    `json:"position"`
+	// Lap is the number of completed laps.
+	Lap uint `json:"lap"`
+	// Progress is how far around the current lap the car is, from 0 to 1.
+	Progress float64 `json:"progress"`
+	// Gap is how many seconds the car is behind the leader, Interval behind the car ahead.
+	Gap      float64 `json:"gap"`
+	Interval float64 `json:"interval"`
+	Finished bool    `json:"finished"`
+	// Connected is false once the car's publisher left, the car keeps its place until the event finishes.
+	Connected bool `json:"connected"`
+	// Stale is set when the car hasn't sent a sample for a while, whether it's connected or not.
+	Stale    bool         `json:"stale"`
+	Location msg.Location `json:"location"`
+	Car      msg.CarState `json:"car"`
+}
+
+func telemetryTopic(id uuid.UUID) string {
+	return "events/" + id.String() + "/telemetry"
+}
+
+func stateTopic(id uuid.UUID) string {
+	return "events/" + id.String() + "/state"
+}
+
+// eventRuntime follows the samples published for an event and advances its race state at a fixed rate,
+// so subscribers get one coherent update per tick instead of every car's samples interleaved
+type eventRuntime struct {
+	event  *Event
+	hub    *ws.Hub
+	course *course // nil when the track has no geometry, positions are unknown then
+
+	// feeds is only touched on the hub's goroutine, which hands a new car to run by storing a longer copy of feedList
+	feeds    map[string]*carFeed
+	feedList atomic.Pointer[[]*carFeed]
+
+	mx   *sync.Mutex
+	cars map[string]*carTrack
+	flag Flag
+	tick uint64
+
+	stopObserving func()
+	cancel        context.CancelFunc
+}
+
+// carFeed is the latest sample of a car, passed from the hub's goroutine to the runtime's without a lock
+type carFeed struct {
+	source    string
+	sample    atomic.Pointer[msg.Sample]
+	connected atomic.Bool
+}
+
+// carTrack is what the runtime knows about a car
+type carTrack struct {
+	sample msg.Sample
+	// seen is the sample of the feed the car was last advanced to
+	seen      *msg.Sample
+	connected bool
+	// lap is the number of completed laps, -1 until a car starting behind the line crosses it
+	lap      int
+	distance float64
+	started  bool
+	finished bool
+	finishAt time.Time
+	// history is the total distance covered over time, oldest first
+	history []checkpoint
+}
+
+type checkpoint struct {
+	total float64
+	at    time.Time
+}
+
+func newEventRuntime(hub *ws.Hub, ev *Event) *eventRuntime {
+	r := &eventRuntime{
+		event: ev,
+		hub:   hub,
+		feeds: make(map[string]*carFeed),
+		mx:    &sync.Mutex{},
+		cars:  make(map[string]*carTrack),
+		flag:  FlagGreen,
+	}
+	if ev.Track != nil && ev.Track.Geometry != nil {
+		// a track that isn't valid anymore just means no positions
+		r.course, _ = newCourse(ev.Track.Geometry)
+	}
+	r.feedList.Store(&[]*carFeed{})
+
+	return r
+}
+
+func (r *eventRuntime) start() error {
+	stop, err := r.hub.Observe(telemetryTopic(r.event.ID), r.observe, r.gone)
+	if err != nil {
+		return err
+	}
+	r.stopObserving = stop
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(ctx)
+
+	return nil
+}
+
+func (r *eventRuntime) stop() {
+	r.cancel()
+	r.stopObserving()
+}
+
+// observe runs on the hub's goroutine, it must not wait on run
+func (r *eventRuntime) observe(_ string, s *msg.Sample) {
+	sample := *s
+	f, ok := r.feeds[s.Source]
+	if !ok {
+		f = &carFeed{source: s.Source}
+		r.feeds[s.Source] = f
+	}
+	f.sample.Store(&sample)
+	f.connected.Store(true)
+	if !ok {
+		// run only finds the feed once it has a sample
+		feeds := append(slices.Clone(*r.feedList.Load()), f)
+		r.feedList.Store(&feeds)
+	}
+}
+
+// gone runs on the hub's goroutine, a car whose connection closed stays in the standings, disconnected,
+// and goes with the runtime once the event finishes
+func (r *eventRuntime) gone(_ string, source string) {
+	if f, ok := r.feeds[source]; ok {
+		f.connected.Store(false)
+	}
+}
+
+func (r *eventRuntime) setFlag(f Flag) {
+	r.mx.Lock()
+	r.flag = f
+	r.mx.Unlock()
+}
+
+func (r *eventRuntime) run(ctx context.Context) {
+	ticker := time.NewTicker(tickRate)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			data, err := json.Marshal(r.step(now))
+			if err != nil {
+				continue
+			}
+			_ = r.hub.Publish(stateTopic(r.event.ID), &msg.Message{Type: msg.RaceState, Data: data})
+		}
+	}
+}
+
+// step advances every car to its latest sample and ranks them
+func (r *eventRuntime) step(now time.Time) *RaceState {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.tick++
+	state := &RaceState{
+		Event: r.event.ID,
+		Tick:  r.tick,
+		Time:  now,
+		Flag:  r.flag,
+		Laps:  r.event.Laps,
+		Cars:  make([]*CarStanding, 0, len(r.cars)),
+	}
+
+	for _, f := range *r.feedList.Load() {
+		c, ok := r.cars[f.source]
+		if !ok {
+			c = &carTrack{}
+			r.cars[f.source] = c
+		}
+		c.connected = f.connected.Load()
+		if sample := f.sample.Load(); sample != c.seen {
+			c.seen, c.sample = sample, *sample
+			r.advance(c, now)
+		}
+	}
+
+	tracks := make(map[*CarStanding]*carTrack, len(r.cars))
+	for source, c := range r.cars {
+		standing := &CarStanding{
+			Source:    source,
+			Lap:       uint(max(c.lap, 0)),
+			Finished:  c.finished,
+			Connected: c.connected,
+			Stale:     !c.connected || now.Sub(c.at(now)) > staleAfter,
+			Location:  c.sample.Location,
+			Car:       c.sample.Car,
+		}
+		if r.course != nil {
+			standing.Progress = c.distance / r.course.length
+		}
+		state.Cars = append(state.Cars, standing)
+		tracks[standing] = c
+	}
+
+	sort.Slice(state.Cars, func(i, j int) bool {
+		a, b := tracks[state.Cars[i]], tracks[state.Cars[j]]
+		switch {
+		case a.finished && b.finished:
+			return a.finishAt.Before(b.finishAt)
+		case a.finished != b.finished:
+			return a.finished
+		}
+		if at, bt := r.total(a), r.total(b); at != bt {
+			return at > bt
+		}
+		return state.Cars[i].Source < state.Cars[j].Source
+	})
+
+	var leader *carTrack
+	for i, standing := range state.Cars {
+		standing.Position = i + 1
+		c := tracks[standing]
+		if leader == nil {
+			leader = c
+			continue
+		}
+
+		standing.Gap = r.gap(leader, c, now)
+		standing.Interval = standing.Gap - state.Cars[i-1].Gap
+	}
+
+	if leader != nil && leader.finished {
+		r.flag = FlagChequered
+		state.Flag = r.flag
+	}
+
+	return state
+}
+
+// at is when c's latest sample was taken on the server's clock, now for a sample without a time
+func (c *carTrack) at(now time.Time) time.Time {
+	if c.sample.Time == 0 {
+		return now
+	}
+
+	return time.Unix(0, c.sample.Time)
+}
+
+// advance moves c to its latest sample, counting the laps it completed and timing them by the sample
+func (r *eventRuntime) advance(c *carTrack, now time.Time) {
+	if r.course == nil || c.finished {
+		return
+	}
+	at := c.at(now)
+
+	d := r.course.distance(c.sample.Location)
+	length := r.course.length
+	switch {
+	case !c.started:
+		c.started = true
+		// a car on the grid behind the start line hasn't started its first lap yet
+		if d > length/2 {
+			c.lap = -1
+		}
+	case c.distance > length*3/4 && d < length/4:
+		c.lap++
+	case c.distance < length/4 && d > length*3/4:
+		// went back over the line
+		c.lap--
+	}
+	c.distance = d
+
+	total := r.total(c)
+	if n := len(c.history); n == 0 || total > c.history[n-1].total {
+		c.history = append(c.history, checkpoint{total: total, at: at})
+	}
+	if i := sort.Search(len(c.history), func(i int) bool {
+		return at.Sub(c.history[i].at) < gapWindow
+	}); i > 0 {
+		c.history = slices.Delete(c.history, 0, i)
+	}
+
+	if r.event.Laps > 0 && c.lap >= int(r.event.Laps) {
+		c.finished = true
+		c.finishAt = at
+	}
+}
+
+func (r *eventRuntime) total(c *carTrack) float64 {
+	if r.course == nil {
+		return 0
+	}
+
+	return float64(c.lap)*r.course.length + c.distance
+}
+
+// gap is how long before c the leader was where c is, in seconds of sample time
+func (r *eventRuntime) gap(leader, c *carTrack, now time.Time) float64 {
+	if leader.finished && c.finished {
+		return c.finishAt.Sub(leader.finishAt).Seconds()
+	}
+
+	total := r.total(c)
+	h := leader.history
+	i := sort.Search(len(h), func(i int) bool { return h[i].total >= total })
+	switch {
+	case i == len(h):
+		return 0
+	case i == 0:
+		return c.at(now).Sub(h[0].at).Seconds()
+	}
+
+	// interpolate between the checkpoints around where c is
+	prev, next := h[i-1], h[i]
+	f := (total - prev.total) / (next.total - prev.total)
+	at := prev.at.Add(time.Duration(f * float64(next.at.Sub(prev.at))))
+
+	return c.at(now).Sub(at).Seconds()
+}
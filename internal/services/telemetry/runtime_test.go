@@ -0,0 +1,139 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+// onSquare is the point d metres around the square lap of square() from its start/finish line
+func onSquare(d float64) msg.Location {
+	switch {
+	case d < 50:
+		return msg.Location{X: float32(50 + d)}
+	case d < 150:
+		return msg.Location{X: 100, Y: float32(d - 50)}
+	case d < 250:
+		return msg.Location{X: float32(100 - (d - 150)), Y: 100}
+	case d < 350:
+		return msg.Location{Y: float32(100 - (d - 250))}
+	}
+	return msg.Location{X: float32(d - 350)}
+}
+
+func squareRuntime(t *testing.T) *eventRuntime {
+	t.Helper()
+	g := square()
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	r := newEventRuntime(nil, &Event{ID: uuid.New(), Track: &Track{Geometry: g}})
+	if r.course == nil {
+		t.Fatal("no course")
+	}
+
+	return r
+}
+
+// standing finds source in the state
+func standing(t *testing.T, state *RaceState, source string) *CarStanding {
+	t.Helper()
+	for _, c := range state.Cars {
+		if c.Source == source {
+			return c
+		}
+	}
+	t.Fatalf("%s not in the standings", source)
+	return nil
+}
+
+// gaps are timed by when the samples were taken, not by when the ticks happen to run
+func TestRuntimeGapSampleTime(t *testing.T) {
+	r := squareRuntime(t)
+	start := time.Now()
+	sample := func(source string, d float64, at time.Duration) {
+		r.observe("", &msg.Sample{Source: source, Location: onSquare(d), Time: start.Add(at).UnixNano()})
+	}
+
+	// ticks run late and unevenly, far from the samples' times
+	tick := start.Add(time.Hour)
+	for i, d := range []float64{10, 20, 30} {
+		sample("a", d, time.Duration(i)*time.Second)
+		tick = tick.Add(time.Duration(i+1) * 7 * time.Second)
+		r.step(tick)
+	}
+	sample("b", 20, 4*time.Second)
+	state := r.step(tick.Add(time.Minute))
+
+	b := standing(t, state, "b")
+	if b.Position != 2 || b.Gap != 3 {
+		t.Errorf("b is P%d %gs behind, want P2 3s behind", b.Position, b.Gap)
+	}
+}
+
+// a car whose publisher leaves keeps its place, marked disconnected, until the runtime goes with the event
+func TestRuntimeGone(t *testing.T) {
+	r := squareRuntime(t)
+	now := time.Now()
+	r.observe("", &msg.Sample{Source: "a", Location: onSquare(30), Time: now.UnixNano()})
+	r.observe("", &msg.Sample{Source: "b", Location: onSquare(10), Time: now.UnixNano()})
+
+	tests := []struct {
+		name      string
+		change    func()
+		tick      time.Time
+		connected bool
+		stale     bool
+	}{
+		{"connected", func() {}, now, true, false},
+		{"no sample for a while", func() {}, now.Add(staleAfter + time.Second), true, true},
+		{"gone", func() { r.gone("", "a") }, now, false, true},
+		{"back", func() {
+			r.observe("", &msg.Sample{Source: "a", Location: onSquare(40), Time: now.Add(time.Second).UnixNano()})
+		}, now.Add(time.Second), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			state := r.step(tt.tick)
+			if len(state.Cars) != 2 {
+				t.Fatalf("%d cars in the standings", len(state.Cars))
+			}
+			a := standing(t, state, "a")
+			if a.Position != 1 || a.Connected != tt.connected || a.Stale != tt.stale {
+				t.Errorf("a is P%d, connected %v, stale %v", a.Position, a.Connected, a.Stale)
+			}
+		})
+	}
+}
+
+// the hub hands samples over while the runtime steps, neither waits on the other
+func TestRuntimeObserveConcurrent(t *testing.T) {
+	r := squareRuntime(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			source := string(rune('a' + i%5))
+			r.observe("", &msg.Sample{Source: source, Location: onSquare(float64(i % 400)), Time: time.Now().UnixNano()})
+			if i%100 == 0 {
+				r.gone("", source)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			if n := len(r.step(time.Now()).Cars); n != 5 {
+				t.Errorf("%d cars in the standings, want 5", n)
+			}
+			return
+		default:
+			r.step(time.Now())
+		}
+	}
+}
@@ -1,29 +1,110 @@
 package telemetry
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
 	"github.com/pmoieni/project-racer-server/internal/lib"
 	"github.com/pmoieni/project-racer-server/internal/net"
-	"github.com/pmoieni/project-racer-server/internal/net/websocket"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+	"github.com/pmoieni/project-racer-server/internal/store"
 )
 
 var _ net.Service = (*TelemetryService)(nil)
 
+// bounds of the flush interval a subscriber may ask for
+const (
+	minFlushInterval = time.Millisecond
+	maxFlushInterval = time.Second
+)
+
+// bounds of the rate distant cars are sent at to a subscriber with an area of interest
+const (
+	minFarInterval = 10 * time.Millisecond
+	maxFarInterval = 10 * time.Second
+)
+
+// admission limits of the hub
+var limits = admission.Limits{
+	Total: 10000,
+	// kept for drivers and race control, a full grid with its engineers and officials
+	Reserved:    500,
+	PerIP:       16,
+	PerIdentity: 16,
+	RetryAfter:  15 * time.Second,
+}
+
+// longest delay spectators may be given, the hub holds every message of the topic meanwhile
+const maxSpectatorDelay = 5 * time.Minute
+
+// bounds of the samples per second of each source a subscriber may ask for
+const (
+	minRate = 0.1
+	maxRate = 1000.0
+)
+
 type TelemetryService struct {
 	*http.ServeMux
 
-	hub *websocket.Hub
-	log *lib.Logger
+	hub  *ws.Hub
+	repo TelemetryRepo
+	auth Authenticator
+	log  *lib.Logger
+
+	runtimesMx *sync.Mutex
+	runtimes   map[uuid.UUID]*eventRuntime
 }
 
-func New() (*TelemetryService, error) {
+var (
+	ErrEventRunning    = errors.New("event is already running")
+	ErrEventNotRunning = errors.New("event is not running")
+)
+
+// New sets up the service, its scheduler runs until ctx is done.
+// Without an authenticator every connection is an anonymous spectator.
+func New(ctx context.Context, repo TelemetryRepo, auth Authenticator) (*TelemetryService, error) {
 	s := &TelemetryService{
 		ServeMux: http.NewServeMux(),
-		hub:      websocket.NewHub(),
+		hub:      ws.NewHub(),
+		repo:     repo,
+		auth:     auth,
 		log:      lib.NewLogger("telemetry"),
+
+		runtimesMx: &sync.Mutex{},
+		runtimes:   make(map[uuid.UUID]*eventRuntime),
+	}
+
+	s.hub.SetLimits(limits)
+
+	// raw samples are small, binary and sent at a high rate, deflate costs more CPU than it saves
+	if err := s.hub.ConfigureTopic("events/*/telemetry", ws.TopicOptions{
+		Compression: websocket.CompressionDisabled,
+	}); err != nil {
+		return nil, err
 	}
+	// leaderboards repeat most of their JSON between updates
+	if err := s.hub.ConfigureTopic("events/*/leaderboard", ws.TopicOptions{
+		Compression: websocket.CompressionContextTakeover,
+	}); err != nil {
+		return nil, err
+	}
+	// so is the race state, only positions and gaps change from one tick to the next
+	if err := s.hub.ConfigureTopic("events/*/state", ws.TopicOptions{
+		Compression: websocket.CompressionContextTakeover,
+	}); err != nil {
+		return nil, err
+	}
+
 	s.setupControllers()
+	go s.schedule(ctx)
 
 	return s, nil
 }
@@ -33,12 +114,212 @@ func (s *TelemetryService) MountPath() string {
 }
 
 func (s *TelemetryService) setupControllers() {
-	s.HandleFunc("GET /ws", handleConn(s.hub))
+	handleResource(s.ServeMux, "tracks", resource[*Track, *TrackParams]{
+		get: s.repo.GetTrack, create: s.repo.CreateTrack, update: s.repo.UpdateTrack, delete: s.repo.DeleteTrack,
+	})
+	handleResource(s.ServeMux, "cars", resource[*Car, *CarParams]{
+		get: s.repo.GetCar, create: s.repo.CreateCar, update: s.repo.UpdateCar, delete: s.repo.DeleteCar,
+	})
+	handleResource(s.ServeMux, "classes", resource[*Class, *ClassParams]{
+		get: s.repo.GetClass, create: s.repo.CreateClass, update: s.repo.UpdateClass, delete: s.repo.DeleteClass,
+	})
+	handleResource(s.ServeMux, "events", resource[*Event, *EventParams]{
+		get: s.repo.GetEvent, create: s.repo.CreateEvent, update: s.repo.UpdateEvent, delete: s.repo.DeleteEvent,
+	})
+	handleResource(s.ServeMux, "drivers", resource[*Driver, *DriverParams]{
+		get: s.repo.GetDriver, create: s.repo.CreateDriver, update: s.repo.UpdateDriver, delete: s.repo.DeleteDriver,
+	})
+	handleResource(s.ServeMux, "teams", resource[*Team, *TeamParams]{
+		get: s.repo.GetTeam, create: s.repo.CreateTeam, update: s.repo.UpdateTeam, delete: s.repo.DeleteTeam,
+	})
+	s.HandleFunc("GET /tracks/{id}/geometry", getGeometry(s))
+	s.HandleFunc("PUT /tracks/{id}/geometry", putGeometry(s))
+	s.HandleFunc("POST /tracks/{id}/survey", surveyTrack(s))
+	s.HandleFunc("GET /tracks", listEntities(trackFilter, s.repo.ListTracks))
+	s.HandleFunc("GET /cars", listEntities(carFilter, s.repo.ListCars))
+	s.HandleFunc("GET /classes", listEntities(classFilter, s.repo.ListClasses))
+	s.HandleFunc("GET /events", listEntities(eventFilter, s.repo.ListEvents))
+	s.HandleFunc("GET /drivers", listEntities(driverFilter, s.repo.ListDrivers))
+	s.HandleFunc("GET /teams", listEntities(teamFilter, s.repo.ListTeams))
+
+	s.HandleFunc("GET /events/{id}/entries", listEntities(entryFilter, s.repo.ListEntries))
+	s.HandleFunc("POST /events/{id}/entries", createEntry(s))
+	s.HandleFunc("GET /events/{id}/entries/{entry}", getEntry(s))
+	s.HandleFunc("POST /events/{id}/entries/{entry}/withdraw", withdrawEntry(s))
+
+	s.HandleFunc("GET /ws", handleConn(s))
+	s.HandleFunc("GET /ws/{topic...}", handleConn(s))
+
+	s.handleAdmin("GET /admin/connections", listConns(s.hub))
+	s.handleAdmin("GET /admin/connections/{id}", getConn(s.hub))
+	s.handleAdmin("GET /admin/topics", listTopics(s.hub))
+	s.handleAdmin("POST /admin/connections/{id}/kick", kickConn(s.hub))
+	s.handleAdmin("PUT /admin/connections/{id}/mute", muteConn(s.hub))
+	s.handleAdmin("GET /admin/bans", listBans(s.hub))
+	s.handleAdmin("POST /admin/bans", createBan(s.hub))
+	s.handleAdmin("DELETE /admin/bans/{id}", deleteBan(s.hub))
+	s.handleAdmin("PUT /admin/events/{id}/state", setEventState(s))
+	s.handleAdmin("PUT /admin/events/{id}/flag", setFlag(s))
+	s.handleAdmin("PUT /admin/events/{id}/delay", setSpectatorDelay(s))
+}
+
+// StartEvent starts advancing the race state of ev.
+// Cars publish their samples on "events/{id}/telemetry" and the state is published on "events/{id}/state".
+func (s *TelemetryService) StartEvent(ev *Event) error {
+	s.runtimesMx.Lock()
+	defer s.runtimesMx.Unlock()
+
+	if _, ok := s.runtimes[ev.ID]; ok {
+		return ErrEventRunning
+	}
+
+	r := newEventRuntime(s.hub, ev)
+	if r.course == nil {
+		s.log.Warn(fmt.Sprintf("event %s: track has no geometry, cars won't be ranked", ev.ID))
+	}
+	if err := r.start(); err != nil {
+		return err
+	}
+	s.runtimes[ev.ID] = r
+
+	return nil
+}
+
+// StopEvent stops advancing the race state of an event, false if it wasn't running.
+func (s *TelemetryService) StopEvent(id uuid.UUID) bool {
+	s.runtimesMx.Lock()
+	defer s.runtimesMx.Unlock()
+
+	r, ok := s.runtimes[id]
+	if !ok {
+		return false
+	}
+	r.stop()
+	delete(s.runtimes, id)
+
+	return true
+}
+
+// SetSpectatorDelay holds back every topic of an event by d for spectators, 0 sends it live again.
+func (s *TelemetryService) SetSpectatorDelay(id uuid.UUID, d time.Duration) error {
+	return s.hub.SetDelay("events/"+id.String()+"/*", d)
+}
+
+// SetFlag changes the flag of a running event.
+func (s *TelemetryService) SetFlag(id uuid.UUID, f Flag) error {
+	if !f.valid() {
+		return fmt.Errorf("invalid flag %q", f)
+	}
+
+	s.runtimesMx.Lock()
+	r, ok := s.runtimes[id]
+	s.runtimesMx.Unlock()
+	if !ok {
+		return ErrEventNotRunning
+	}
+	r.setFlag(f)
+
+	return nil
 }
 
-func handleConn(hub *websocket.Hub) http.HandlerFunc {
+func handleConn(s *TelemetryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// TODO: do some checks here
-		hub.ServeHTTP(w, r)
+		sub, err := subscription(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := s.identify(r)
+		if err != nil {
+			writeAuthErr(w, err)
+			return
+		}
+		// samples are only attributed to a driver who proved who they are, others publish as their identity
+		if id.Role == ws.RoleDriver && id.Authenticated {
+			if id.Driver, err = s.driverOf(r.Context(), id.ID); err != nil {
+				writeJoinErr(w, err)
+				return
+			}
+		}
+		if err := s.mayJoin(r.Context(), id, sub.Topic); err != nil {
+			writeJoinErr(w, err)
+			return
+		}
+		s.hub.Serve(w, r.WithContext(ws.WithIdentity(r.Context(), id)), sub)
+	}
+}
+
+// subscription reads what the client asks of the hub from the request
+func subscription(r *http.Request) (ws.Subscription, error) {
+	sub := ws.Subscription{Topic: r.PathValue("topic")}
+	if sub.Topic == "" {
+		sub.Topic = ws.DefaultTopic
+	}
+
+	if v := r.URL.Query().Get("flush"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minFlushInterval || d > maxFlushInterval {
+			return sub, fmt.Errorf("flush must be a duration between %s and %s", minFlushInterval, maxFlushInterval)
+		}
+		sub.FlushInterval = d
+	}
+
+	if v := r.URL.Query().Get("rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < minRate || rate > maxRate {
+			return sub, fmt.Errorf("rate must be a number of samples per second between %g and %g", minRate, maxRate)
+		}
+		sub.MaxRate = rate
+
+		if v := r.URL.Query().Get("downsample"); v != "" {
+			sub.Downsample = ws.Downsampling(v)
+			if !sub.Downsample.Valid() {
+				return sub, fmt.Errorf("downsample must be %s or %s", ws.DownsampleDecimate, ws.DownsampleAverage)
+			}
+		}
+	}
+
+	if v := r.URL.Query().Get("radius"); v != "" {
+		radius, err := strconv.ParseFloat(v, 32)
+		if err != nil || radius <= 0 {
+			return sub, errors.New("radius must be a positive number")
+		}
+		sub.Interest = &ws.Interest{Radius: float32(radius), Follow: r.URL.Query().Get("follow")}
+
+		if v := r.URL.Query().Get("far"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < minFarInterval || d > maxFarInterval {
+				return sub, fmt.Errorf("far must be a duration between %s and %s", minFarInterval, maxFarInterval)
+			}
+			sub.Interest.FarInterval = d
+		}
+	}
+
+	return sub, nil
+}
+
+var ErrNoDriver = errors.New("no driver is linked to the identity")
+
+// driverOf returns the ID of the driver linked to a login identity, publishers are attributed to it
+func (s *TelemetryService) driverOf(ctx context.Context, identity string) (string, error) {
+	if identity == "" {
+		return "", ErrNoDriver
+	}
+	d, err := s.repo.GetDriverByIdentity(ctx, identity)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", ErrNoDriver
+	} else if err != nil {
+		return "", err
+	}
+
+	return d.ID.String(), nil
+}
+
+func writeJoinErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoDriver) || errors.Is(err, ErrNotTeammate) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
@@ -0,0 +1,198 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/lib"
+	"github.com/pmoieni/project-racer-server/internal/net/admission"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+// driversRepo links login identities to drivers
+type driversRepo struct {
+	TelemetryRepo
+	drivers map[string]uuid.UUID
+}
+
+func (r *driversRepo) GetDriverByIdentity(_ context.Context, identity string) (*Driver, error) {
+	id, ok := r.drivers[identity]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &Driver{ID: id}, nil
+}
+
+// serve runs a service on repo and auth without its scheduler
+func serve(t *testing.T, repo TelemetryRepo, auth Authenticator) (*TelemetryService, *httptest.Server) {
+	t.Helper()
+	s := &TelemetryService{
+		ServeMux:   http.NewServeMux(),
+		hub:        ws.NewHub(),
+		repo:       repo,
+		auth:       auth,
+		log:        lib.NewLogger("telemetry"),
+		runtimesMx: &sync.Mutex{},
+		runtimes:   make(map[uuid.UUID]*eventRuntime),
+	}
+	s.setupControllers()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	return s, srv
+}
+
+// dial connects to path of srv with the bearer token if any
+func dial(t *testing.T, srv *httptest.Server, path, token string) (*websocket.Conn, int) {
+	t.Helper()
+	opts := &websocket.DialOptions{HTTPHeader: http.Header{}}
+	if token != "" {
+		opts.HTTPHeader.Set("Authorization", "Bearer "+token)
+	}
+	conn, res, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+path, opts)
+	if res == nil {
+		t.Fatal(err)
+	}
+	if conn != nil {
+		t.Cleanup(func() { conn.CloseNow() })
+	}
+
+	return conn, res.StatusCode
+}
+
+// the capacity kept for drivers and race control goes to whoever authenticates as one, not to whoever claims to be
+func TestHandleConnReserved(t *testing.T) {
+	repo := &driversRepo{drivers: map[string]uuid.UUID{"d": uuid.New()}}
+	tokens := testTokens(t)
+	if err := tokens.Add("unlinked", "nobody", ws.RoleDriver); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"anonymous", "/ws", "", http.StatusServiceUnavailable},
+		{"declared driver", "/ws?identity=d&role=driver", "", http.StatusServiceUnavailable},
+		{"declared race control", "/ws?identity=rc&role=race_control", "", http.StatusServiceUnavailable},
+		{"spectator", "/ws", "fan", http.StatusServiceUnavailable},
+		{"unknown token", "/ws", "guess", http.StatusUnauthorized},
+		{"driver without a driver", "/ws", "unlinked", http.StatusForbidden},
+		{"driver", "/ws", "driver", http.StatusSwitchingProtocols},
+		{"race control", "/ws", "rc", http.StatusSwitchingProtocols},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := serve(t, repo, tokens)
+			s.hub.SetLimits(admission.Limits{Total: 1, Reserved: 1})
+
+			if _, status := dial(t, srv, tt.path, tt.token); status != tt.want {
+				t.Errorf("got %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+// samples are attributed to the driver linked to an authenticated identity, a declared one publishes as its connection
+func TestHandleConnAttribution(t *testing.T) {
+	driver := uuid.New()
+	repo := &driversRepo{drivers: map[string]uuid.UUID{"d": driver}}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  func(source string) bool
+	}{
+		{"driver", "/ws/events/1/telemetry", "driver", func(source string) bool { return source == driver.String() }},
+		{"declared driver", "/ws/events/1/telemetry?identity=d&role=driver", "", func(source string) bool {
+			_, err := uuid.Parse(source)
+			return err == nil && source != driver.String()
+		}},
+		{"race control", "/ws/events/1/telemetry", "rc", func(source string) bool { return source == "rc" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := serve(t, repo, testTokens(t))
+			sources := make(chan string, 1)
+			stop, err := s.hub.Observe("events/*/telemetry", func(_ string, sample *msg.Sample) {
+				select {
+				case sources <- sample.Source:
+				default:
+				}
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stop()
+
+			conn, status := dial(t, srv, tt.path, tt.token)
+			if status != http.StatusSwitchingProtocols {
+				t.Fatalf("got %d", status)
+			}
+			data, err := msg.EncodeSample(&msg.Sample{Seq: 1, Time: time.Now().UnixNano()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.Write(context.Background(), websocket.MessageBinary, data); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case source := <-sources:
+				if !tt.want(source) {
+					t.Errorf("attributed to %q", source)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("sample not delivered")
+			}
+		})
+	}
+}
+
+func TestSubscriptionInterest(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *ws.Interest
+		ok    bool
+	}{
+		{"", nil, true},
+		{"radius=50", &ws.Interest{Radius: 50}, true},
+		{"radius=50&follow=car-7&far=500ms", &ws.Interest{Radius: 50, Follow: "car-7", FarInterval: 500 * time.Millisecond}, true},
+		// without a radius there's no area to follow
+		{"follow=car-7&far=500ms", nil, true},
+		{"radius=0", nil, false},
+		{"radius=-1", nil, false},
+		{"radius=near", nil, false},
+		{"radius=50&far=1ms", nil, false},
+		{"radius=50&far=1m", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			sub, err := subscription(httptest.NewRequest(http.MethodGet, "/ws?"+tt.query, nil))
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v", err)
+			}
+			if !tt.ok {
+				return
+			}
+			if (sub.Interest == nil) != (tt.want == nil) || (sub.Interest != nil && *sub.Interest != *tt.want) {
+				t.Errorf("got %+v, want %+v", sub.Interest, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+)
+
+/*
+surveys
+
+- a survey builds the geometry of a track from one lap driven around it, recorded as the samples a car publishes
+- samples are taken in the order they're sent, the recording may start anywhere on the lap
+- the lap ends where the car first comes back to where it started, anything recorded after is dropped
+- a lap stopped a little short of where it started is joined to it
+- the path is resampled every few metres and smoothed with a moving average, which irons out the car's line and stray samples
+- the start/finish line is guessed across the middle of the longest straight, or where the recording starts without one
+- sectors and pit gates aren't guessed, they're added by editing the geometry
+*/
+
+const (
+	// a sample closer than this to the one before is the car standing still
+	surveyMinStep = 0.1
+	// metres between points of the centreline, long tracks get them further apart to stay within maxCentrelinePoints
+	surveySpacing = 5.0
+	// points averaged on each side of a point when smoothing
+	surveySmoothing = 3
+	// how many track widths from its start the lap has to come back to, and twice that to leave
+	surveyReturnWidths = 4
+	// a straight bends less than a curve of this radius in metres over any straightChord of it,
+	// and is at least minStraightLength long
+	straightRadius    = 300.0
+	straightChord     = 50.0
+	minStraightLength = 50.0
+)
+
+// Bodies of a survey are bigger than others, a lap is a few minutes of samples.
+const maxSurveySize = 16 << 20
+
+// Survey builds the geometry of a track width metres wide from a recorded lap, placed at origin if it isn't nil.
+func Survey(samples []msg.Sample, width float64, origin *GeoPoint) (*Geometry, error) {
+	if !(width > 0) || math.IsInf(width, 0) {
+		return nil, errors.New("width must be positive")
+	}
+
+	var path []Point
+	for _, s := range samples {
+		p := Point{X: float64(s.Location.X), Y: float64(s.Location.Y)}
+		if len(path) > 0 && p.dist(path[len(path)-1]) < surveyMinStep {
+			continue
+		}
+		path = append(path, p)
+	}
+	if len(path) < minCentrelinePoints {
+		return nil, fmt.Errorf("survey needs at least %d distinct locations", minCentrelinePoints)
+	}
+
+	loop, err := closeLap(path, width)
+	if err != nil {
+		return nil, err
+	}
+	length := loopLength(loop)
+	spacing := math.Max(surveySpacing, length/(maxCentrelinePoints/2))
+	count := int(length / spacing)
+	if count < minCentrelinePoints {
+		return nil, fmt.Errorf("lap is %.0f m long, too short for a track", length)
+	}
+
+	points := smooth(resample(loop, count))
+	g := &Geometry{
+		Centreline:  append(points, points[0]),
+		Width:       width,
+		StartFinish: startFinish(points, width),
+		Origin:      origin,
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("surveyed lap is not a track: %w", err)
+	}
+
+	return g, nil
+}
+
+// closeLap cuts path where it first comes back closest to its start, the loop it returns joins its last point to its first
+func closeLap(path []Point, width float64) ([]Point, error) {
+	near := surveyReturnWidths * width
+	away := false
+	end, best := -1, math.Inf(1)
+	for i, p := range path {
+		d := p.dist(path[0])
+		if !away {
+			away = d > 2*near
+			continue
+		}
+		if d <= near && d < best {
+			end, best = i, d
+		} else if d > near && end >= 0 {
+			// leaving the start again, this is a second lap
+			break
+		}
+	}
+	if end < 0 {
+		return nil, errors.New("lap doesn't come back to where it started, record a full lap")
+	}
+
+	// the joining segment can't be shorter than the others
+	if best < surveyMinStep {
+		end--
+	}
+
+	return path[:end+1], nil
+}
+
+func loopLength(loop []Point) float64 {
+	length := 0.0
+	for i, p := range loop {
+		length += p.dist(loop[(i+1)%len(loop)])
+	}
+
+	return length
+}
+
+// resample returns count points evenly spaced around loop, starting at its first
+func resample(loop []Point, count int) []Point {
+	n := len(loop)
+	step := loopLength(loop) / float64(count)
+	out := make([]Point, count)
+
+	// at is the distance around the loop to the start of segment seg
+	seg, at := 0, 0.0
+	for k := range out {
+		d := float64(k) * step
+		a, b := loop[seg], loop[(seg+1)%n]
+		for l := a.dist(b); d > at+l && seg < n-1; l = a.dist(b) {
+			at += l
+			seg++
+			a, b = loop[seg], loop[(seg+1)%n]
+		}
+		t := math.Min(1, (d-at)/a.dist(b))
+		out[k] = Point{X: a.X + t*(b.X-a.X), Y: a.Y + t*(b.Y-a.Y)}
+	}
+
+	return out
+}
+
+// smooth averages each point of a loop with its surveySmoothing neighbours on each side
+func smooth(points []Point) []Point {
+	n := len(points)
+	w := float64(2*surveySmoothing + 1)
+	out := make([]Point, n)
+	for i := range points {
+		var sum Point
+		for k := i - surveySmoothing; k <= i+surveySmoothing; k++ {
+			p := points[(k%n+n)%n]
+			sum.X += p.X
+			sum.Y += p.Y
+		}
+		out[i] = Point{X: sum.X / w, Y: sum.Y / w}
+	}
+
+	return out
+}
+
+// startFinish guesses the start/finish line of a loop: across the middle of its longest straight, or its first segment
+func startFinish(points []Point, width float64) Gate {
+	n := len(points)
+	at := func(i int) Point { return points[(i%n+n)%n] }
+
+	// a point is on a straight if it's closer to the chord between its neighbours straightChord apart than a
+	// straightRadius curve would be, a long chord rather than the next points keeps the car's line out of it
+	spacing := loopLength(points) / float64(n)
+	k := max(1, int(math.Ceil(straightChord/2/spacing)))
+	chord := 2 * float64(k) * spacing
+	sagitta := chord * chord / (8 * straightRadius)
+	straight := func(i int) bool {
+		a, b, p := at(i-k), at(i+k), at(i)
+		l := a.dist(b)
+		return l > 0 && math.Abs(cross(Point{X: b.X - a.X, Y: b.Y - a.Y}, Point{X: p.X - a.X, Y: p.Y - a.Y}))/l < sagitta
+	}
+
+	// runs of straight points, going round twice to catch the one across the first point
+	seg, longest := 0, 0
+	start, run := 0, 0
+	for i := 0; i < 2*n && longest < n; i++ {
+		if !straight(i) {
+			run = 0
+			continue
+		}
+		if run == 0 {
+			start = i
+		}
+		run++
+		if run > longest {
+			longest = run
+			seg = (start + run/2) % n
+		}
+	}
+	if float64(longest)*spacing < minStraightLength {
+		seg = 0
+	}
+
+	// across the middle of the segment from seg, as wide as the track
+	a, b := at(seg), at(seg+1)
+	l := a.dist(b)
+	nx, ny := -(b.Y-a.Y)/l*width/2, (b.X-a.X)/l*width/2
+	mid := Point{X: (a.X + b.X) / 2, Y: (a.Y + b.Y) / 2}
+
+	return Gate{A: Point{X: mid.X + nx, Y: mid.Y + ny}, B: Point{X: mid.X - nx, Y: mid.Y - ny}}
+}
+
+// surveyTrack replaces the geometry of a track with one surveyed from the lap in the body.
+// ?width= is the track's width in metres, ?lon= and ?lat= place it, it keeps its origin without them.
+func surveyTrack(s *TelemetryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		q := &query{Values: r.URL.Query()}
+		width, lon, lat := q.float("width"), q.float("lon"), q.float("lat")
+		if q.err == nil && width == nil {
+			q.err = errors.New("width is required")
+		}
+		if q.err == nil && (lon == nil) != (lat == nil) {
+			q.err = errors.New("lon and lat go together")
+		}
+		if q.err != nil {
+			http.Error(w, q.err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var samples []msg.Sample
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSurveySize)).Decode(&samples); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		current, err := s.repo.GetTrack(r.Context(), id)
+		if err != nil {
+			writeStoreErr(w, err)
+			return
+		}
+		if _, ok := checkIfMatch(w, r, current); !ok {
+			return
+		}
+
+		var origin *GeoPoint
+		if lon != nil {
+			origin = &GeoPoint{Lon: *lon, Lat: *lat}
+		} else if current.Geometry != nil {
+			origin = current.Geometry.Origin
+		}
+		g, err := Survey(samples, *width, origin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		// the name is the one read, a rename made meanwhile fails the update rather than being undone
+		track, err := s.repo.UpdateTrack(r.Context(), id, &TrackParams{Name: current.Name, Geometry: g}, current.Version)
+		if err != nil {
+			writeParamsErr(w, err)
+			return
+		}
+		writeEntity(w, r, http.StatusOK, track)
+	}
+}
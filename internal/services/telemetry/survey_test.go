@@ -0,0 +1,229 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/net/msg"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+// the stadium is two 300 m straights along y = 0 and y = 160 joined by half circles, driven anticlockwise
+const (
+	stadiumStraight = 300.0
+	stadiumRadius   = 80.0
+)
+
+var stadiumLength = 2*stadiumStraight + 2*math.Pi*stadiumRadius
+
+// stadium returns the point s metres around the stadium from the start of its bottom straight
+func stadium(s float64) Point {
+	s = math.Mod(s, stadiumLength)
+	curve := math.Pi * stadiumRadius
+	switch {
+	case s < stadiumStraight:
+		return Point{s, 0}
+	case s < stadiumStraight+curve:
+		a := -math.Pi/2 + (s-stadiumStraight)/stadiumRadius
+		return Point{stadiumStraight + stadiumRadius*math.Cos(a), stadiumRadius + stadiumRadius*math.Sin(a)}
+	case s < 2*stadiumStraight+curve:
+		return Point{stadiumStraight - (s - stadiumStraight - curve), 2 * stadiumRadius}
+	default:
+		a := math.Pi/2 + (s-2*stadiumStraight-curve)/stadiumRadius
+		return Point{stadiumRadius * math.Cos(a), stadiumRadius + stadiumRadius*math.Sin(a)}
+	}
+}
+
+// offStadium is how far p is from the stadium's line
+func offStadium(p Point) float64 {
+	switch {
+	case p.X < 0:
+		return math.Abs(p.dist(Point{0, stadiumRadius}) - stadiumRadius)
+	case p.X > stadiumStraight:
+		return math.Abs(p.dist(Point{stadiumStraight, stadiumRadius}) - stadiumRadius)
+	}
+	return math.Min(math.Abs(p.Y), math.Abs(p.Y-2*stadiumRadius))
+}
+
+// lap records a car driving laps of the stadium from start metres in, a sample every metre with noise metres of error
+func lap(start, laps, noise float64) []msg.Sample {
+	r := rand.New(rand.NewPCG(1, 2))
+	var samples []msg.Sample
+	for s := start; s < start+laps*stadiumLength; s++ {
+		p := stadium(s)
+		samples = append(samples, msg.Sample{Location: msg.Location{
+			X: float32(p.X + noise*(2*r.Float64()-1)),
+			Y: float32(p.Y + noise*(2*r.Float64()-1)),
+		}})
+	}
+	return samples
+}
+
+func TestSurvey(t *testing.T) {
+	tests := []struct {
+		name  string
+		start float64
+		laps  float64
+	}{
+		{"from a straight", 100, 1},
+		{"from a curve", stadiumStraight + 60, 1},
+		// stopped a couple of metres short of where it started
+		{"short of the line", 500, 0.998},
+		{"on into a second lap", 800, 1.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := &GeoPoint{Lon: 7.42, Lat: 43.73}
+			g, err := Survey(lap(tt.start, tt.laps, 0.5), 12, origin)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := len(g.Centreline)
+			if g.Centreline[0] != g.Centreline[n-1] {
+				t.Error("centreline isn't closed")
+			}
+			if math.Abs(g.Length-stadiumLength) > stadiumLength/100 {
+				t.Errorf("lap is %.1f m, want %.1f", g.Length, stadiumLength)
+			}
+			// spaced along the recorded path, the noise makes it longer than the lap
+			for i := 1; i < n; i++ {
+				if d := g.Centreline[i].dist(g.Centreline[i-1]); d < surveySpacing/2 || d > surveySpacing {
+					t.Fatalf("points %d and %d are %.2f m apart", i-1, i, d)
+				}
+			}
+			for i, p := range g.Centreline {
+				if d := offStadium(p); d > 1 {
+					t.Fatalf("point %d %v is %.2f m off the line", i, p, d)
+				}
+			}
+			if g.Width != 12 || g.Origin != origin || len(g.Sectors) != 0 || g.PitEntry != nil {
+				t.Errorf("got width %g, origin %v, sectors %v, pit entry %v", g.Width, g.Origin, g.Sectors, g.PitEntry)
+			}
+
+			// across one of the straights, as wide as the track
+			sf := g.StartFinish
+			mid := Point{(sf.A.X + sf.B.X) / 2, (sf.A.Y + sf.B.Y) / 2}
+			if mid.X < 0 || mid.X > stadiumStraight || offStadium(mid) > 1 {
+				t.Errorf("start/finish line at %v, not on a straight", mid)
+			}
+			if math.Abs(sf.A.X-sf.B.X) > 1 || math.Abs(sf.A.dist(sf.B)-g.Width) > 1e-9 {
+				t.Errorf("start/finish line %v isn't across the straight", sf)
+			}
+		})
+	}
+}
+
+func TestSurveyFails(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []msg.Sample
+		width   float64
+		errors  string
+	}{
+		{"no width", lap(0, 1, 0), 0, "width"},
+		{"negative width", lap(0, 1, 0), -12, "width"},
+		{"width not a number", lap(0, 1, 0), math.NaN(), "width"},
+		{"infinite width", lap(0, 1, 0), math.Inf(1), "width"},
+		{"no samples", nil, 12, "at least"},
+		{"standing still", make([]msg.Sample, 100), 12, "at least"},
+		{"half a lap", lap(0, 0.5, 0), 12, "doesn't come back"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Survey(tt.samples, tt.width, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.errors) {
+				t.Fatalf("got %v, want an error about %q", err, tt.errors)
+			}
+		})
+	}
+}
+
+// tracksRepo holds a single track
+type tracksRepo struct {
+	TelemetryRepo
+	track *Track
+}
+
+func (r *tracksRepo) GetTrack(context.Context, uuid.UUID) (*Track, error) {
+	return r.track, nil
+}
+
+func (r *tracksRepo) UpdateTrack(_ context.Context, id uuid.UUID, p *TrackParams, version Version) (*Track, error) {
+	if version != 0 && version != r.track.Version {
+		return nil, store.ErrPreconditionFailed
+	}
+	r.track = &Track{ID: id, Name: p.Name, Geometry: p.Geometry, Version: r.track.Version + 1}
+	return r.track, nil
+}
+
+func TestSurveyTrack(t *testing.T) {
+	samples, err := json.Marshal(lap(0, 1, 0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed := &GeoPoint{Lon: 2.35, Lat: 48.85}
+
+	tests := []struct {
+		name   string
+		query  string
+		body   []byte
+		status int
+		origin *GeoPoint
+	}{
+		{"keeps the origin", "width=12", samples, http.StatusOK, placed},
+		{"placed", "width=12&lon=7.42&lat=43.73", samples, http.StatusOK, &GeoPoint{Lon: 7.42, Lat: 43.73}},
+		{"no width", "", samples, http.StatusBadRequest, nil},
+		{"width not a number", "width=wide", samples, http.StatusBadRequest, nil},
+		{"width NaN", "width=NaN", samples, http.StatusBadRequest, nil},
+		{"width infinite", "width=Inf", samples, http.StatusBadRequest, nil},
+		{"negative width", "width=-12", samples, http.StatusUnprocessableEntity, nil},
+		{"lon without lat", "width=12&lon=7.42", samples, http.StatusBadRequest, nil},
+		{"lat without lon", "width=12&lat=43.73", samples, http.StatusBadRequest, nil},
+		{"lon not a number", "width=12&lon=east&lat=43.73", samples, http.StatusBadRequest, nil},
+		{"lat not a number", "width=12&lon=7.42&lat=north", samples, http.StatusBadRequest, nil},
+		{"lat off the globe", "width=12&lon=7.42&lat=89", samples, http.StatusUnprocessableEntity, nil},
+		{"not samples", "width=12", []byte(`{"location": {}}`), http.StatusBadRequest, nil},
+		{"not a lap", "width=12", []byte(`[]`), http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := uuid.New()
+			repo := &tracksRepo{track: &Track{ID: id, Name: "ring", Geometry: &Geometry{Origin: placed}}}
+			s := &TelemetryService{repo: repo}
+
+			r := httptest.NewRequest(http.MethodPost, "/tracks/"+id.String()+"/survey?"+tt.query, bytes.NewReader(tt.body))
+			r.SetPathValue("id", id.String())
+			w := httptest.NewRecorder()
+			surveyTrack(s)(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				if repo.track.Geometry.Centreline != nil {
+					t.Error("track updated")
+				}
+				return
+			}
+			var got Track
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != "ring" || got.Geometry == nil || got.Geometry.Origin == nil || *got.Geometry.Origin != *tt.origin {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+/*
+private channels
+
+- every driver has a private topic, "drivers/{id}/private", for what only their side should see
+- the driver joins it, and so do the members of the teams they drive for and race control
+- everyone else is turned away before the upgrade
+- so is anyone not authenticated, a role or identity the client declares itself proves nothing
+*/
+
+var ErrNotTeammate = errors.New("only the driver, their teams and race control may join a private channel")
+
+func privateTopic(driverID uuid.UUID) string {
+	return "drivers/" + driverID.String() + "/private"
+}
+
+// privateDriver returns the driver whose private topic is topic, false if it isn't one
+func privateDriver(topic string) (uuid.UUID, bool) {
+	rest, ok := strings.CutPrefix(topic, "drivers/")
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, ok := strings.CutSuffix(rest, "/private")
+	if !ok {
+		return uuid.Nil, false
+	}
+	driverID, err := uuid.Parse(id)
+	if err != nil || topic != privateTopic(driverID) {
+		// only the canonical spelling, the hub tells topics apart by name
+		return uuid.Nil, false
+	}
+
+	return driverID, true
+}
+
+// drives tells whether the driver drives for t
+func (t *Team) drives(driverID uuid.UUID) bool {
+	for _, m := range t.Members {
+		if m.Role == TeamDriver && m.DriverID != nil && *m.DriverID == driverID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayJoin checks that id may join topic, ErrNotTeammate if it's a private topic id has no business in
+func (s *TelemetryService) mayJoin(ctx context.Context, id ws.Identity, topic string) error {
+	driverID, ok := privateDriver(topic)
+	if !ok {
+		return nil
+	}
+	if !id.Authenticated || id.ID == "" {
+		return ErrNotTeammate
+	}
+	if id.Role == ws.RoleRaceControl || id.Driver == driverID.String() {
+		return nil
+	}
+
+	teams, err := s.repo.ListTeams(ctx, &TeamFilter{Page: Page{Limit: 1}, Identity: id.ID, DriverID: driverID})
+	if err != nil {
+		return err
+	}
+	if len(teams) == 0 {
+		return ErrNotTeammate
+	}
+
+	return nil
+}
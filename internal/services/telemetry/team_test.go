@@ -0,0 +1,93 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	ws "github.com/pmoieni/project-racer-server/internal/net/websocket"
+)
+
+// teamsRepo knows the identities of one team
+type teamsRepo struct {
+	TelemetryRepo
+	members map[string]bool
+}
+
+func (r *teamsRepo) ListTeams(_ context.Context, f *TeamFilter) ([]*Team, error) {
+	if r.members[f.Identity] {
+		return []*Team{{}}, nil
+	}
+	return nil, nil
+}
+
+func TestMayJoin(t *testing.T) {
+	driver := uuid.New()
+	private := privateTopic(driver)
+	s := &TelemetryService{repo: &teamsRepo{members: map[string]bool{"engineer": true}}}
+
+	tests := []struct {
+		name  string
+		id    ws.Identity
+		topic string
+		want  error
+	}{
+		{"public topic", ws.Identity{Role: ws.RoleSpectator}, "events/1/state", nil},
+		{"spectator", ws.Identity{Role: ws.RoleSpectator}, private, ErrNotTeammate},
+		{"declared race control", ws.Identity{ID: "rc", Role: ws.RoleRaceControl}, private, ErrNotTeammate},
+		{"declared driver", ws.Identity{ID: "d", Role: ws.RoleDriver, Driver: driver.String()}, private, ErrNotTeammate},
+		{"declared teammate", ws.Identity{ID: "engineer", Role: ws.RoleSpectator}, private, ErrNotTeammate},
+		{"race control", ws.Identity{ID: "rc", Role: ws.RoleRaceControl, Authenticated: true}, private, nil},
+		{"driver", ws.Identity{ID: "d", Role: ws.RoleDriver, Driver: driver.String(), Authenticated: true}, private, nil},
+		{"another driver", ws.Identity{ID: "o", Role: ws.RoleDriver, Driver: uuid.NewString(), Authenticated: true}, private, ErrNotTeammate},
+		{"teammate", ws.Identity{ID: "engineer", Role: ws.RoleSpectator, Authenticated: true}, private, nil},
+		{"stranger", ws.Identity{ID: "fan", Role: ws.RoleSpectator, Authenticated: true}, private, ErrNotTeammate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.mayJoin(context.Background(), tt.id, tt.topic); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// private channels open to the tokens of the driver, their teammates and race control
+func TestHandleConnPrivate(t *testing.T) {
+	driver := uuid.New()
+	repo := &teamsRepo{
+		TelemetryRepo: &driversRepo{drivers: map[string]uuid.UUID{"d": driver}},
+		members:       map[string]bool{"engineer": true},
+	}
+	tokens := testTokens(t)
+	if err := tokens.Add("engineer", "engineer", ws.RoleSpectator); err != nil {
+		t.Fatal(err)
+	}
+	path := "/ws/" + privateTopic(driver)
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"anonymous", path, "", http.StatusForbidden},
+		{"declared teammate", path + "?identity=engineer", "", http.StatusForbidden},
+		{"spectator", path, "fan", http.StatusForbidden},
+		{"driver", path, "driver", http.StatusSwitchingProtocols},
+		{"teammate", path, "engineer", http.StatusSwitchingProtocols},
+		{"race control", path, "rc", http.StatusSwitchingProtocols},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := serve(t, repo, tokens)
+			if _, status := dial(t, srv, tt.path, tt.token); status != tt.want {
+				t.Errorf("got %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
@@ -7,83 +7,267 @@ import (
 	"github.com/google/uuid"
 )
 
+// Version counts the updates of an entity, a write made on a condition requires the version it was checked against.
+// The zero Version requires none.
+type Version int64
+
 type Track struct {
-	ID     uuid.UUID
-	Name   string
-	Layout string
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// Geometry is nil until the track is surveyed.
+	Geometry *Geometry `json:"geometry,omitempty"`
+	Version  Version   `json:"-"`
 }
 
 type TrackParams struct {
-	Name   string
-	Layout string
+	Name     string    `json:"name"`
+	Geometry *Geometry `json:"geometry"`
 }
 
 type Car struct {
-	ID    uuid.UUID
-	Name  string
-	Class *Class
+	ID      uuid.UUID `json:"id"`
+	Name    string    `json:"name"`
+	Class   *Class    `json:"class,omitempty"`
+	Version Version   `json:"-"`
 }
 
 type CarParams struct {
-	Name    string
-	ClassID uuid.UUID
+	Name    string    `json:"name"`
+	ClassID uuid.UUID `json:"class_id"`
 }
 
 type Class struct {
-	ID    uuid.UUID
-	Title string
-	Cars  []*Car
+	ID      uuid.UUID `json:"id"`
+	Title   string    `json:"title"`
+	Cars    []*Car    `json:"cars,omitempty"`
+	Version Version   `json:"-"`
 }
 
 type ClassParams struct {
-	Title string
-	Cars  []uuid.UUID
+	Title string      `json:"title"`
+	Cars  []uuid.UUID `json:"cars"`
 }
 
 type Event struct {
-	ID           uuid.UUID
-	Title        string
-	Classes      []*Class
-	State        string // ongoing, finished, etc.
-	Picture      string
-	Track        *Track
-	Laps         uint
-	StartsAt     time.Time
-	EndsAt       time.Time
-	IsHighReward bool
+	ID           uuid.UUID  `json:"id"`
+	Title        string     `json:"title"`
+	Classes      []*Class   `json:"classes"`
+	State        EventState `json:"state"`
+	Picture      string     `json:"picture"`
+	Track        *Track     `json:"track"`
+	Laps         uint       `json:"laps"`
+	StartsAt     time.Time  `json:"starts_at"`
+	EndsAt       time.Time  `json:"ends_at"`
+	IsHighReward bool       `json:"is_high_reward"`
+	// EntryLimits is how many entries each class takes, classes missing from it take any number.
+	EntryLimits map[uuid.UUID]uint `json:"entry_limits,omitempty"`
+	// The registration window, registration stays open for as long as race control keeps it so when there's none.
+	RegistrationOpensAt  *time.Time `json:"registration_opens_at,omitempty"`
+	RegistrationClosesAt *time.Time `json:"registration_closes_at,omitempty"`
+	Version              Version    `json:"-"`
 }
 
 type EventParams struct {
-	Title        string
-	Classes      []uuid.UUID
-	State        string
-	Picture      string
+	Title        string      `json:"title"`
+	Classes      []uuid.UUID `json:"classes"`
+	Picture      string      `json:"picture"`
+	TrackID      uuid.UUID   `json:"track_id"`
+	Laps         uint        `json:"laps"`
+	StartsAt     time.Time   `json:"starts_at"`
+	EndsAt       time.Time   `json:"ends_at"`
+	IsHighReward bool        `json:"is_high_reward"`
+	// EntryLimits must only name classes of Classes.
+	EntryLimits          map[uuid.UUID]uint `json:"entry_limits"`
+	RegistrationOpensAt  *time.Time         `json:"registration_opens_at"`
+	RegistrationClosesAt *time.Time         `json:"registration_closes_at"`
+}
+
+type Driver struct {
+	ID          uuid.UUID `json:"id"`
+	DisplayName string    `json:"display_name"`
+	// Country is an ISO 3166-1 alpha-2 code such as "IT".
+	Country string `json:"country"`
+	Number  uint   `json:"number"`
+	// Identity is the login identity of the driver's account, empty until one is linked.
+	Identity string  `json:"identity,omitempty"`
+	Version  Version `json:"-"`
+}
+
+type DriverParams struct {
+	DisplayName string `json:"display_name"`
+	Country     string `json:"country"`
+	Number      uint   `json:"number"`
+	Identity    string `json:"identity"`
+}
+
+type TeamRole string
+
+const (
+	TeamDriver   TeamRole = "driver"
+	TeamEngineer TeamRole = "engineer"
+	TeamManager  TeamRole = "manager"
+)
+
+type Team struct {
+	ID      uuid.UUID     `json:"id"`
+	Name    string        `json:"name"`
+	Members []*TeamMember `json:"members"`
+	Version Version       `json:"-"`
+}
+
+// TeamMember is a driver of a team, or someone working for it known by their login identity.
+type TeamMember struct {
+	Role     TeamRole   `json:"role"`
+	DriverID *uuid.UUID `json:"driver_id,omitempty"`
+	Identity string     `json:"identity,omitempty"`
+}
+
+type TeamParams struct {
+	Name    string        `json:"name"`
+	Members []*TeamMember `json:"members"`
+}
+
+// Entry is one or more drivers sharing a car in one of the classes of an event.
+type Entry struct {
+	ID      uuid.UUID `json:"id"`
+	EventID uuid.UUID `json:"event_id"`
+	// Class is what the entry races in, the car belonged to it when entered.
+	Class *Class `json:"class"`
+	Car   *Car   `json:"car"`
+	// Drivers are in the order they were entered, the first one starts.
+	Drivers []uuid.UUID `json:"drivers"`
+	// TeamID is the team owning the entry, nil for privateers.
+	TeamID    *uuid.UUID `json:"team_id,omitempty"`
+	EnteredAt time.Time  `json:"entered_at"`
+	// WithdrawnAt is set once the entry is withdrawn, it doesn't take a place in its class anymore.
+	WithdrawnAt *time.Time `json:"withdrawn_at,omitempty"`
+}
+
+type EntryParams struct {
+	ClassID uuid.UUID   `json:"class_id"`
+	CarID   uuid.UUID   `json:"car_id"`
+	Drivers []uuid.UUID `json:"drivers"`
+	// TeamID makes the entry the team's, its drivers must all drive for it.
+	TeamID *uuid.UUID `json:"team_id"`
+}
+
+// Page selects a page of a list. Lists are ordered by ID, which is creation order for UUIDv7s.
+type Page struct {
+	// After is the ID of the last item of the previous page, uuid.Nil for the first page.
+	After uuid.UUID
+	Limit int
+}
+
+type TrackFilter struct {
+	Page
+	// Name matches tracks whose name contains it, ignoring case.
+	Name string
+}
+
+type CarFilter struct {
+	Page
+	ClassID uuid.UUID
+}
+
+type ClassFilter struct {
+	Page
+}
+
+// EventFilter selects events, zero fields don't filter.
+type EventFilter struct {
+	Page
+	State        EventState
+	StartsAfter  time.Time
+	StartsBefore time.Time
+	EndsAfter    time.Time
+	EndsBefore   time.Time
 	TrackID      uuid.UUID
-	Laps         uint
-	StartsAt     time.Time
-	EndsAt       time.Time
-	IsHighReward bool
+	// ClassID selects the events the class races in.
+	ClassID      uuid.UUID
+	IsHighReward *bool
+	// RegistrationOpenAt selects the events with a registration window containing it.
+	RegistrationOpenAt time.Time
+	// RegistrationClosedAt selects the events with a registration window that closed by it.
+	RegistrationClosedAt time.Time
 }
 
+type DriverFilter struct {
+	Page
+	// Name matches drivers whose display name contains it, ignoring case.
+	Name    string
+	Country string
+}
+
+type TeamFilter struct {
+	Page
+	// Name matches teams whose name contains it, ignoring case.
+	Name string
+	// Identity selects the teams a login identity is a member of, as a driver or otherwise.
+	Identity string
+	// DriverID selects the teams the driver drives for.
+	DriverID uuid.UUID
+}
+
+// EntryFilter selects the entries of an event, withdrawn ones only if asked.
+type EntryFilter struct {
+	Page
+	EventID          uuid.UUID
+	ClassID          uuid.UUID
+	DriverID         uuid.UUID
+	TeamID           uuid.UUID
+	IncludeWithdrawn bool
+}
+
+// TelemetryRepo stores the entities of the service.
+// Updates and deletes take the version of the entity they require, ErrPreconditionFailed if it's at another one.
 type TelemetryRepo interface {
-	// TODO: CRUD for every type
 	GetTrack(context.Context, uuid.UUID) (*Track, error)
 	CreateTrack(context.Context, *TrackParams) (*Track, error)
-	UpdateTrack(context.Context, uuid.UUID, *TrackParams) (*Track, error)
-	DeleteTrack(context.Context, uuid.UUID) error
+	UpdateTrack(context.Context, uuid.UUID, *TrackParams, Version) (*Track, error)
+	DeleteTrack(context.Context, uuid.UUID, Version) error
+	ListTracks(context.Context, *TrackFilter) ([]*Track, error)
 
 	GetCar(context.Context, uuid.UUID) (*Car, error)
 	CreateCar(context.Context, *CarParams) (*Car, error)
-	UpdateCar(context.Context, uuid.UUID, *CarParams) (*Car, error)
-	DeleteCar(context.Context, uuid.UUID) error
+	UpdateCar(context.Context, uuid.UUID, *CarParams, Version) (*Car, error)
+	DeleteCar(context.Context, uuid.UUID, Version) error
+	ListCars(context.Context, *CarFilter) ([]*Car, error)
 
 	GetClass(context.Context, uuid.UUID) (*Class, error)
 	CreateClass(context.Context, *ClassParams) (*Class, error)
-	UpdateClass(context.Context, uuid.UUID, *ClassParams) (*Class, error)
-	DeleteClass(context.Context, uuid.UUID) error
+	UpdateClass(context.Context, uuid.UUID, *ClassParams, Version) (*Class, error)
+	DeleteClass(context.Context, uuid.UUID, Version) error
+	ListClasses(context.Context, *ClassFilter) ([]*Class, error)
 
 	GetEvent(context.Context, uuid.UUID) (*Event, error)
 	CreateEvent(context.Context, *EventParams) (*Event, error)
-	UpdateEvent(context.Context, uuid.UUID, *EventParams) (*Event, error)
-	DeleteEvent(context.Context, uuid.UUID) error
+	// UpdateEvent edits an event, ErrConflict once it went live or if a class has more entries than its new limit.
+	UpdateEvent(context.Context, uuid.UUID, *EventParams, Version) (*Event, error)
+	DeleteEvent(context.Context, uuid.UUID, Version) error
+	ListEvents(context.Context, *EventFilter) ([]*Event, error)
+	// SetEventState moves an event from one state to another, ErrConflict if it isn't in from anymore.
+	SetEventState(ctx context.Context, id uuid.UUID, from, to EventState) (*Event, error)
+
+	GetDriver(context.Context, uuid.UUID) (*Driver, error)
+	// GetDriverByIdentity returns the driver linked to a login identity.
+	GetDriverByIdentity(context.Context, string) (*Driver, error)
+	CreateDriver(context.Context, *DriverParams) (*Driver, error)
+	UpdateDriver(context.Context, uuid.UUID, *DriverParams, Version) (*Driver, error)
+	DeleteDriver(context.Context, uuid.UUID, Version) error
+	ListDrivers(context.Context, *DriverFilter) ([]*Driver, error)
+
+	GetTeam(context.Context, uuid.UUID) (*Team, error)
+	CreateTeam(context.Context, *TeamParams) (*Team, error)
+	UpdateTeam(context.Context, uuid.UUID, *TeamParams, Version) (*Team, error)
+	DeleteTeam(context.Context, uuid.UUID, Version) error
+	ListTeams(context.Context, *TeamFilter) ([]*Team, error)
+
+	GetEntry(context.Context, uuid.UUID) (*Entry, error)
+	// CreateEntry enters p in an event if check accepts the entrant, ErrConflict if its class is full or one of the
+	// drivers is already entered.
+	// The entrant is read in the transaction making the entry and stays locked until it's made.
+	CreateEntry(ctx context.Context, eventID uuid.UUID, p *EntryParams, check func(*Entrant) error) (*Entry, error)
+	// WithdrawEntry withdraws an entry, ErrConflict if it already is.
+	WithdrawEntry(context.Context, uuid.UUID) (*Entry, error)
+	ListEntries(context.Context, *EntryFilter) ([]*Entry, error)
 }
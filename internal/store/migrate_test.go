@@ -0,0 +1,99 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// the embedded migrations are complete, numbered one after the other from 1
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d_%s follows version %d", m.Version, m.Name, i)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %d_%s has an empty file", m.Version, m.Name)
+		}
+	}
+	// the versions the store's writes check are in the schema
+	if len(migrations) < 8 || migrations[7].Name != "versions" {
+		t.Error("no migration 0008_versions")
+	}
+}
+
+func TestReadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		// the versions read in order, or the error
+		want []int
+		err  string
+	}{
+		{
+			"ordered by version",
+			fstest.MapFS{
+				"migrations/0010_b.up.sql":   file("up"),
+				"migrations/0010_b.down.sql": file("down"),
+				"migrations/0002_a.up.sql":   file("up"),
+				"migrations/0002_a.down.sql": file("down"),
+			},
+			[]int{2, 10}, "",
+		},
+		{
+			"missing down",
+			fstest.MapFS{"migrations/0001_a.up.sql": file("up")},
+			nil, "needs both an up and a down file",
+		},
+		{
+			"misnamed",
+			fstest.MapFS{"migrations/first.sql": file("up")},
+			nil, "isn't named",
+		},
+		{
+			"two names for a version",
+			fstest.MapFS{
+				"migrations/0001_a.up.sql":   file("up"),
+				"migrations/0001_b.down.sql": file("down"),
+			},
+			nil, "named both",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := readMigrations(tt.files)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want an error saying %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int
+			for _, m := range migrations {
+				got = append(got, m.Version)
+				if m.Up != "up" || m.Down != "down" {
+					t.Errorf("migration %d has up %q and down %q", m.Version, m.Up, m.Down)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got versions %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
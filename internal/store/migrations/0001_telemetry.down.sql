@@ -0,0 +1,5 @@
+DROP TABLE event_classes;
+DROP TABLE events;
+DROP TABLE cars;
+DROP TABLE classes;
+DROP TABLE tracks;
@@ -0,0 +1,41 @@
+CREATE TABLE tracks (
+    id     uuid PRIMARY KEY,
+    name   text NOT NULL UNIQUE,
+    layout text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE classes (
+    id    uuid PRIMARY KEY,
+    title text NOT NULL UNIQUE
+);
+
+CREATE TABLE cars (
+    id       uuid PRIMARY KEY,
+    name     text NOT NULL UNIQUE,
+    class_id uuid NOT NULL REFERENCES classes (id)
+);
+
+CREATE INDEX cars_class_id_idx ON cars (class_id);
+
+CREATE TABLE events (
+    id             uuid PRIMARY KEY,
+    title          text NOT NULL,
+    state          text NOT NULL DEFAULT '',
+    picture        text NOT NULL DEFAULT '',
+    track_id       uuid NOT NULL REFERENCES tracks (id),
+    laps           integer NOT NULL DEFAULT 0 CHECK (laps >= 0),
+    starts_at      timestamptz NOT NULL,
+    ends_at        timestamptz NOT NULL,
+    is_high_reward boolean NOT NULL DEFAULT false,
+    CHECK (ends_at >= starts_at)
+);
+
+CREATE INDEX events_track_id_idx ON events (track_id);
+
+CREATE TABLE event_classes (
+    event_id uuid NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    class_id uuid NOT NULL REFERENCES classes (id),
+    PRIMARY KEY (event_id, class_id)
+);
+
+CREATE INDEX event_classes_class_id_idx ON event_classes (class_id);
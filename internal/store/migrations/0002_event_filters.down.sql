@@ -0,0 +1,2 @@
+DROP INDEX events_starts_at_idx;
+DROP INDEX events_state_idx;
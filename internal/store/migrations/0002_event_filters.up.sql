@@ -0,0 +1,2 @@
+CREATE INDEX events_state_idx ON events (state);
+CREATE INDEX events_starts_at_idx ON events (starts_at);
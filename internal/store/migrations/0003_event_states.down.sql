@@ -0,0 +1,3 @@
+ALTER TABLE events
+    DROP CONSTRAINT events_state_check,
+    ALTER COLUMN state SET DEFAULT '';
@@ -0,0 +1,8 @@
+-- states were free-form until now, anything unknown starts over as a draft
+UPDATE events SET state = 'draft'
+WHERE state NOT IN ('draft', 'scheduled', 'registration_open', 'live', 'finished', 'cancelled');
+
+ALTER TABLE events
+    ALTER COLUMN state SET DEFAULT 'draft',
+    ADD CONSTRAINT events_state_check
+        CHECK (state IN ('draft', 'scheduled', 'registration_open', 'live', 'finished', 'cancelled'));
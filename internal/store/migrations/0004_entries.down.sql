@@ -0,0 +1,9 @@
+DROP TABLE entries;
+
+ALTER TABLE event_classes
+    DROP COLUMN max_entries;
+
+ALTER TABLE events
+    DROP CONSTRAINT events_registration_check,
+    DROP COLUMN registration_closes_at,
+    DROP COLUMN registration_opens_at;
@@ -0,0 +1,26 @@
+ALTER TABLE events
+    ADD COLUMN registration_opens_at timestamptz,
+    ADD COLUMN registration_closes_at timestamptz,
+    ADD CONSTRAINT events_registration_check
+        CHECK (registration_closes_at >= registration_opens_at AND registration_closes_at <= starts_at);
+
+ALTER TABLE event_classes
+    ADD COLUMN max_entries integer CHECK (max_entries > 0);
+
+CREATE TABLE entries (
+    id           uuid PRIMARY KEY,
+    event_id     uuid NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    class_id     uuid NOT NULL,
+    car_id       uuid NOT NULL REFERENCES cars (id),
+    driver_id    uuid NOT NULL,
+    entered_at   timestamptz NOT NULL DEFAULT now(),
+    withdrawn_at timestamptz,
+    -- a class can't be taken off an event while it has entries, even withdrawn ones
+    FOREIGN KEY (event_id, class_id) REFERENCES event_classes (event_id, class_id)
+);
+
+-- a driver enters an event once, again only after withdrawing
+CREATE UNIQUE INDEX entries_event_id_driver_id_idx ON entries (event_id, driver_id) WHERE withdrawn_at IS NULL;
+CREATE INDEX entries_event_id_class_id_idx ON entries (event_id, class_id);
+CREATE INDEX entries_car_id_idx ON entries (car_id);
+CREATE INDEX entries_driver_id_idx ON entries (driver_id);
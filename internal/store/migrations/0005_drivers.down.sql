@@ -0,0 +1,4 @@
+ALTER TABLE entries
+    DROP CONSTRAINT entries_driver_id_fkey;
+
+DROP TABLE drivers;
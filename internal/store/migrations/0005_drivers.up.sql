@@ -0,0 +1,13 @@
+CREATE TABLE drivers (
+    id           uuid PRIMARY KEY,
+    display_name text NOT NULL,
+    country      text NOT NULL CHECK (country ~ '^[A-Z]{2}$'),
+    number       integer NOT NULL CHECK (number BETWEEN 0 AND 999),
+    -- the login identity of the driver's account, NULL until one is linked
+    identity     text UNIQUE
+);
+
+CREATE INDEX drivers_country_idx ON drivers (country);
+
+ALTER TABLE entries
+    ADD CONSTRAINT entries_driver_id_fkey FOREIGN KEY (driver_id) REFERENCES drivers (id);
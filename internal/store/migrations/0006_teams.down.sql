@@ -0,0 +1,25 @@
+-- entries keep their first driver only
+ALTER TABLE entries
+    ADD COLUMN driver_id uuid;
+
+UPDATE entries en SET driver_id = ed.driver_id
+FROM entry_drivers ed
+WHERE ed.entry_id = en.id AND ed.position = (SELECT min(position) FROM entry_drivers WHERE entry_id = en.id);
+
+DELETE FROM entries WHERE driver_id IS NULL;
+
+ALTER TABLE entries
+    ALTER COLUMN driver_id SET NOT NULL,
+    ADD CONSTRAINT entries_driver_id_fkey FOREIGN KEY (driver_id) REFERENCES drivers (id);
+
+CREATE UNIQUE INDEX entries_event_id_driver_id_idx ON entries (event_id, driver_id) WHERE withdrawn_at IS NULL;
+CREATE INDEX entries_driver_id_idx ON entries (driver_id);
+
+DROP TABLE entry_drivers;
+
+ALTER TABLE entries
+    DROP CONSTRAINT entries_id_event_id_key,
+    DROP COLUMN team_id;
+
+DROP TABLE team_members;
+DROP TABLE teams;
@@ -0,0 +1,47 @@
+CREATE TABLE teams (
+    id   uuid PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+-- drivers are members by driver, everyone else by login identity
+CREATE TABLE team_members (
+    team_id   uuid NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    role      text NOT NULL CHECK (role IN ('driver', 'engineer', 'manager')),
+    driver_id uuid REFERENCES drivers (id),
+    identity  text,
+    CHECK ((role = 'driver') = (driver_id IS NOT NULL)),
+    CHECK ((role = 'driver') = (identity IS NULL)),
+    UNIQUE (team_id, driver_id),
+    UNIQUE (team_id, identity)
+);
+
+CREATE INDEX team_members_driver_id_idx ON team_members (driver_id);
+CREATE INDEX team_members_identity_idx ON team_members (identity);
+
+ALTER TABLE entries
+    ADD COLUMN team_id uuid REFERENCES teams (id),
+    ADD UNIQUE (id, event_id);
+
+CREATE INDEX entries_team_id_idx ON entries (team_id);
+
+-- the drivers sharing the car of an entry, event_id and withdrawn_at follow the entry's
+CREATE TABLE entry_drivers (
+    entry_id     uuid NOT NULL,
+    event_id     uuid NOT NULL,
+    driver_id    uuid NOT NULL REFERENCES drivers (id),
+    position     integer NOT NULL,
+    withdrawn_at timestamptz,
+    PRIMARY KEY (entry_id, driver_id),
+    UNIQUE (entry_id, position),
+    FOREIGN KEY (entry_id, event_id) REFERENCES entries (id, event_id) ON DELETE CASCADE
+);
+
+-- a driver is in one entry of an event, again only after withdrawing
+CREATE UNIQUE INDEX entry_drivers_event_id_driver_id_idx ON entry_drivers (event_id, driver_id) WHERE withdrawn_at IS NULL;
+CREATE INDEX entry_drivers_driver_id_idx ON entry_drivers (driver_id);
+
+INSERT INTO entry_drivers (entry_id, event_id, driver_id, position, withdrawn_at)
+SELECT id, event_id, driver_id, 0, withdrawn_at FROM entries;
+
+ALTER TABLE entries
+    DROP COLUMN driver_id;
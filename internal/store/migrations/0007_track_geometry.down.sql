@@ -0,0 +1,10 @@
+-- the centreline is what runtimes read from layouts, without its closing point
+UPDATE tracks SET layout = (
+    SELECT jsonb_agg(p ORDER BY i)::text
+    FROM jsonb_array_elements(geometry -> 'centreline') WITH ORDINALITY AS c (p, i)
+    WHERE i < jsonb_array_length(geometry -> 'centreline')
+)
+WHERE geometry IS NOT NULL;
+
+ALTER TABLE tracks
+    DROP COLUMN geometry;
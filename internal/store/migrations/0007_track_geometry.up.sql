@@ -0,0 +1,83 @@
+-- layouts become geometries, tracks keep their positions without being surveyed again:
+-- - a layout is a JSON array of {"x", "y"} points whose last joins its first, the centreline repeats the first to close it
+-- - a point repeating the one before is left out, a centreline can't have them
+-- - tracks are 12 m wide, the narrowest a circuit is built, until race control edits them
+-- - the start/finish line goes across the middle of the first segment, layouts started the lap at its first point
+-- - anything else, e.g. an empty layout or fewer than 3 points, leaves the track without a geometry
+-- layout stays until every track has been checked, a later version drops it
+ALTER TABLE tracks
+    ADD COLUMN geometry jsonb;
+
+CREATE FUNCTION pg_temp.layout_geometry(layout text) RETURNS jsonb
+LANGUAGE plpgsql AS $$
+DECLARE
+    width CONSTANT float8 := 12;
+    points jsonb := '[]';
+    p jsonb;
+    x float8;
+    y float8;
+    px float8;
+    py float8;
+    total float8 := 0;
+    dx float8;
+    dy float8;
+    d float8;
+BEGIN
+    BEGIN
+        FOR p IN SELECT value FROM jsonb_array_elements(layout::jsonb) LOOP
+            x := (p ->> 'x')::float8;
+            y := (p ->> 'y')::float8;
+            IF x IS NULL OR y IS NULL THEN
+                RETURN NULL;
+            END IF;
+            IF jsonb_array_length(points) > 0 AND x = px AND y = py THEN
+                CONTINUE;
+            END IF;
+            IF jsonb_array_length(points) > 0 THEN
+                total := total + sqrt((x - px) ^ 2 + (y - py) ^ 2);
+            END IF;
+            points := points || jsonb_build_array(jsonb_build_object('x', x, 'y', y));
+            px := x;
+            py := y;
+        END LOOP;
+    EXCEPTION WHEN others THEN
+        -- not JSON, not an array or not numbers
+        RETURN NULL;
+    END;
+
+    -- the last point joining the first isn't a segment of its own
+    IF jsonb_array_length(points) > 1 AND points -> 0 = points -> -1 THEN
+        points := points - (-1);
+    END IF;
+    IF jsonb_array_length(points) < 3 THEN
+        RETURN NULL;
+    END IF;
+    x := (points -> 0 ->> 'x')::float8;
+    y := (points -> 0 ->> 'y')::float8;
+    total := total + sqrt((x - px) ^ 2 + (y - py) ^ 2);
+
+    -- across the middle of the first segment, as wide as the track
+    dx := (points -> 1 ->> 'x')::float8 - x;
+    dy := (points -> 1 ->> 'y')::float8 - y;
+    d := sqrt(dx ^ 2 + dy ^ 2);
+    px := x + dx / 2;
+    py := y + dy / 2;
+    dx := dx / d * width / 2;
+    dy := dy / d * width / 2;
+
+    RETURN jsonb_build_object(
+        'centreline', points || jsonb_build_array(points -> 0),
+        'width', width,
+        'start_finish', jsonb_build_object(
+            'a', jsonb_build_object('x', px - dy, 'y', py + dx),
+            'b', jsonb_build_object('x', px + dy, 'y', py - dx),
+            'distance', 0
+        ),
+        'sectors', '[]'::jsonb,
+        'length', total
+    );
+END;
+$$;
+
+UPDATE tracks SET geometry = pg_temp.layout_geometry(layout);
+DROP FUNCTION pg_temp.layout_geometry(text);
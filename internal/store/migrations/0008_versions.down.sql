@@ -0,0 +1,6 @@
+ALTER TABLE teams DROP COLUMN version;
+ALTER TABLE drivers DROP COLUMN version;
+ALTER TABLE events DROP COLUMN version;
+ALTER TABLE classes DROP COLUMN version;
+ALTER TABLE cars DROP COLUMN version;
+ALTER TABLE tracks DROP COLUMN version;
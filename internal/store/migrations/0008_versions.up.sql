@@ -0,0 +1,7 @@
+-- every update bumps the version of the row, a write made on a condition requires the version it was checked against
+ALTER TABLE tracks ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE cars ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE classes ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE events ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE drivers ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE teams ADD COLUMN version bigint NOT NULL DEFAULT 1;
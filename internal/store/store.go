@@ -14,6 +14,8 @@ import (
 var (
 	ErrConflict = errors.New("store: entity already exists")
 	ErrNotFound = errors.New("store: entity not found")
+	// ErrPreconditionFailed is returned by writes that required a version of an entity it isn't at anymore.
+	ErrPreconditionFailed = errors.New("store: entity was modified")
 )
 
 type StoreErr struct {
@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+)
+
+// the class of a car is loaded without its cars, they would point back at it
+const carColumns = `c.id, c.name, c.class_id, cl.title AS class_title, c.version`
+
+func (d *carDTO) toCar() *telemetry.Car {
+	return &telemetry.Car{
+		ID:      d.ID,
+		Name:    d.Name,
+		Class:   &telemetry.Class{ID: d.ClassID, Title: d.ClassTitle},
+		Version: d.Version,
+	}
+}
+
+func (r *TelemetryRepo) GetCar(ctx context.Context, id uuid.UUID) (*telemetry.Car, error) {
+	return getCar(ctx, r.db, id)
+}
+
+func getCar(ctx context.Context, q sqlx.QueryerContext, id uuid.UUID) (*telemetry.Car, error) {
+	var dto carDTO
+	if err := sqlx.GetContext(ctx, q, &dto, `
+		SELECT `+carColumns+`
+		FROM cars c JOIN classes cl ON cl.id = c.class_id
+		WHERE c.id = $1`,
+		id,
+	); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toCar(), nil
+}
+
+func (r *TelemetryRepo) CreateCar(ctx context.Context, p *telemetry.CarParams) (*telemetry.Car, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var dto carDTO
+	if err := r.db.GetContext(ctx, &dto, `
+		WITH c AS (
+			INSERT INTO cars (id, name, class_id) VALUES ($1, $2, $3)
+			RETURNING id, name, class_id, version
+		)
+		SELECT `+carColumns+` FROM c JOIN classes cl ON cl.id = c.class_id`,
+		id, p.Name, p.ClassID,
+	); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toCar(), nil
+}
+
+func (r *TelemetryRepo) UpdateCar(
+	ctx context.Context, id uuid.UUID, p *telemetry.CarParams, version telemetry.Version,
+) (*telemetry.Car, error) {
+	var dto carDTO
+	if err := r.db.GetContext(ctx, &dto, `
+		WITH c AS (
+			UPDATE cars SET name = $2, class_id = $3, version = version + 1
+			WHERE id = $1 AND `+atVersion(4)+`
+			RETURNING id, name, class_id, version
+		)
+		SELECT `+carColumns+` FROM c JOIN classes cl ON cl.id = c.class_id`,
+		id, p.Name, p.ClassID, version,
+	); errors.Is(err, sql.ErrNoRows) {
+		return nil, notWritten(ctx, r.db, "cars", id)
+	} else if err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toCar(), nil
+}
+
+func (r *TelemetryRepo) DeleteCar(ctx context.Context, id uuid.UUID, version telemetry.Version) error {
+	return deleteByID(ctx, r.db, "cars", id, version)
+}
+
+func (r *TelemetryRepo) ListCars(ctx context.Context, f *telemetry.CarFilter) ([]*telemetry.Car, error) {
+	var c conditions
+	if f.ClassID != uuid.Nil {
+		c.add("c.class_id = ?", f.ClassID)
+	}
+	q, args := c.page(`SELECT `+carColumns+` FROM cars c JOIN classes cl ON cl.id = c.class_id`, "c", f.Page)
+
+	var dtos []carDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	cars := make([]*telemetry.Car, len(dtos))
+	for i := range dtos {
+		cars[i] = dtos[i].toCar()
+	}
+
+	return cars, nil
+}
@@ -0,0 +1,196 @@
+package telemetry
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+const classQuery = `
+	SELECT cl.id, cl.title,
+		COALESCE((SELECT array_agg(c.id ORDER BY c.id)::text FROM cars c WHERE c.class_id = cl.id), '{}') AS cars,
+		cl.version
+	FROM classes cl`
+
+func (r *TelemetryRepo) GetClass(ctx context.Context, id uuid.UUID) (*telemetry.Class, error) {
+	return getClass(ctx, r.db, id)
+}
+
+func getClass(ctx context.Context, q sqlx.QueryerContext, id uuid.UUID) (*telemetry.Class, error) {
+	var dto classDTO
+	if err := sqlx.GetContext(ctx, q, &dto, classQuery+` WHERE cl.id = $1`, id); err != nil {
+		return nil, mapErr(err)
+	}
+
+	classes, err := loadCars(ctx, q, []classDTO{dto})
+	if err != nil {
+		return nil, err
+	}
+
+	return classes[0], nil
+}
+
+// getClasses loads the classes with ids along with their cars, in the order of ids
+func getClasses(ctx context.Context, q sqlx.QueryerContext, ids uuidArray) ([]*telemetry.Class, error) {
+	var dtos []classDTO
+	if err := sqlx.SelectContext(ctx, q, &dtos, classQuery+` WHERE cl.id = ANY($1::text::uuid[])`, ids); err != nil {
+		return nil, mapErr(err)
+	}
+	slices.SortFunc(dtos, func(a, b classDTO) int {
+		return slices.Index(ids, a.ID) - slices.Index(ids, b.ID)
+	})
+
+	return loadCars(ctx, q, dtos)
+}
+
+// loadCars fetches the cars of every class in one query
+func loadCars(ctx context.Context, q sqlx.QueryerContext, dtos []classDTO) ([]*telemetry.Class, error) {
+	var ids uuidArray
+	for _, dto := range dtos {
+		ids = append(ids, dto.Cars...)
+	}
+
+	var cars []carDTO
+	if len(ids) > 0 {
+		if err := sqlx.SelectContext(ctx, q, &cars, `
+			SELECT `+carColumns+`
+			FROM cars c JOIN classes cl ON cl.id = c.class_id
+			WHERE c.id = ANY($1::text::uuid[])
+			ORDER BY c.id`,
+			ids,
+		); err != nil {
+			return nil, mapErr(err)
+		}
+	}
+
+	classes := make([]*telemetry.Class, len(dtos))
+	byID := make(map[uuid.UUID]*telemetry.Class, len(dtos))
+	for i, dto := range dtos {
+		classes[i] = &telemetry.Class{
+			ID:      dto.ID,
+			Title:   dto.Title,
+			Cars:    make([]*telemetry.Car, 0, len(dto.Cars)),
+			Version: dto.Version,
+		}
+		byID[dto.ID] = classes[i]
+	}
+	for i := range cars {
+		if c, ok := byID[cars[i].ClassID]; ok {
+			c.Cars = append(c.Cars, cars[i].toCar())
+		}
+	}
+
+	return classes, nil
+}
+
+func (r *TelemetryRepo) ListClasses(ctx context.Context, f *telemetry.ClassFilter) ([]*telemetry.Class, error) {
+	var c conditions
+	q, args := c.page(classQuery, "cl", f.Page)
+
+	var dtos []classDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return loadCars(ctx, r.db, dtos)
+}
+
+// CreateClass creates a class and moves the cars in p to it.
+func (r *TelemetryRepo) CreateClass(ctx context.Context, p *telemetry.ClassParams) (*telemetry.Class, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var class *telemetry.Class
+	err = r.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO classes (id, title) VALUES ($1, $2)`, id, p.Title); err != nil {
+			return mapErr(err)
+		}
+		if err := moveCars(ctx, tx, id, p.Cars); err != nil {
+			return err
+		}
+
+		class, err = getClass(ctx, tx, id)
+		return err
+	})
+
+	return class, err
+}
+
+// UpdateClass updates a class and moves the cars in p to it.
+// A car always has a class, the ones not in p stay where they are.
+func (r *TelemetryRepo) UpdateClass(
+	ctx context.Context, id uuid.UUID, p *telemetry.ClassParams, version telemetry.Version,
+) (*telemetry.Class, error) {
+	var class *telemetry.Class
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE classes SET title = $2, version = version + 1 WHERE id = $1 AND `+atVersion(3),
+			id, p.Title, version,
+		)
+		if err != nil {
+			return mapErr(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return notWritten(ctx, tx, "classes", id)
+		}
+
+		if err := moveCars(ctx, tx, id, p.Cars); err != nil {
+			return err
+		}
+
+		class, err = getClass(ctx, tx, id)
+		return err
+	})
+
+	return class, err
+}
+
+// moveCars puts cars in the class, ErrNotFound if one of them doesn't exist
+func moveCars(ctx context.Context, tx *sqlx.Tx, classID uuid.UUID, cars []uuid.UUID) error {
+	ids := uuidArray(unique(cars))
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// a car that moves is updated, one already in the class isn't
+	res, err := tx.ExecContext(ctx, `
+		UPDATE cars SET class_id = $1, version = CASE WHEN class_id = $1 THEN version ELSE version + 1 END
+		WHERE id = ANY($2::text::uuid[])`,
+		classID, ids,
+	)
+	if err != nil {
+		return mapErr(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(ids)) {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *TelemetryRepo) DeleteClass(ctx context.Context, id uuid.UUID, version telemetry.Version) error {
+	return deleteByID(ctx, r.db, "classes", id, version)
+}
+
+func unique(ids []uuid.UUID) []uuid.UUID {
+	res := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !slices.Contains(res, id) {
+			res = append(res, id)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,106 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+)
+
+const driverColumns = `id, display_name, country, number, identity, version`
+
+func (d *driverDTO) toDriver() *telemetry.Driver {
+	return &telemetry.Driver{
+		ID:          d.ID,
+		DisplayName: d.DisplayName,
+		Country:     d.Country,
+		Number:      d.Number,
+		Identity:    d.Identity.String,
+		Version:     d.Version,
+	}
+}
+
+func (r *TelemetryRepo) GetDriver(ctx context.Context, id uuid.UUID) (*telemetry.Driver, error) {
+	var dto driverDTO
+	if err := r.db.GetContext(ctx, &dto, `SELECT `+driverColumns+` FROM drivers WHERE id = $1`, id); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toDriver(), nil
+}
+
+func (r *TelemetryRepo) GetDriverByIdentity(ctx context.Context, identity string) (*telemetry.Driver, error) {
+	var dto driverDTO
+	if err := r.db.GetContext(ctx, &dto, `SELECT `+driverColumns+` FROM drivers WHERE identity = $1`, identity); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toDriver(), nil
+}
+
+// CreateDriver creates a driver, ErrConflict if its identity is linked to another one.
+func (r *TelemetryRepo) CreateDriver(ctx context.Context, p *telemetry.DriverParams) (*telemetry.Driver, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var dto driverDTO
+	if err := r.db.GetContext(ctx, &dto, `
+		INSERT INTO drivers (id, display_name, country, number, identity) VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		RETURNING `+driverColumns,
+		id, p.DisplayName, p.Country, p.Number, p.Identity,
+	); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toDriver(), nil
+}
+
+func (r *TelemetryRepo) UpdateDriver(
+	ctx context.Context, id uuid.UUID, p *telemetry.DriverParams, version telemetry.Version,
+) (*telemetry.Driver, error) {
+	var dto driverDTO
+	if err := r.db.GetContext(ctx, &dto, `
+		UPDATE drivers SET display_name = $2, country = $3, number = $4, identity = NULLIF($5, ''), version = version + 1
+		WHERE id = $1 AND `+atVersion(6)+`
+		RETURNING `+driverColumns,
+		id, p.DisplayName, p.Country, p.Number, p.Identity, version,
+	); errors.Is(err, sql.ErrNoRows) {
+		return nil, notWritten(ctx, r.db, "drivers", id)
+	} else if err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toDriver(), nil
+}
+
+// DeleteDriver deletes a driver, ErrConflict if they have entries.
+func (r *TelemetryRepo) DeleteDriver(ctx context.Context, id uuid.UUID, version telemetry.Version) error {
+	return deleteByID(ctx, r.db, "drivers", id, version)
+}
+
+func (r *TelemetryRepo) ListDrivers(ctx context.Context, f *telemetry.DriverFilter) ([]*telemetry.Driver, error) {
+	var c conditions
+	if f.Name != "" {
+		c.add("d.display_name ILIKE ?", contains(f.Name))
+	}
+	if f.Country != "" {
+		c.add("d.country = ?", f.Country)
+	}
+	q, args := c.page(`SELECT d.id, d.display_name, d.country, d.number, d.identity FROM drivers d`, "d", f.Page)
+
+	var dtos []driverDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	drivers := make([]*telemetry.Driver, len(dtos))
+	for i := range dtos {
+		drivers[i] = dtos[i].toDriver()
+	}
+
+	return drivers, nil
+}
@@ -0,0 +1,224 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+const entryQuery = `
+	SELECT en.id, en.event_id, en.class_id, en.car_id, cl.title AS class_title, c.name AS car_name,
+		COALESCE((SELECT array_agg(ed.driver_id ORDER BY ed.position)::text FROM entry_drivers ed WHERE ed.entry_id = en.id), '{}') AS drivers,
+		en.team_id, en.entered_at, en.withdrawn_at
+	FROM entries en
+	JOIN classes cl ON cl.id = en.class_id
+	JOIN cars c ON c.id = en.car_id`
+
+func (d *entryDTO) toEntry() *telemetry.Entry {
+	return &telemetry.Entry{
+		ID:          d.ID,
+		EventID:     d.EventID,
+		Class:       &telemetry.Class{ID: d.ClassID, Title: d.ClassTitle},
+		Car:         &telemetry.Car{ID: d.CarID, Name: d.CarName},
+		Drivers:     d.Drivers,
+		TeamID:      d.TeamID,
+		EnteredAt:   d.EnteredAt,
+		WithdrawnAt: d.WithdrawnAt,
+	}
+}
+
+func (r *TelemetryRepo) GetEntry(ctx context.Context, id uuid.UUID) (*telemetry.Entry, error) {
+	return getEntry(ctx, r.db, id)
+}
+
+func getEntry(ctx context.Context, q sqlx.QueryerContext, id uuid.UUID) (*telemetry.Entry, error) {
+	var dto entryDTO
+	if err := sqlx.GetContext(ctx, q, &dto, entryQuery+` WHERE en.id = $1`, id); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toEntry(), nil
+}
+
+// CreateEntry enters p in the event once check accepts the entrant.
+// The event, the car, the team and the drivers are locked while they're checked, the event can't close registration
+// nor the team drop a driver until the entry is made. The class is locked while its entries are counted, concurrent
+// entries can't both take its last place.
+func (r *TelemetryRepo) CreateEntry(
+	ctx context.Context, eventID uuid.UUID, p *telemetry.EntryParams, check func(*telemetry.Entrant) error,
+) (*telemetry.Entry, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var entry *telemetry.Entry
+	err = r.inTx(ctx, func(tx *sqlx.Tx) error {
+		e, err := lockEntrant(ctx, tx, eventID, p)
+		if err != nil {
+			return err
+		}
+		if err := check(e); err != nil {
+			return err
+		}
+
+		var limit sql.NullInt64
+		if err := tx.GetContext(ctx, &limit, `
+			SELECT max_entries FROM event_classes WHERE event_id = $1 AND class_id = $2 FOR UPDATE`,
+			eventID, p.ClassID,
+		); err != nil {
+			return mapErr(err)
+		}
+		if limit.Valid {
+			var n int64
+			if err := tx.GetContext(ctx, &n, `
+				SELECT count(*) FROM entries WHERE event_id = $1 AND class_id = $2 AND withdrawn_at IS NULL`,
+				eventID, p.ClassID,
+			); err != nil {
+				return err
+			}
+			if n >= limit.Int64 {
+				return fmt.Errorf("%w: class is full", store.ErrConflict)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO entries (id, event_id, class_id, car_id, team_id) VALUES ($1, $2, $3, $4, $5)`,
+			id, eventID, p.ClassID, p.CarID, p.TeamID,
+		); err != nil {
+			return mapErr(err)
+		}
+		// the unique index on the drivers of the event's entries refuses one entered twice
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO entry_drivers (entry_id, event_id, driver_id, position)
+			SELECT $1, $2, d.id, d.position - 1
+			FROM unnest($3::text::uuid[]) WITH ORDINALITY AS d (id, position)`,
+			id, eventID, uuidArray(p.Drivers),
+		); err != nil {
+			return mapErr(err)
+		}
+
+		entry, err = getEntry(ctx, tx, id)
+		return err
+	})
+
+	return entry, err
+}
+
+// lockEntrant reads what p is entered with, locked until tx ends
+func lockEntrant(ctx context.Context, tx *sqlx.Tx, eventID uuid.UUID, p *telemetry.EntryParams) (*telemetry.Entrant, error) {
+	var e telemetry.Entrant
+	if ok, err := lock(ctx, tx, "events", eventID); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, store.ErrNotFound
+	}
+	event, err := getEvent(ctx, tx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	e.Event = event
+
+	if ok, err := lock(ctx, tx, "cars", p.CarID); err != nil {
+		return nil, err
+	} else if ok {
+		if e.Car, err = getCar(ctx, tx, p.CarID); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.TeamID != nil {
+		if ok, err := lock(ctx, tx, "teams", *p.TeamID); err != nil {
+			return nil, err
+		} else if ok {
+			if e.Team, err = getTeam(ctx, tx, *p.TeamID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.SelectContext(ctx, &e.Drivers, `
+		SELECT id FROM drivers WHERE id = ANY($1::text::uuid[]) FOR SHARE`,
+		uuidArray(p.Drivers),
+	); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// lock takes a share lock on the row id of table, false if there's no such row.
+// table is one of ours, never a client's.
+func lock(ctx context.Context, tx *sqlx.Tx, table string, id uuid.UUID) (bool, error) {
+	var found bool
+	err := tx.GetContext(ctx, &found, `SELECT true FROM `+table+` WHERE id = $1 FOR SHARE`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *TelemetryRepo) WithdrawEntry(ctx context.Context, id uuid.UUID) (*telemetry.Entry, error) {
+	var entry *telemetry.Entry
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE entries SET withdrawn_at = now() WHERE id = $1 AND withdrawn_at IS NULL`, id)
+		if err != nil {
+			return mapErr(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			if _, err := getEntry(ctx, tx, id); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: entry is already withdrawn", store.ErrConflict)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE entry_drivers SET withdrawn_at = now() WHERE entry_id = $1`, id); err != nil {
+			return err
+		}
+
+		entry, err = getEntry(ctx, tx, id)
+		return err
+	})
+
+	return entry, err
+}
+
+func (r *TelemetryRepo) ListEntries(ctx context.Context, f *telemetry.EntryFilter) ([]*telemetry.Entry, error) {
+	var c conditions
+	c.add("en.event_id = ?", f.EventID)
+	if f.ClassID != uuid.Nil {
+		c.add("en.class_id = ?", f.ClassID)
+	}
+	if f.DriverID != uuid.Nil {
+		c.add("EXISTS (SELECT 1 FROM entry_drivers ed WHERE ed.entry_id = en.id AND ed.driver_id = ?)", f.DriverID)
+	}
+	if f.TeamID != uuid.Nil {
+		c.add("en.team_id = ?", f.TeamID)
+	}
+	if !f.IncludeWithdrawn {
+		c.where = append(c.where, "en.withdrawn_at IS NULL")
+	}
+	q, args := c.page(entryQuery, "en", f.Page)
+
+	var dtos []entryDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	entries := make([]*telemetry.Entry, len(dtos))
+	for i := range dtos {
+		entries[i] = dtos[i].toEntry()
+	}
+
+	return entries, nil
+}
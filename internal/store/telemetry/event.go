@@ -0,0 +1,297 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+const eventQuery = `
+	SELECT e.id, e.title,
+		COALESCE((SELECT array_agg(ec.class_id ORDER BY ec.class_id)::text FROM event_classes ec WHERE ec.event_id = e.id), '{}') AS classes,
+		e.state, e.picture, e.track_id, e.laps, e.starts_at, e.ends_at, e.is_high_reward,
+		COALESCE((SELECT json_object_agg(ec.class_id, ec.max_entries)::text FROM event_classes ec WHERE ec.event_id = e.id AND ec.max_entries IS NOT NULL), '{}') AS entry_limits,
+		e.registration_opens_at, e.registration_closes_at, e.version
+	FROM events e`
+
+func (r *TelemetryRepo) GetEvent(ctx context.Context, id uuid.UUID) (*telemetry.Event, error) {
+	return getEvent(ctx, r.db, id)
+}
+
+func getEvent(ctx context.Context, q sqlx.QueryerContext, id uuid.UUID) (*telemetry.Event, error) {
+	var dto eventDTO
+	if err := sqlx.GetContext(ctx, q, &dto, eventQuery+` WHERE e.id = $1`, id); err != nil {
+		return nil, mapErr(err)
+	}
+
+	events, err := loadEvents(ctx, q, []eventDTO{dto})
+	if err != nil {
+		return nil, err
+	}
+
+	return events[0], nil
+}
+
+func (r *TelemetryRepo) ListEvents(ctx context.Context, f *telemetry.EventFilter) ([]*telemetry.Event, error) {
+	var c conditions
+	if f.State != "" {
+		c.add("e.state = ?", f.State)
+	}
+	if !f.StartsAfter.IsZero() {
+		c.add("e.starts_at >= ?", f.StartsAfter)
+	}
+	if !f.StartsBefore.IsZero() {
+		c.add("e.starts_at < ?", f.StartsBefore)
+	}
+	if !f.EndsAfter.IsZero() {
+		c.add("e.ends_at >= ?", f.EndsAfter)
+	}
+	if !f.EndsBefore.IsZero() {
+		c.add("e.ends_at < ?", f.EndsBefore)
+	}
+	if f.TrackID != uuid.Nil {
+		c.add("e.track_id = ?", f.TrackID)
+	}
+	if f.ClassID != uuid.Nil {
+		c.add("EXISTS (SELECT 1 FROM event_classes ec WHERE ec.event_id = e.id AND ec.class_id = ?)", f.ClassID)
+	}
+	if f.IsHighReward != nil {
+		c.add("e.is_high_reward = ?", *f.IsHighReward)
+	}
+	if !f.RegistrationOpenAt.IsZero() {
+		c.add("e.registration_opens_at <= ?", f.RegistrationOpenAt)
+		c.add("(e.registration_closes_at IS NULL OR e.registration_closes_at > ?)", f.RegistrationOpenAt)
+	}
+	if !f.RegistrationClosedAt.IsZero() {
+		c.add("e.registration_closes_at <= ?", f.RegistrationClosedAt)
+	}
+	q, args := c.page(eventQuery, "e", f.Page)
+
+	var dtos []eventDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return loadEvents(ctx, r.db, dtos)
+}
+
+// loadEvents fetches the tracks and classes of the events, each of them once
+func loadEvents(ctx context.Context, q sqlx.QueryerContext, dtos []eventDTO) ([]*telemetry.Event, error) {
+	var trackIDs, classIDs uuidArray
+	for _, dto := range dtos {
+		trackIDs = append(trackIDs, dto.TrackID)
+		classIDs = append(classIDs, dto.Classes...)
+	}
+
+	tracks, err := getTracks(ctx, q, unique(trackIDs))
+	if err != nil {
+		return nil, err
+	}
+	classes, err := getClasses(ctx, q, unique(classIDs))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]*telemetry.Class, len(classes))
+	for _, c := range classes {
+		byID[c.ID] = c
+	}
+
+	events := make([]*telemetry.Event, len(dtos))
+	for i, dto := range dtos {
+		var limits map[uuid.UUID]uint
+		if err := json.Unmarshal([]byte(dto.EntryLimits), &limits); err != nil {
+			return nil, err
+		}
+		if len(limits) == 0 {
+			limits = nil
+		}
+
+		events[i] = &telemetry.Event{
+			ID:           dto.ID,
+			Title:        dto.Title,
+			Classes:      make([]*telemetry.Class, 0, len(dto.Classes)),
+			State:        dto.State,
+			Picture:      dto.Picture,
+			Track:        tracks[dto.TrackID],
+			Laps:         dto.Laps,
+			StartsAt:     dto.StartsAt,
+			EndsAt:       dto.EndsAt,
+			IsHighReward: dto.IsHighReward,
+
+			EntryLimits:          limits,
+			RegistrationOpensAt:  dto.RegistrationOpensAt,
+			RegistrationClosesAt: dto.RegistrationClosesAt,
+			Version:              dto.Version,
+		}
+		for _, id := range dto.Classes {
+			events[i].Classes = append(events[i].Classes, byID[id])
+		}
+	}
+
+	return events, nil
+}
+
+func (r *TelemetryRepo) CreateEvent(ctx context.Context, p *telemetry.EventParams) (*telemetry.Event, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var event *telemetry.Event
+	err = r.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO events (
+				id, title, state, picture, track_id, laps, starts_at, ends_at, is_high_reward,
+				registration_opens_at, registration_closes_at
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			id, p.Title, telemetry.EventDraft, p.Picture, p.TrackID, p.Laps, p.StartsAt, p.EndsAt, p.IsHighReward,
+			p.RegistrationOpensAt, p.RegistrationClosesAt,
+		); err != nil {
+			return mapErr(err)
+		}
+		if err := setClasses(ctx, tx, id, p.Classes, p.EntryLimits); err != nil {
+			return err
+		}
+
+		event, err = getEvent(ctx, tx, id)
+		return err
+	})
+
+	return event, err
+}
+
+// UpdateEvent edits an event that didn't go live yet, ErrConflict if it did or if a class has more entries than
+// its new limit.
+// The event is locked while it's edited, it can't go live meanwhile.
+func (r *TelemetryRepo) UpdateEvent(
+	ctx context.Context, id uuid.UUID, p *telemetry.EventParams, version telemetry.Version,
+) (*telemetry.Event, error) {
+	var event *telemetry.Event
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		var current struct {
+			State   telemetry.EventState `db:"state"`
+			Version telemetry.Version    `db:"version"`
+		}
+		if err := tx.GetContext(ctx, &current, `SELECT state, version FROM events WHERE id = $1 FOR UPDATE`, id); err != nil {
+			return mapErr(err)
+		}
+		if version != 0 && current.Version != version {
+			return store.ErrPreconditionFailed
+		}
+		if !current.State.Editable() {
+			return fmt.Errorf(
+				"%w: event is %s, only events that didn't go live can be edited", store.ErrConflict, current.State,
+			)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE events SET
+				title = $2, picture = $3, track_id = $4, laps = $5,
+				starts_at = $6, ends_at = $7, is_high_reward = $8,
+				registration_opens_at = $9, registration_closes_at = $10,
+				version = version + 1
+			WHERE id = $1`,
+			id, p.Title, p.Picture, p.TrackID, p.Laps, p.StartsAt, p.EndsAt, p.IsHighReward,
+			p.RegistrationOpensAt, p.RegistrationClosesAt,
+		); err != nil {
+			return mapErr(err)
+		}
+
+		// the classes stay locked by setClasses, entries counted here can't be joined by another one
+		if err := setClasses(ctx, tx, id, p.Classes, p.EntryLimits); err != nil {
+			return err
+		}
+		var full uuidArray
+		if err := tx.GetContext(ctx, &full, `
+			SELECT COALESCE(array_agg(ec.class_id ORDER BY ec.class_id)::text, '{}')
+			FROM event_classes ec
+			WHERE ec.event_id = $1 AND ec.max_entries < (
+				SELECT count(*) FROM entries en
+				WHERE en.event_id = ec.event_id AND en.class_id = ec.class_id AND en.withdrawn_at IS NULL
+			)`,
+			id,
+		); err != nil {
+			return err
+		}
+		if len(full) > 0 {
+			return fmt.Errorf("%w: classes %v have more entries than their new limit", store.ErrConflict, []uuid.UUID(full))
+		}
+
+		var err error
+		event, err = getEvent(ctx, tx, id)
+		return err
+	})
+
+	return event, err
+}
+
+func (r *TelemetryRepo) SetEventState(ctx context.Context, id uuid.UUID, from, to telemetry.EventState) (*telemetry.Event, error) {
+	var event *telemetry.Event
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE events SET state = $3, version = version + 1 WHERE id = $1 AND state = $2`, id, from, to)
+		if err != nil {
+			return mapErr(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// either there's no such event or something else moved it first
+			if _, err := getEvent(ctx, tx, id); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: event is not %s anymore", store.ErrConflict, from)
+		}
+
+		event, err = getEvent(ctx, tx, id)
+		return err
+	})
+
+	return event, err
+}
+
+// setClasses makes classes the classes of the event with their entry limits.
+// ErrNotFound if one of them doesn't exist, ErrConflict if one taken off the event has entries.
+func setClasses(ctx context.Context, tx *sqlx.Tx, eventID uuid.UUID, classes []uuid.UUID, limits map[uuid.UUID]uint) error {
+	ids := uuidArray(unique(classes))
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM event_classes WHERE event_id = $1 AND class_id <> ALL($2::text::uuid[])`,
+		eventID, ids,
+	); err != nil {
+		return mapDeleteErr(err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if limits == nil {
+		// "null" isn't an object json_each_text can go through
+		limits = map[uuid.UUID]uint{}
+	}
+	bs, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO event_classes (event_id, class_id, max_entries)
+		SELECT $1, c.id, l.value::integer
+		FROM unnest($2::text::uuid[]) AS c (id)
+		LEFT JOIN json_each_text($3::text::json) AS l ON l.key::uuid = c.id
+		ON CONFLICT (event_id, class_id) DO UPDATE SET max_entries = EXCLUDED.max_entries`,
+		eventID, ids, string(bs),
+	); err != nil {
+		return mapErr(err)
+	}
+
+	return nil
+}
+
+// DeleteEvent deletes an event, its links to classes and its entries go with it.
+func (r *TelemetryRepo) DeleteEvent(ctx context.Context, id uuid.UUID, version telemetry.Version) error {
+	return deleteByID(ctx, r.db, "events", id, version)
+}
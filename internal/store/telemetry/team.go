@@ -0,0 +1,159 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+)
+
+const teamQuery = `
+	SELECT t.id, t.name,
+		COALESCE((
+			SELECT json_agg(json_build_object('role', m.role, 'driver_id', m.driver_id, 'identity', m.identity)
+				ORDER BY m.role, m.driver_id, m.identity)::text
+			FROM team_members m WHERE m.team_id = t.id
+		), '[]') AS members,
+		t.version
+	FROM teams t`
+
+func (d *teamDTO) toTeam() (*telemetry.Team, error) {
+	t := &telemetry.Team{ID: d.ID, Name: d.Name, Version: d.Version}
+	if err := json.Unmarshal([]byte(d.Members), &t.Members); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (r *TelemetryRepo) GetTeam(ctx context.Context, id uuid.UUID) (*telemetry.Team, error) {
+	return getTeam(ctx, r.db, id)
+}
+
+func getTeam(ctx context.Context, q sqlx.QueryerContext, id uuid.UUID) (*telemetry.Team, error) {
+	var dto teamDTO
+	if err := sqlx.GetContext(ctx, q, &dto, teamQuery+` WHERE t.id = $1`, id); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toTeam()
+}
+
+// CreateTeam creates a team with its members, ErrNotFound if one of the drivers doesn't exist.
+func (r *TelemetryRepo) CreateTeam(ctx context.Context, p *telemetry.TeamParams) (*telemetry.Team, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var team *telemetry.Team
+	err = r.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO teams (id, name) VALUES ($1, $2)`, id, p.Name); err != nil {
+			return mapErr(err)
+		}
+		if err := setMembers(ctx, tx, id, p.Members); err != nil {
+			return err
+		}
+
+		team, err = getTeam(ctx, tx, id)
+		return err
+	})
+
+	return team, err
+}
+
+// UpdateTeam renames a team and replaces its members.
+func (r *TelemetryRepo) UpdateTeam(
+	ctx context.Context, id uuid.UUID, p *telemetry.TeamParams, version telemetry.Version,
+) (*telemetry.Team, error) {
+	var team *telemetry.Team
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE teams SET name = $2, version = version + 1 WHERE id = $1 AND `+atVersion(3),
+			id, p.Name, version,
+		)
+		if err != nil {
+			return mapErr(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return notWritten(ctx, tx, "teams", id)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM team_members WHERE team_id = $1`, id); err != nil {
+			return err
+		}
+		if err := setMembers(ctx, tx, id, p.Members); err != nil {
+			return err
+		}
+
+		team, err = getTeam(ctx, tx, id)
+		return err
+	})
+
+	return team, err
+}
+
+// setMembers adds the members to the team
+func setMembers(ctx context.Context, tx *sqlx.Tx, teamID uuid.UUID, members []*telemetry.TeamMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	bs, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO team_members (team_id, role, driver_id, identity)
+		SELECT $1, m.role, m.driver_id, NULLIF(m.identity, '')
+		FROM json_to_recordset($2::text::json) AS m (role text, driver_id uuid, identity text)`,
+		teamID, string(bs),
+	); err != nil {
+		return mapErr(err)
+	}
+
+	return nil
+}
+
+// DeleteTeam deletes a team and its members, ErrConflict if it owns entries.
+func (r *TelemetryRepo) DeleteTeam(ctx context.Context, id uuid.UUID, version telemetry.Version) error {
+	return deleteByID(ctx, r.db, "teams", id, version)
+}
+
+func (r *TelemetryRepo) ListTeams(ctx context.Context, f *telemetry.TeamFilter) ([]*telemetry.Team, error) {
+	var c conditions
+	if f.Name != "" {
+		c.add("t.name ILIKE ?", contains(f.Name))
+	}
+	if f.Identity != "" {
+		c.add(`EXISTS (
+			SELECT 1 FROM team_members m LEFT JOIN drivers d ON d.id = m.driver_id
+			WHERE m.team_id = t.id AND (m.identity = ? OR d.identity = ?))`,
+			f.Identity, f.Identity,
+		)
+	}
+	if f.DriverID != uuid.Nil {
+		c.add("EXISTS (SELECT 1 FROM team_members m WHERE m.team_id = t.id AND m.driver_id = ?)", f.DriverID)
+	}
+	q, args := c.page(teamQuery, "t", f.Page)
+
+	var dtos []teamDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	teams := make([]*telemetry.Team, len(dtos))
+	for i := range dtos {
+		t, err := dtos[i].toTeam()
+		if err != nil {
+			return nil, err
+		}
+		teams[i] = t
+	}
+
+	return teams, nil
+}
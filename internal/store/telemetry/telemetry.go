@@ -1,43 +1,272 @@
-package telemtry
+package telemetry
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+	"github.com/pmoieni/project-racer-server/internal/store"
 )
 
+var _ telemetry.TelemetryRepo = (*TelemetryRepo)(nil)
+
 type TelemetryRepo struct {
 	db *sqlx.DB
 }
 
+func NewTelemetryRepo(db *sqlx.DB) *TelemetryRepo {
+	return &TelemetryRepo{db: db}
+}
+
 type trackDTO struct {
-	ID     uuid.UUID `db:"id"`
-	Name   string    `db:"name"`
-	Layout string    `db:"layout"`
+	ID   uuid.UUID `db:"id"`
+	Name string    `db:"name"`
+	// the geometry as JSON, NULL until the track is surveyed
+	Geometry sql.NullString    `db:"geometry"`
+	Version  telemetry.Version `db:"version"`
 }
 
 type carDTO struct {
 	ID      uuid.UUID `db:"id"`
 	Name    string    `db:"name"`
 	ClassID uuid.UUID `db:"class_id"`
+	// joined from classes
+	ClassTitle string            `db:"class_title"`
+	Version    telemetry.Version `db:"version"`
 }
 
 type classDTO struct {
-	ID    uuid.UUID   `db:"id"`
-	Title string      `db:"title"`
-	Cars  []uuid.UUID `db:"cars"`
+	ID      uuid.UUID         `db:"id"`
+	Title   string            `db:"title"`
+	Cars    uuidArray         `db:"cars"`
+	Version telemetry.Version `db:"version"`
 }
 
 type eventDTO struct {
-	ID           uuid.UUID   `db:"id"`
-	Title        string      `db:"title"`
-	Classes      []uuid.UUID `db:"classes"`
-	State        string      `db:"state"`
-	Picture      string      `db:"picture`
-	TrackID      uuid.UUID   `db:"track_id"`
-	Laps         uint        `db:"laps"`
-	StartsAt     time.Time   `db:"starts_at"`
-	EndsAt       time.Time   `db:"ends_at"`
-	IsHighReward bool        `db:"is_high_reward"`
+	ID           uuid.UUID            `db:"id"`
+	Title        string               `db:"title"`
+	Classes      uuidArray            `db:"classes"`
+	State        telemetry.EventState `db:"state"`
+	Picture      string               `db:"picture"`
+	TrackID      uuid.UUID            `db:"track_id"`
+	Laps         uint                 `db:"laps"`
+	StartsAt     time.Time            `db:"starts_at"`
+	EndsAt       time.Time            `db:"ends_at"`
+	IsHighReward bool                 `db:"is_high_reward"`
+	// a JSON object of the limits by class ID
+	EntryLimits          string            `db:"entry_limits"`
+	RegistrationOpensAt  *time.Time        `db:"registration_opens_at"`
+	RegistrationClosesAt *time.Time        `db:"registration_closes_at"`
+	Version              telemetry.Version `db:"version"`
+}
+
+type driverDTO struct {
+	ID          uuid.UUID         `db:"id"`
+	DisplayName string            `db:"display_name"`
+	Country     string            `db:"country"`
+	Number      uint              `db:"number"`
+	Identity    sql.NullString    `db:"identity"`
+	Version     telemetry.Version `db:"version"`
+}
+
+type teamDTO struct {
+	ID   uuid.UUID `db:"id"`
+	Name string    `db:"name"`
+	// a JSON array of the members
+	Members string            `db:"members"`
+	Version telemetry.Version `db:"version"`
+}
+
+type entryDTO struct {
+	ID      uuid.UUID `db:"id"`
+	EventID uuid.UUID `db:"event_id"`
+	ClassID uuid.UUID `db:"class_id"`
+	CarID   uuid.UUID `db:"car_id"`
+	// joined from classes and cars
+	ClassTitle  string     `db:"class_title"`
+	CarName     string     `db:"car_name"`
+	Drivers     uuidArray  `db:"drivers"`
+	TeamID      *uuid.UUID `db:"team_id"`
+	EnteredAt   time.Time  `db:"entered_at"`
+	WithdrawnAt *time.Time `db:"withdrawn_at"`
+}
+
+// inTx runs fn in a transaction, committed if fn succeeds
+func (r *TelemetryRepo) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Postgres error codes, https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// mapErr turns what Postgres reports into the store's errors.
+// A missing foreign key on insert or update means a referenced entity doesn't exist.
+func mapErr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			return fmt.Errorf("%w: %s", store.ErrConflict, pgErr.Detail)
+		case foreignKeyViolation:
+			return fmt.Errorf("%w: %s", store.ErrNotFound, pgErr.Detail)
+		}
+	}
+
+	return err
+}
+
+// mapDeleteErr is mapErr for deletes, where a foreign key violation means the entity is still referenced
+func mapDeleteErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return fmt.Errorf("%w: %s", store.ErrConflict, pgErr.Detail)
+	}
+
+	return mapErr(err)
+}
+
+// deleteByID deletes the row of table with id at version, any version if it's 0.
+// ErrNotFound if there is none, ErrPreconditionFailed if it's at another version.
+func deleteByID(ctx context.Context, q sqlx.ExtContext, table string, id uuid.UUID, version telemetry.Version) error {
+	res, err := q.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = $1 AND "+atVersion(2), id, version)
+	if err != nil {
+		return mapDeleteErr(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notWritten(ctx, q, table, id)
+	}
+
+	return nil
+}
+
+// atVersion is the condition of a write requiring the version in parameter n, which 0 doesn't restrict
+func atVersion(n int) string {
+	return fmt.Sprintf("($%d::bigint = 0 OR version = $%[1]d)", n)
+}
+
+// notWritten tells why a write of the row of table with id touched nothing, ErrNotFound if there is no such row and
+// ErrPreconditionFailed if it's at another version than the one required
+func notWritten(ctx context.Context, q sqlx.QueryerContext, table string, id uuid.UUID) error {
+	var found bool
+	if err := sqlx.GetContext(ctx, q, &found, `SELECT true FROM `+table+` WHERE id = $1`, id); errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return store.ErrPreconditionFailed
+}
+
+// conditions build the WHERE clause of a list query, with ? placeholders
+type conditions struct {
+	where []string
+	args  []any
+}
+
+func (c *conditions) add(cond string, args ...any) {
+	c.where = append(c.where, cond)
+	c.args = append(c.args, args...)
+}
+
+// page filters base, a SELECT over table alias t, and keeps the requested page of it in ID order
+func (c *conditions) page(base, t string, p telemetry.Page) (string, []any) {
+	if p.After != uuid.Nil {
+		c.add(t+".id > ?", p.After)
+	}
+
+	q := base
+	if len(c.where) > 0 {
+		q += " WHERE " + strings.Join(c.where, " AND ")
+	}
+	q += " ORDER BY " + t + ".id"
+	args := c.args
+	if p.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, p.Limit)
+	}
+
+	return sqlx.Rebind(sqlx.DOLLAR, q), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// contains is a LIKE pattern matching s anywhere
+func contains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// uuidArray is a Postgres uuid[] in its text form, database/sql has no array support of its own.
+// Queries cast it explicitly, ::text on the way out and ::text::uuid[] on the way in.
+type uuidArray []uuid.UUID
+
+func (a *uuidArray) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("uuidArray: can't scan %T", src)
+	}
+
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	if s == "" {
+		*a = uuidArray{}
+		return nil
+	}
+
+	parts := strings.Split(s, ",")
+	ids := make(uuidArray, 0, len(parts))
+	for _, p := range parts {
+		id, err := uuid.Parse(p)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	*a = ids
+
+	return nil
+}
+
+func (a uuidArray) Value() (driver.Value, error) {
+	ids := make([]string, len(a))
+	for i, id := range a {
+		ids[i] = id.String()
+	}
+
+	return "{" + strings.Join(ids, ",") + "}", nil
 }
@@ -0,0 +1,237 @@
+package telemetry
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+func TestMapErr(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name string
+		err  error
+		// what mapErr and mapDeleteErr wrap it in
+		want, wantDelete error
+	}{
+		{"no rows", sql.ErrNoRows, store.ErrNotFound, store.ErrNotFound},
+		{"wrapped no rows", fmt.Errorf("get: %w", sql.ErrNoRows), store.ErrNotFound, store.ErrNotFound},
+		{"unique violation", &pgconn.PgError{Code: uniqueViolation}, store.ErrConflict, store.ErrConflict},
+		// a write pointing at what doesn't exist, a delete of what's still pointed at
+		{"foreign key violation", &pgconn.PgError{Code: foreignKeyViolation}, store.ErrNotFound, store.ErrConflict},
+		{"other postgres error", &pgconn.PgError{Code: "42P01"}, nil, nil},
+		{"other error", other, other, other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct {
+				name string
+				fn   func(error) error
+				want error
+			}{
+				{"mapErr", mapErr, tt.want},
+				{"mapDeleteErr", mapDeleteErr, tt.wantDelete},
+			} {
+				got := c.fn(tt.err)
+				if c.want == nil {
+					// passed on as is
+					c.want = tt.err
+				}
+				if !errors.Is(got, c.want) {
+					t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUUIDArray(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name string
+		src  any
+		want uuidArray
+		ok   bool
+	}{
+		{"null", nil, nil, true},
+		{"empty", "{}", uuidArray{}, true},
+		{"text", "{" + a.String() + "," + b.String() + "}", uuidArray{a, b}, true},
+		{"bytes", []byte("{" + a.String() + "}"), uuidArray{a}, true},
+		{"not a uuid", "{nope}", nil, false},
+		{"not text", 42, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got uuidArray
+			err := got.Scan(tt.src)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v", err)
+			}
+			if !tt.ok {
+				return
+			}
+			if (got == nil) != (tt.want == nil) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			// what's written back reads the same
+			v, err := got.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back uuidArray
+			if err := back.Scan(v); err != nil || fmt.Sprint(back) != fmt.Sprint(got) {
+				t.Errorf("%v read back as %v, %v", v, back, err)
+			}
+		})
+	}
+}
+
+func TestToTrack(t *testing.T) {
+	tests := []struct {
+		name     string
+		geometry sql.NullString
+		placed   bool
+		ok       bool
+	}{
+		{"not surveyed", sql.NullString{}, false, true},
+		{"surveyed", sql.NullString{String: `{"width": 10, "centreline": [{"x": 0, "y": 0}, {"x": 100, "y": 0}, {"x": 0, "y": 0}]}`, Valid: true}, true, true},
+		{"corrupt", sql.NullString{String: `{"width": `, Valid: true}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &trackDTO{ID: uuid.New(), Name: "Monza", Geometry: tt.geometry, Version: 3}
+			track, err := d.toTrack()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v", err)
+			}
+			if !tt.ok {
+				return
+			}
+			if track.ID != d.ID || track.Name != d.Name || track.Version != d.Version {
+				t.Errorf("got %+v", track)
+			}
+			if (track.Geometry != nil) != tt.placed {
+				t.Errorf("got geometry %+v", track.Geometry)
+			}
+		})
+	}
+}
+
+// the members are aggregated as JSON by the query, a team without any gets an empty list
+func TestToTeam(t *testing.T) {
+	driver := uuid.New()
+
+	tests := []struct {
+		name    string
+		members string
+		want    int
+		ok      bool
+	}{
+		{"no members", `[]`, 0, true},
+		{
+			"members",
+			`[{"role": "manager", "driver_id": null, "identity": "boss"}, {"role": "driver", "driver_id": "` + driver.String() + `", "identity": null}]`,
+			2, true,
+		},
+		{"corrupt", `[{`, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			team, err := (&teamDTO{ID: uuid.New(), Name: "Team", Members: tt.members}).toTeam()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v", err)
+			}
+			if !tt.ok {
+				return
+			}
+			if len(team.Members) != tt.want {
+				t.Fatalf("got %d members, want %d", len(team.Members), tt.want)
+			}
+			if tt.want > 0 {
+				if m := team.Members[1]; m.Role != telemetry.TeamDriver || m.DriverID == nil || *m.DriverID != driver {
+					t.Errorf("got %+v", m)
+				}
+			}
+		})
+	}
+}
+
+func TestAtVersion(t *testing.T) {
+	if got, want := atVersion(3), "($3::bigint = 0 OR version = $3)"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestConditionsPage(t *testing.T) {
+	const base = "SELECT t.id FROM tracks t"
+	after := uuid.New()
+
+	tests := []struct {
+		name  string
+		conds func(c *conditions)
+		page  telemetry.Page
+		want  string
+		args  []any
+	}{
+		{"everything", func(*conditions) {}, telemetry.Page{}, base + " ORDER BY t.id", nil},
+		{
+			"first page", func(*conditions) {}, telemetry.Page{Limit: 10},
+			base + " ORDER BY t.id LIMIT $1", []any{10},
+		},
+		{
+			"filtered page after a cursor",
+			func(c *conditions) {
+				c.add("t.name ILIKE ?", "%monza%")
+				c.add("t.version > ? AND t.version < ?", 1, 5)
+			},
+			telemetry.Page{After: after, Limit: 10},
+			base + " WHERE t.name ILIKE $1 AND t.version > $2 AND t.version < $3 AND t.id > $4 ORDER BY t.id LIMIT $5",
+			[]any{"%monza%", 1, 5, after, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c conditions
+			tt.conds(&c)
+			q, args := c.page(base, "t", tt.page)
+			if q != tt.want {
+				t.Errorf("got %s\nwant %s", q, tt.want)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.args) {
+				t.Errorf("got args %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+// what's searched for matches literally, LIKE's wildcards included
+func TestContains(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"monza", "%monza%"},
+		{"100%", `%100\%%`},
+		{"a_b", `%a\_b%`},
+		{`C:\`, `%C:\\%`},
+	}
+
+	for _, tt := range tests {
+		if got := contains(tt.s); got != tt.want {
+			t.Errorf("contains(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+)
+
+const trackColumns = `id, name, geometry::text AS geometry, version`
+
+func (d *trackDTO) toTrack() (*telemetry.Track, error) {
+	t := &telemetry.Track{ID: d.ID, Name: d.Name, Version: d.Version}
+	if d.Geometry.Valid {
+		if err := json.Unmarshal([]byte(d.Geometry.String), &t.Geometry); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// geometryValue is the geometry of p as the JSON stored, nil for none
+func geometryValue(p *telemetry.TrackParams) (any, error) {
+	if p.Geometry == nil {
+		return nil, nil
+	}
+	bs, err := json.Marshal(p.Geometry)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(bs), nil
+}
+
+func (r *TelemetryRepo) GetTrack(ctx context.Context, id uuid.UUID) (*telemetry.Track, error) {
+	return getTrack(ctx, r.db, id)
+}
+
+func getTrack(ctx context.Context, q sqlx.QueryerContext, id uuid.UUID) (*telemetry.Track, error) {
+	var dto trackDTO
+	if err := sqlx.GetContext(ctx, q, &dto, `SELECT `+trackColumns+` FROM tracks WHERE id = $1`, id); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toTrack()
+}
+
+func (r *TelemetryRepo) CreateTrack(ctx context.Context, p *telemetry.TrackParams) (*telemetry.Track, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	geometry, err := geometryValue(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var dto trackDTO
+	if err := r.db.GetContext(ctx, &dto, `
+		INSERT INTO tracks (id, name, geometry) VALUES ($1, $2, $3::text::jsonb)
+		RETURNING `+trackColumns,
+		id, p.Name, geometry,
+	); err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toTrack()
+}
+
+func (r *TelemetryRepo) UpdateTrack(
+	ctx context.Context, id uuid.UUID, p *telemetry.TrackParams, version telemetry.Version,
+) (*telemetry.Track, error) {
+	geometry, err := geometryValue(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var dto trackDTO
+	if err := r.db.GetContext(ctx, &dto, `
+		UPDATE tracks SET name = $2, geometry = $3::text::jsonb, version = version + 1
+		WHERE id = $1 AND `+atVersion(4)+`
+		RETURNING `+trackColumns,
+		id, p.Name, geometry, version,
+	); errors.Is(err, sql.ErrNoRows) {
+		return nil, notWritten(ctx, r.db, "tracks", id)
+	} else if err != nil {
+		return nil, mapErr(err)
+	}
+
+	return dto.toTrack()
+}
+
+func (r *TelemetryRepo) DeleteTrack(ctx context.Context, id uuid.UUID, version telemetry.Version) error {
+	return deleteByID(ctx, r.db, "tracks", id, version)
+}
+
+func (r *TelemetryRepo) ListTracks(ctx context.Context, f *telemetry.TrackFilter) ([]*telemetry.Track, error) {
+	var c conditions
+	if f.Name != "" {
+		c.add("t.name ILIKE ?", contains(f.Name))
+	}
+	q, args := c.page(`SELECT t.id, t.name, t.geometry::text AS geometry FROM tracks t`, "t", f.Page)
+
+	var dtos []trackDTO
+	if err := r.db.SelectContext(ctx, &dtos, q, args...); err != nil {
+		return nil, mapErr(err)
+	}
+
+	tracks := make([]*telemetry.Track, len(dtos))
+	for i := range dtos {
+		t, err := dtos[i].toTrack()
+		if err != nil {
+			return nil, err
+		}
+		tracks[i] = t
+	}
+
+	return tracks, nil
+}
+
+// getTracks loads the tracks with ids by ID
+func getTracks(ctx context.Context, q sqlx.QueryerContext, ids uuidArray) (map[uuid.UUID]*telemetry.Track, error) {
+	var dtos []trackDTO
+	if err := sqlx.SelectContext(ctx, q, &dtos, `SELECT `+trackColumns+` FROM tracks WHERE id = ANY($1::text::uuid[])`, ids); err != nil {
+		return nil, mapErr(err)
+	}
+
+	tracks := make(map[uuid.UUID]*telemetry.Track, len(dtos))
+	for i := range dtos {
+		t, err := dtos[i].toTrack()
+		if err != nil {
+			return nil, err
+		}
+		tracks[dtos[i].ID] = t
+	}
+
+	return tracks, nil
+}
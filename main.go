@@ -1,14 +1,42 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/pmoieni/project-racer-server/internal/net"
 	"github.com/pmoieni/project-racer-server/internal/services/telemetry"
+	"github.com/pmoieni/project-racer-server/internal/store"
+	telemetrystore "github.com/pmoieni/project-racer-server/internal/store/telemetry"
 )
 
 func main() {
-	telemetryService, err := telemetry.New()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[0], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// connections are made on first use, the server starts without a database
+	db, err := store.NewDB(context.Background(), dsn())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the server stops on the same signals
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	auth, err := authenticator()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	telemetryService, err := telemetry.New(ctx, telemetrystore.NewTelemetryRepo(db), auth)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -23,3 +51,15 @@ func main() {
 		log.Fatalf("Could not start the server: %v", err)
 	}
 }
+
+// authenticator verifies the tokens listed in $TOKENS_FILE.
+// Without it every client is an anonymous spectator and the admin endpoints aren't served.
+func authenticator() (telemetry.Authenticator, error) {
+	path := os.Getenv("TOKENS_FILE")
+	if path == "" {
+		log.Print("TOKENS_FILE isn't set, every client is an anonymous spectator")
+		return nil, nil
+	}
+
+	return telemetry.LoadTokens(path)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmoieni/project-racer-server/internal/store"
+)
+
+// the database the flake provisions
+const flakeDSN = "postgres://127.0.0.1:5432/pracer"
+
+// dsn is $DATABASE_URL, or the flake's database if it isn't set
+func dsn() string {
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		return v
+	}
+
+	return flakeDSN
+}
+
+const migrateUsage = `usage: %s migrate [-dsn DSN] <command>
+
+commands:
+  up        apply every pending migration
+  down [N]  revert the last N applied migrations, 1 by default
+  status    list the migrations and when they were applied
+
+The DSN defaults to $DATABASE_URL, then %s.
+`
+
+func migrate(name string, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprintf(fs.Output(), migrateUsage, name, flakeDSN) }
+	url := fs.String("dsn", "", "Postgres connection string")
+	_ = fs.Parse(args)
+
+	if *url == "" {
+		*url = dsn()
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+
+	ctx := context.Background()
+	db, err := store.NewDB(ctx, *url)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := store.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch cmd := fs.Arg(0); cmd {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mig := range done {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to apply")
+		}
+		return err
+	case "down":
+		steps := 1
+		if fs.NArg() > 1 {
+			if steps, err = strconv.Atoi(fs.Arg(1)); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", fs.Arg(1))
+			}
+		}
+		done, err := m.Down(ctx, steps)
+		for _, mig := range done {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}